@@ -0,0 +1,80 @@
+package utreexo
+
+import (
+	"encoding/binary"
+)
+
+// BloomFilter is a simple bloom filter over hashes. It may return false positives but
+// never returns false negatives for hashes that were added.
+type BloomFilter struct {
+	bits      []uint64
+	numBits   uint64
+	numHashes uint8
+}
+
+// NewBloomFilter returns an empty bloom filter with the given number of bits and hash
+// functions. The number of bits and hash functions are set to 1 if they're 0.
+func NewBloomFilter(numBits uint64, numHashes uint8) *BloomFilter {
+	if numBits == 0 {
+		numBits = 1
+	}
+	if numHashes == 0 {
+		numHashes = 1
+	}
+
+	return &BloomFilter{
+		bits:      make([]uint64, (numBits+63)/64),
+		numBits:   numBits,
+		numHashes: numHashes,
+	}
+}
+
+// bitIndex returns the index of the bit for the ith hash function of the given hash.
+//
+// The hashes in the accumulator are already uniformly distributed so the hash functions
+// are derived from the hash itself with double hashing.
+func (b *BloomFilter) bitIndex(hash Hash, i uint8) uint64 {
+	h1 := binary.LittleEndian.Uint64(hash[:8])
+	h2 := binary.LittleEndian.Uint64(hash[8:16])
+
+	return (h1 + uint64(i)*h2) % b.numBits
+}
+
+// Add adds the hash to the filter.
+func (b *BloomFilter) Add(hash Hash) {
+	for i := uint8(0); i < b.numHashes; i++ {
+		idx := b.bitIndex(hash, i)
+		b.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+// MayContain returns true if the hash may have been added to the filter. It returns
+// false only if the hash was definitely not added.
+func (b *BloomFilter) MayContain(hash Hash) bool {
+	for i := uint8(0); i < b.numHashes; i++ {
+		idx := b.bitIndex(hash, i)
+		if b.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TargetsMatchFilter returns true if any of the target hashes may be in the filter. The
+// targetHashes are the hashes of the targets of the proof. It returns false if none of
+// the targets are in the filter and the proof can be skipped by a caller only interested
+// in the hashes in the filter.
+func (p Proof) TargetsMatchFilter(filter *BloomFilter, targetHashes []Hash) bool {
+	if filter == nil {
+		return false
+	}
+
+	for _, hash := range targetHashes {
+		if filter.MayContain(hash) {
+			return true
+		}
+	}
+
+	return false
+}
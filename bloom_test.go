@@ -0,0 +1,61 @@
+package utreexo
+
+import (
+	"testing"
+)
+
+func TestTargetsMatchFilter(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(64, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Watch every 4th leaf.
+	filter := NewBloomFilter(1024, 4)
+	for i := 0; i < len(leaves); i += 4 {
+		filter.Add(leaves[i].Hash)
+	}
+
+	// Every watched hash must match.
+	for i := 0; i < len(leaves); i += 4 {
+		hashes := []Hash{leaves[i+1].Hash, leaves[i].Hash}
+		proof, err := p.Prove(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.TargetsMatchFilter(filter, hashes) {
+			t.Fatalf("expected leaf %d to match the filter", i)
+		}
+	}
+
+	// Unwatched hashes should mostly not match. With 16 hashes in 1024 bits and 4
+	// hash functions, the false positive rate is well below 1%.
+	falsePositives := 0
+	for i := 0; i < len(leaves); i++ {
+		if i%4 == 0 {
+			continue
+		}
+		hashes := []Hash{leaves[i].Hash}
+		proof, err := p.Prove(hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.TargetsMatchFilter(filter, hashes) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 2 {
+		t.Fatalf("got %d false positives out of %d", falsePositives, 48)
+	}
+
+	if (Proof{}).TargetsMatchFilter(filter, nil) {
+		t.Fatalf("expected no targets to not match the filter")
+	}
+	if (Proof{}).TargetsMatchFilter(nil, []Hash{leaves[0].Hash}) {
+		t.Fatalf("expected a nil filter to not match")
+	}
+}
@@ -0,0 +1,231 @@
+package utreexo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// CommitmentEntry is the commitment of the accumulator at a block along with the number
+// of leaves the accumulator had at that block.
+type CommitmentEntry struct {
+	NumLeaves  uint64
+	Commitment Hash
+}
+
+// EncodeCommitmentChain writes the entries to the writer. The number of leaves never
+// decreases between blocks so only the difference from the previous entry is written as
+// a varint. The commitments are written as is.
+//
+// The entries are serialized as:
+// [varint count][varint numLeaves delta][32 byte commitment]...
+func EncodeCommitmentChain(entries []CommitmentEntry, w io.Writer) error {
+	var buf [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(buf[:], uint64(len(entries)))
+	_, err := w.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	prevNumLeaves := uint64(0)
+	for i, entry := range entries {
+		if entry.NumLeaves < prevNumLeaves {
+			return fmt.Errorf("EncodeCommitmentChain fail. Entry %d has %d leaves "+
+				"which is less than the %d leaves of the previous entry",
+				i, entry.NumLeaves, prevNumLeaves)
+		}
+
+		n = binary.PutUvarint(buf[:], entry.NumLeaves-prevNumLeaves)
+		_, err = w.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(entry.Commitment[:])
+		if err != nil {
+			return err
+		}
+
+		prevNumLeaves = entry.NumLeaves
+	}
+
+	return nil
+}
+
+// DecodeCommitmentChain reads the entries that were written with EncodeCommitmentChain.
+func DecodeCommitmentChain(r io.Reader) ([]CommitmentEntry, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		bufReader := bufio.NewReader(r)
+		br, r = bufReader, bufReader
+	}
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't trust the count for the allocation as it could be corrupted.
+	entries := make([]CommitmentEntry, 0, proofPrealloc(count))
+	prevNumLeaves := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if prevNumLeaves+delta < prevNumLeaves {
+			return nil, fmt.Errorf("DecodeCommitmentChain fail. Number of "+
+				"leaves overflowed at entry %d", i)
+		}
+
+		entry := CommitmentEntry{NumLeaves: prevNumLeaves + delta}
+		_, err = io.ReadFull(r, entry.Commitment[:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+
+		prevNumLeaves = entry.NumLeaves
+	}
+
+	return entries, nil
+}
+
+// Checkpoint is the state of the accumulator at a height. It's enough to verify proofs
+// against without the rest of the accumulator.
+type Checkpoint struct {
+	Height    uint64
+	NumLeaves uint64
+	Roots     []Hash
+}
+
+// Checkpoint returns the checkpoint of the current state of the pollard.
+func (p *Pollard) Checkpoint() Checkpoint {
+	return Checkpoint{Height: p.Height(), NumLeaves: p.NumLeaves, Roots: p.GetRoots()}
+}
+
+// WriteTo writes the checkpoint to the writer. Like the commitment log, the height is
+// written as 8 little endian bytes first. It's followed by the numLeaves as 8 little
+// endian bytes and then all the roots. The number of roots isn't written as it's
+// determined by the numLeaves.
+//
+// [8 byte height][8 byte numLeaves][32 byte root]...
+func (c Checkpoint) WriteTo(w io.Writer) (int64, error) {
+	if len(c.Roots) != int(numRoots(c.NumLeaves)) {
+		return 0, fmt.Errorf("Checkpoint.WriteTo fail. Expected %d roots for %d "+
+			"leaves but have %d", numRoots(c.NumLeaves), c.NumLeaves, len(c.Roots))
+	}
+
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], c.Height)
+	binary.LittleEndian.PutUint64(buf[8:], c.NumLeaves)
+	n, err := w.Write(buf[:])
+	totalBytes := int64(n)
+	if err != nil {
+		return totalBytes, err
+	}
+
+	for _, root := range c.Roots {
+		n, err = w.Write(root[:])
+		totalBytes += int64(n)
+		if err != nil {
+			return totalBytes, err
+		}
+	}
+
+	return totalBytes, nil
+}
+
+// LoadCheckpoint reads a checkpoint that was written with Checkpoint.WriteTo.
+func LoadCheckpoint(r io.Reader) (Checkpoint, error) {
+	var buf [16]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	c := Checkpoint{
+		Height:    binary.LittleEndian.Uint64(buf[:8]),
+		NumLeaves: binary.LittleEndian.Uint64(buf[8:]),
+	}
+	c.Roots = make([]Hash, numRoots(c.NumLeaves))
+	for i := range c.Roots {
+		_, err = io.ReadFull(r, c.Roots[i][:])
+		if err != nil {
+			return Checkpoint{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// Verify verifies the proof for the delHashes against the roots of the checkpoint.
+func (c Checkpoint) Verify(delHashes []Hash, proof Proof) error {
+	_, err := Verify(Stump{Roots: c.Roots, NumLeaves: c.NumLeaves}, delHashes, proof)
+	return err
+}
+
+// SerializeRoots writes only the numLeaves and the roots of the pollard to the writer.
+// It's the minimal state needed to create a Stump. The number of roots isn't written as
+// it's determined by the numLeaves.
+//
+// [varint numLeaves][32 byte root]...
+func (p *Pollard) SerializeRoots(w io.Writer) error {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], p.NumLeaves)
+	_, err := w.Write(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	for _, root := range p.Roots {
+		_, err = w.Write(root.data[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// byteReader reads one byte at a time from the underlying reader so that nothing past
+// the requested bytes is consumed from it.
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+// ReadByte reads a single byte from the underlying reader.
+func (b *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(b.r, b.buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return b.buf[0], nil
+}
+
+// DeserializeRoots reads the roots and the numLeaves that were written with
+// SerializeRoots. Nothing past the roots is read from the reader so other data can
+// follow the roots in the same stream.
+func DeserializeRoots(r io.Reader) ([]Hash, uint64, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+
+	numLeaves, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DeserializeRoots fail. %w", err)
+	}
+
+	roots := make([]Hash, numRoots(numLeaves))
+	for i := range roots {
+		_, err = io.ReadFull(r, roots[i][:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("DeserializeRoots fail. %w", err)
+		}
+	}
+
+	return roots, numLeaves, nil
+}
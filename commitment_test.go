@@ -0,0 +1,219 @@
+package utreexo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestCommitmentChain(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewSource(1))
+	entries := make([]CommitmentEntry, 10_000)
+	numLeaves := uint64(0)
+	for i := range entries {
+		numLeaves += uint64(rnd.Intn(5000))
+		entries[i].NumLeaves = numLeaves
+		rnd.Read(entries[i].Commitment[:])
+	}
+
+	var buf bytes.Buffer
+	err := EncodeCommitmentChain(entries, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	naiveSize := len(entries) * CommitmentEntrySize
+	if buf.Len() >= naiveSize {
+		t.Fatalf("expected the encoding to be smaller than %d bytes but got %d",
+			naiveSize, buf.Len())
+	}
+
+	encoded := buf.Bytes()
+	decoded, err := DecodeCommitmentChain(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, decoded) {
+		t.Fatalf("decoded entries don't match the encoded entries")
+	}
+
+	// Truncated data must error.
+	_, err = DecodeCommitmentChain(bytes.NewReader(encoded[:len(encoded)-1]))
+	if err == nil {
+		t.Fatalf("expected an error for truncated data")
+	}
+
+	// An empty chain round trips.
+	buf.Reset()
+	err = EncodeCommitmentChain(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err = DecodeCommitmentChain(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 0 {
+		t.Fatalf("expected no entries but got %d", len(decoded))
+	}
+
+	// The number of leaves can't decrease.
+	err = EncodeCommitmentChain([]CommitmentEntry{{NumLeaves: 2}, {NumLeaves: 1}}, &buf)
+	if err == nil {
+		t.Fatalf("expected an error for a decreasing number of leaves")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	sc := newSimChain(0x07)
+	for b := 0; b < 20; b++ {
+		adds, _, delHashes := sc.NextBlock(8)
+		err := deleteHashes(&p, delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	wroteBytes, err := p.Checkpoint().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wroteBytes != int64(buf.Len()) {
+		t.Fatalf("wrote %d bytes but have %d bytes", wroteBytes, buf.Len())
+	}
+
+	checkpoint, err := LoadCheckpoint(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Checkpoint(), checkpoint) {
+		t.Fatalf("expected checkpoint %v but got %v", p.Checkpoint(), checkpoint)
+	}
+
+	leaves := p.CachedLeaves()
+	delHashes := []Hash{leaves[1], leaves[len(leaves)/2], leaves[len(leaves)-1]}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkpoint.Verify(delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkpoint.Verify(delHashes[:2], Proof{Targets: proof.Targets[:2], Proof: proof.Proof})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid proof")
+	}
+
+	// Truncated checkpoints can't be loaded.
+	buf.Reset()
+	_, err = p.Checkpoint().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadCheckpoint(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		t.Fatalf("expected an error for a truncated checkpoint")
+	}
+}
+
+func TestSerializeRoots(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	sc := newSimChain(0x07)
+	for b := 0; b < 20; b++ {
+		adds, _, delHashes := sc.NextBlock(8)
+		err := deleteHashes(&p, delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := p.SerializeRoots(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var varintBuf [binary.MaxVarintLen64]byte
+	expectedLen := binary.PutUvarint(varintBuf[:], p.NumLeaves) + 32*len(p.Roots)
+	if buf.Len() != expectedLen {
+		t.Fatalf("expected %d bytes but got %d", expectedLen, buf.Len())
+	}
+
+	roots, numLeaves, err := DeserializeRoots(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numLeaves != p.NumLeaves || !reflect.DeepEqual(p.GetRoots(), roots) {
+		t.Fatalf("expected %d leaves and roots:\n%s\ngot %d leaves and roots:\n%s",
+			p.NumLeaves, printHashes(p.GetRoots()), numLeaves, printHashes(roots))
+	}
+
+	stump := Stump{Roots: roots, NumLeaves: numLeaves}
+	leaves := p.CachedLeaves()
+	delHashes := []Hash{leaves[0], leaves[len(leaves)/3], leaves[len(leaves)-2]}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(stump, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncated roots can't be deserialized.
+	buf.Reset()
+	err = p.SerializeRoots(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DeserializeRoots(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v for truncated roots but got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	// Nothing past the roots is read from a reader that's not an io.ByteReader so the
+	// record after the roots can still be read from the same stream.
+	buf.Reset()
+	err = p.SerializeRoots(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := []byte("next record")
+	buf.Write(next)
+	r := struct{ io.Reader }{&buf}
+	roots, numLeaves, err = DeserializeRoots(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numLeaves != p.NumLeaves || !reflect.DeepEqual(p.GetRoots(), roots) {
+		t.Fatalf("expected %d leaves and roots:\n%s\ngot %d leaves and roots:\n%s",
+			p.NumLeaves, printHashes(p.GetRoots()), numLeaves, printHashes(roots))
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, next) {
+		t.Fatalf("expected %q after the roots but got %q", next, rest)
+	}
+}
@@ -43,7 +43,16 @@ func NewMapPollard() MapPollard {
 }
 
 // Modify takes in the additions and deletions and updates the accumulator accordingly.
+// MapPollard doesn't track the values and the TTLs of the leaves so adds with either
+// are rejected with ErrUnsupportedLeafData.
 func (m *MapPollard) Modify(adds []Leaf, delHashes []Hash, proof Proof) error {
+	for i, add := range adds {
+		if add.Value != 0 || add.TTL != 0 {
+			return fmt.Errorf("MapPollard.Modify fail. %w: add %d has a value "+
+				"or a TTL", ErrUnsupportedLeafData, i)
+		}
+	}
+
 	err := m.remove(proof, delHashes)
 	if err != nil {
 		return err
@@ -952,8 +961,17 @@ func (m *MapPollard) Write(w io.Writer) (int, error) {
 	}
 	totalBytes += bytes
 
-	// Write the map elements.
-	for k, v := range m.CachedLeaves {
+	// Write the map elements in the order of their positions so that the same
+	// pollard always serializes to the same bytes.
+	cachedHashes := make([]Hash, 0, len(m.CachedLeaves))
+	for k := range m.CachedLeaves {
+		cachedHashes = append(cachedHashes, k)
+	}
+	slices.SortFunc(cachedHashes, func(a, b Hash) bool {
+		return m.CachedLeaves[a] < m.CachedLeaves[b]
+	})
+	for _, k := range cachedHashes {
+		v := m.CachedLeaves[k]
 		written, err := w.Write(k[:])
 		if err != nil {
 			return totalBytes, err
@@ -976,9 +994,16 @@ func (m *MapPollard) Write(w io.Writer) (int, error) {
 	}
 	totalBytes += bytes
 
-	// Write the node elements.
+	// Write the node elements in the order of their positions.
+	positions := make([]uint64, 0, len(m.Nodes))
+	for k := range m.Nodes {
+		positions = append(positions, k)
+	}
+	slices.Sort(positions)
+
 	var leafBuf [33]byte
-	for k, v := range m.Nodes {
+	for _, k := range positions {
+		v := m.Nodes[k]
 		binary.LittleEndian.PutUint64(buf[:], k)
 		bytes, err := w.Write(buf[:])
 		if err != nil {
@@ -987,6 +1012,7 @@ func (m *MapPollard) Write(w io.Writer) (int, error) {
 		totalBytes += bytes
 
 		copy(leafBuf[:32], v.Hash[:])
+		leafBuf[32] = 0
 		if v.Remember {
 			leafBuf[32] = 1
 		}
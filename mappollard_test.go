@@ -3,6 +3,7 @@ package utreexo
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
@@ -424,3 +425,97 @@ func FuzzMapPollardWriteAndRead(f *testing.F) {
 		}
 	})
 }
+
+func TestMapPollardWriteDeterministic(t *testing.T) {
+	t.Parallel()
+
+	leaves := makeTestLeaves(16, 0)
+	for i := range leaves {
+		leaves[i].Remember = i%3 != 0
+	}
+	delHashes := []Hash{leaves[1].Hash, leaves[4].Hash, leaves[10].Hash}
+
+	modify := func(m *MapPollard, adds []Leaf, delHashes []Hash) {
+		t.Helper()
+		proof, err := m.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Add all the leaves in one block and then delete.
+	m1 := NewMapPollard()
+	modify(&m1, leaves, nil)
+	modify(&m1, nil, delHashes)
+
+	// Add the leaves over multiple blocks and delete along with the last additions.
+	m2 := NewMapPollard()
+	modify(&m2, leaves[:5], nil)
+	modify(&m2, leaves[5:12], nil)
+	modify(&m2, leaves[12:], delHashes)
+
+	if !reflect.DeepEqual(m1.GetRoots(), m2.GetRoots()) {
+		t.Fatalf("expected the same roots")
+	}
+
+	var buf1, buf2 bytes.Buffer
+	_, err := m1.Write(&buf1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m2.Write(&buf2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Fatalf("expected the same serialization for the same state")
+	}
+
+	// Writing the same pollard again gives the same bytes.
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		_, err = m1.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf1.Bytes(), buf.Bytes()) {
+			t.Fatalf("expected the same serialization on write %d", i)
+		}
+	}
+}
+
+func TestMapPollardUnsupportedLeafData(t *testing.T) {
+	t.Parallel()
+
+	m := NewMapPollard()
+	leaves := makeTestLeaves(4, 0)
+	err := m.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := m.GetRoots()
+
+	valued := makeTestLeaves(2, len(leaves))
+	valued[1].Value = 5
+	err = m.Modify(valued, nil, Proof{})
+	if !errors.Is(err, ErrUnsupportedLeafData) {
+		t.Fatalf("expected %v but got %v", ErrUnsupportedLeafData, err)
+	}
+	if m.NumLeaves != uint64(len(leaves)) || !reflect.DeepEqual(beforeRoots, m.GetRoots()) {
+		t.Fatalf("map pollard changed after the rejected modify")
+	}
+
+	valued[1].Value = 0
+	valued[0].TTL = 3
+	err = m.Modify(valued, nil, Proof{})
+	if !errors.Is(err, ErrUnsupportedLeafData) {
+		t.Fatalf("expected %v but got %v", ErrUnsupportedLeafData, err)
+	}
+	if m.NumLeaves != uint64(len(leaves)) || !reflect.DeepEqual(beforeRoots, m.GetRoots()) {
+		t.Fatalf("map pollard changed after the rejected modify")
+	}
+}
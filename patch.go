@@ -0,0 +1,257 @@
+package utreexo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrBaseUnknown is returned when a patch is requested from a state that's not in the
+// history of the accumulator.
+var ErrBaseUnknown = errors.New("base state unknown")
+
+// ModifyOp is a single modification to the accumulator. It has all the arguments that
+// were passed into Modify.
+type ModifyOp struct {
+	Adds      []Leaf
+	DelHashes []Hash
+	Proof     Proof
+}
+
+// copyModifyOp returns a deep copy of the modify op.
+func copyModifyOp(adds []Leaf, delHashes []Hash, proof Proof) ModifyOp {
+	return ModifyOp{
+		Adds:      append([]Leaf(nil), adds...),
+		DelHashes: append([]Hash(nil), delHashes...),
+		Proof: Proof{
+			Targets: append([]uint64(nil), proof.Targets...),
+			Proof:   append([]Hash(nil), proof.Proof...),
+		},
+	}
+}
+
+// Patch is the list of modifications that bring an accumulator from the base state to
+// the target state.
+type Patch struct {
+	// Base is the StateHash of the accumulator the patch applies to.
+	Base Hash
+
+	// Target is the StateHash of the accumulator after the patch is applied.
+	Target Hash
+
+	// Ops are the modifications to apply in order.
+	Ops []ModifyOp
+}
+
+// historyEntry is a single modify that was applied to the accumulator along with the
+// state before the modify.
+type historyEntry struct {
+	// commitment is the StateHash before the modify.
+	commitment Hash
+
+	// prevRoots are the roots before the modify.
+	prevRoots []Hash
+
+	// op is the modify that was applied.
+	op ModifyOp
+}
+
+// newHistoryEntry returns the history entry for the modify with the current state of the
+// accumulator as the state before the modify.
+func (p *Pollard) newHistoryEntry(adds []Leaf, delHashes []Hash, proof Proof) historyEntry {
+	return historyEntry{
+		commitment: p.StateHash(),
+		prevRoots:  p.GetRoots(),
+		op:         copyModifyOp(adds, delHashes, proof),
+	}
+}
+
+// appendHistory appends the entry to the history and forgets the oldest entries that
+// are over the history length.
+func (p *Pollard) appendHistory(entry historyEntry) {
+	if p.HistoryLength <= 0 {
+		p.history = nil
+		return
+	}
+
+	p.history = append(p.history, entry)
+	if len(p.history) > p.HistoryLength {
+		p.history = append([]historyEntry(nil), p.history[len(p.history)-p.HistoryLength:]...)
+	}
+}
+
+// PatchFrom returns the patch that brings an accumulator with the base commitment to the
+// current state of this accumulator. The base commitment is the StateHash of the
+// accumulator to be patched. ErrBaseUnknown is returned if the base is not in the
+// history.
+func (p *Pollard) PatchFrom(baseCommitment Hash) (Patch, error) {
+	current := p.StateHash()
+	if baseCommitment == current {
+		return Patch{Base: current, Target: current}, nil
+	}
+
+	for i := len(p.history) - 1; i >= 0; i-- {
+		if p.history[i].commitment != baseCommitment {
+			continue
+		}
+
+		patch := Patch{Base: baseCommitment, Target: current}
+		patch.Ops = make([]ModifyOp, 0, len(p.history)-i)
+		for _, entry := range p.history[i:] {
+			patch.Ops = append(patch.Ops, copyModifyOp(
+				entry.op.Adds, entry.op.DelHashes, entry.op.Proof))
+		}
+
+		return patch, nil
+	}
+
+	return Patch{}, fmt.Errorf("PatchFrom fail. %w: %s", ErrBaseUnknown, baseCommitment)
+}
+
+// ProveHistorical returns a proof for the hash that's valid against the roots of the
+// accumulator when it had atNumLeaves. If the accumulator had atNumLeaves for more than
+// one state, the most recent one is used. ErrBaseUnknown is returned if the state is not
+// in the history.
+//
+// NOTE The whole accumulator is copied to undo the modifies so it's expensive for large
+// accumulators.
+func (p *Pollard) ProveHistorical(h Hash, atNumLeaves uint64) (Proof, error) {
+	if p.NumLeaves == atNumLeaves {
+		return p.Prove([]Hash{h})
+	}
+
+	// Undo the modifies on a copy until we reach the requested state. Nothing is
+	// reported or written for the copy.
+	c := p.detachedClone()
+	for i := len(p.history) - 1; i >= 0; i-- {
+		entry := p.history[i]
+		err := c.undo(uint64(len(entry.op.Adds)), entry.op.Proof,
+			entry.op.DelHashes, entry.prevRoots)
+		if err != nil {
+			return Proof{}, fmt.Errorf("ProveHistorical fail. %v", err)
+		}
+
+		if c.NumLeaves == atNumLeaves {
+			return c.Prove([]Hash{h})
+		}
+	}
+
+	return Proof{}, fmt.Errorf("ProveHistorical fail. %w: state with %d leaves",
+		ErrBaseUnknown, atNumLeaves)
+}
+
+// ApplyPatch applies the patch to the accumulator. The accumulator must be in the base
+// state of the patch. Each of the deletions are verified before they're applied. If any
+// of the modifications fail or if the resulting state doesn't match the target of the
+// patch, an error is returned and the accumulator is left unchanged.
+func (p *Pollard) ApplyPatch(patch Patch) error {
+	if p.StateHash() != patch.Base {
+		return fmt.Errorf("ApplyPatch fail. Have state %s but the patch is for %s",
+			p.StateHash(), patch.Base)
+	}
+
+	// Buffer the commitments so that nothing is written if the patch fails.
+	c := p.clone()
+	var commitments bytes.Buffer
+	if p.commitmentLog != nil {
+		c.commitmentLog = &commitments
+	}
+
+	for i, op := range patch.Ops {
+		err := c.Verify(op.DelHashes, op.Proof, false)
+		if err != nil {
+			return fmt.Errorf("ApplyPatch fail at op %d. %v", i, err)
+		}
+		err = c.Modify(op.Adds, op.DelHashes, op.Proof)
+		if err != nil {
+			return fmt.Errorf("ApplyPatch fail at op %d. %v", i, err)
+		}
+	}
+
+	if c.StateHash() != patch.Target {
+		return fmt.Errorf("ApplyPatch fail. Expected state %s after the patch but got %s",
+			patch.Target, c.StateHash())
+	}
+
+	c.commitmentLog = p.commitmentLog
+	*p = c
+	if p.commitmentLog != nil {
+		_, err := p.commitmentLog.Write(commitments.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReconcileProof updates a cached proof across a reorg. cached is a proof for
+// cachedHashes against the current state of the accumulator. undoBlocks are the blocks
+// being reorged out, most recent first, and redoBlocks are the blocks of the new chain
+// to apply after the undos, oldest first. The returned hashes are the cached hashes that
+// are still in the accumulator after the reorg, in the same order as cachedHashes,
+// along with a proof for them against the new state. The cached hashes that are not
+// returned were reorged out.
+//
+// The accumulator itself is not modified. For a pollard that's not full, the cached
+// hashes must be cached in the pollard.
+//
+// NOTE The whole accumulator is copied to apply the reorg so it's expensive for large
+// accumulators.
+func (p *Pollard) ReconcileProof(cached Proof, cachedHashes []Hash, undoBlocks []UndoData,
+	redoBlocks []ModifyOp) (Proof, []Hash, error) {
+
+	err := p.Verify(cachedHashes, cached, false)
+	if err != nil {
+		return Proof{}, nil, fmt.Errorf("ReconcileProof fail. Invalid cached proof. %v", err)
+	}
+
+	c := p.detachedClone()
+
+	for i, ud := range undoBlocks {
+		err = c.UndoFromData(ud)
+		if err != nil {
+			return Proof{}, nil, fmt.Errorf("ReconcileProof fail at undo %d. %v", i, err)
+		}
+	}
+
+	// Keep the cached hashes cached if they're added again in the new chain.
+	cachedSet := make(map[Hash]struct{}, len(cachedHashes))
+	for _, hash := range cachedHashes {
+		cachedSet[hash] = struct{}{}
+	}
+	for i, op := range redoBlocks {
+		err = c.Verify(op.DelHashes, op.Proof, false)
+		if err != nil {
+			return Proof{}, nil, fmt.Errorf("ReconcileProof fail at redo %d. %v", i, err)
+		}
+
+		adds := make([]Leaf, len(op.Adds))
+		copy(adds, op.Adds)
+		for j := range adds {
+			if _, found := cachedSet[adds[j].Hash]; found {
+				adds[j].Remember = true
+			}
+		}
+
+		err = c.Modify(adds, op.DelHashes, op.Proof)
+		if err != nil {
+			return Proof{}, nil, fmt.Errorf("ReconcileProof fail at redo %d. %v", i, err)
+		}
+	}
+
+	var surviving []Hash
+	for _, hash := range cachedHashes {
+		node, found := c.NodeMap[hash.mini()]
+		if found && node.data == hash {
+			surviving = append(surviving, hash)
+		}
+	}
+
+	proof, err := c.Prove(surviving)
+	if err != nil {
+		return Proof{}, nil, fmt.Errorf("ReconcileProof fail. %v", err)
+	}
+
+	return proof, surviving, nil
+}
@@ -0,0 +1,277 @@
+package utreexo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChain(0x07)
+	source := NewAccumulator(true)
+	source.HistoryLength = 5
+	remote := NewAccumulator(true)
+
+	applyBlock := func(p *Pollard, adds []Leaf, delHashes []Hash) {
+		t.Helper()
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bring both to the same state.
+	for b := 0; b < 10; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		applyBlock(&source, adds, delHashes)
+		applyBlock(&remote, adds, delHashes)
+	}
+	base := remote.StateHash()
+	if base != source.StateHash() {
+		t.Fatalf("expected the same state")
+	}
+
+	// Move the source a few blocks ahead.
+	for b := 0; b < 3; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		applyBlock(&source, adds, delHashes)
+	}
+
+	patch, err := source.PatchFrom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch.Ops) != 3 {
+		t.Fatalf("expected 3 ops but got %d", len(patch.Ops))
+	}
+	err = remote.ApplyPatch(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.StateHash() != source.StateHash() {
+		t.Fatalf("expected state %s but got %s", source.StateHash(), remote.StateHash())
+	}
+	if !reflect.DeepEqual(source.GetRoots(), remote.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(source.GetRoots()), printHashes(remote.GetRoots()))
+	}
+	err = remote.posMapSanity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A patch from the current state is empty.
+	patch, err = source.PatchFrom(source.StateHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patch.Ops) != 0 {
+		t.Fatalf("expected an empty patch but got %d ops", len(patch.Ops))
+	}
+
+	// A patch can't be applied to the wrong base.
+	adds, _, delHashes := sc.NextBlock(5)
+	applyBlock(&source, adds, delHashes)
+	patch, err = source.PatchFrom(remote.StateHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch.Base = Hash{1}
+	err = remote.ApplyPatch(patch)
+	if err == nil {
+		t.Fatalf("expected an error applying a patch to the wrong base")
+	}
+
+	// A bad patch leaves the accumulator unchanged.
+	patch, err = source.PatchFrom(remote.StateHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch.Target = Hash{1}
+	before := remote.StateHash()
+	err = remote.ApplyPatch(patch)
+	if err == nil {
+		t.Fatalf("expected an error applying a patch with the wrong target")
+	}
+	if remote.StateHash() != before {
+		t.Fatalf("expected the accumulator to be unchanged after a failed patch")
+	}
+
+	// States older than the history are unknown.
+	for b := 0; b < 6; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+		applyBlock(&source, adds, delHashes)
+	}
+	_, err = source.PatchFrom(remote.StateHash())
+	if !errors.Is(err, ErrBaseUnknown) {
+		t.Fatalf("expected %v but got %v", ErrBaseUnknown, err)
+	}
+}
+
+func TestProveHistorical(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.HistoryLength = 3
+
+	type state struct {
+		roots     []Hash
+		numLeaves uint64
+	}
+	var states []state
+	var leaves []Leaf
+	for b := 0; b < 6; b++ {
+		adds := makeTestLeaves(4, len(leaves))
+		leaves = append(leaves, adds...)
+
+		// Delete a leaf from the previous block.
+		var delHashes []Hash
+		if b > 0 {
+			delHashes = []Hash{leaves[len(leaves)-5].Hash}
+		}
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, state{p.GetRoots(), p.NumLeaves})
+	}
+	beforeRoots := p.GetRoots()
+
+	// The leaf was deleted in the last block but it existed in the state before.
+	old := states[len(states)-2]
+	deleted := leaves[len(leaves)-5].Hash
+	proof, err := p.ProveHistorical(deleted, old.numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: old.roots, NumLeaves: old.numLeaves}, []Hash{deleted}, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: p.GetRoots(), NumLeaves: old.numLeaves}, []Hash{deleted}, proof)
+	if err == nil {
+		t.Fatalf("expected the historical proof to fail against the current roots")
+	}
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("pollard changed after proving a historical state")
+	}
+
+	// The current state doesn't need any history.
+	current := states[len(states)-1]
+	proof, err = p.ProveHistorical(leaves[0].Hash, current.numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: current.roots, NumLeaves: current.numLeaves},
+		[]Hash{leaves[0].Hash}, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// States older than the history are unknown.
+	_, err = p.ProveHistorical(leaves[0].Hash, states[0].numLeaves)
+	if !errors.Is(err, ErrBaseUnknown) {
+		t.Fatalf("expected ErrBaseUnknown but got %v", err)
+	}
+}
+
+func TestReconcileProof(t *testing.T) {
+	t.Parallel()
+
+	// Both chains share the first 3 blocks.
+	p := NewAccumulator(true)
+	newChain := NewAccumulator(true)
+	leaves := makeTestLeaves(30, 0)
+	for b := 0; b < 3; b++ {
+		adds := leaves[b*4 : b*4+4]
+		for _, acc := range []*Pollard{&p, &newChain} {
+			err := acc.Modify(adds, nil, Proof{})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Two blocks on the old chain.
+	var undoBlocks []UndoData
+	oldBlocks := []struct {
+		adds []Leaf
+		dels []Hash
+	}{
+		{leaves[12:15], []Hash{leaves[1].Hash}},
+		{leaves[15:18], []Hash{leaves[12].Hash}},
+	}
+	for _, block := range oldBlocks {
+		proof, err := p.Prove(block.dels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ud, err := p.ModifyWithUndo(block.adds, block.dels, proof.Targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		undoBlocks = append([]UndoData{ud}, undoBlocks...)
+	}
+
+	// Two blocks on the new chain. leaves[16] is in both chains and leaves[13] is only
+	// in the old chain.
+	var redoBlocks []ModifyOp
+	newBlocks := []struct {
+		adds []Leaf
+		dels []Hash
+	}{
+		{[]Leaf{leaves[16], leaves[20]}, []Hash{leaves[5].Hash}},
+		{leaves[21:24], []Hash{leaves[20].Hash}},
+	}
+	for _, block := range newBlocks {
+		proof, err := newChain.Prove(block.dels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redoBlocks = append(redoBlocks, copyModifyOp(block.adds, block.dels, proof))
+		err = newChain.Modify(block.adds, block.dels, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cachedHashes := []Hash{leaves[2].Hash, leaves[13].Hash, leaves[16].Hash}
+	cached, err := p.Prove(cachedHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+
+	proof, surviving, err := p.ReconcileProof(cached, cachedHashes, undoBlocks, redoBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hash{leaves[2].Hash, leaves[16].Hash}
+	if !reflect.DeepEqual(expected, surviving) {
+		t.Fatalf("expected surviving hashes:\n%s\ngot:\n%s",
+			printHashes(expected), printHashes(surviving))
+	}
+	err = newChain.Verify(surviving, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("pollard changed after reconciling a proof")
+	}
+
+	// A stale cached proof is rejected.
+	_, _, err = p.ReconcileProof(cached, cachedHashes[:1], undoBlocks, redoBlocks)
+	if err == nil {
+		t.Fatalf("expected an error for an invalid cached proof")
+	}
+}
@@ -1,16 +1,74 @@
 package utreexo
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
+	"math/bits"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Assert that Pollard implements the Utreexo interface.
 var _ Utreexo = (*Pollard)(nil)
 
+var (
+	// ErrDuplicateDeletion is returned when the same hash is passed in more than once
+	// as a deletion in a single call to Modify.
+	ErrDuplicateDeletion = errors.New("duplicate deletion")
+
+	// ErrUnsortedAdds is returned when RequireSortedAdds is set and the additions passed
+	// into Modify are not sorted by their hashes.
+	ErrUnsortedAdds = errors.New("additions are not sorted by hash")
+
+	// ErrHashCollision is returned when a hash being added collides with a leaf in the
+	// node map, another cached node, or another hash being added in the same call to
+	// Modify.
+	ErrHashCollision = errors.New("hash collision")
+
+	// ErrLeafDeleted is returned when proving a leaf that was deleted within the
+	// tombstone window.
+	ErrLeafDeleted = errors.New("leaf was deleted")
+
+	// ErrAddDeleteConflict is returned when a hash is both added and deleted in the same
+	// call to Modify but the deletion isn't of a leaf that was already in the
+	// accumulator.
+	ErrAddDeleteConflict = errors.New("hash is both added and deleted")
+
+	// ErrChecksumMismatch is returned when the checksum at the end of a serialized
+	// pollard doesn't match the serialized data. It means the data was corrupted.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrZeroHashLeaf is returned by ValidateLeaves when one of the leaves has an empty
+	// hash.
+	ErrZeroHashLeaf = errors.New("leaf has a zero hash")
+
+	// ErrUnknownVersion is returned when restoring a serialized pollard that was written
+	// with a serialization format that's not supported.
+	ErrUnknownVersion = errors.New("unknown serialization version")
+
+	// ErrReplayIgnored is returned when DedupeReplays is set and Modify is called with
+	// the same block as the modify that was just applied. The pollard isn't modified.
+	ErrReplayIgnored = errors.New("replayed modify ignored")
+
+	// ErrUndoDataRequired is returned by Undo when the undone modify deleted leaves and
+	// the pollard tracks the values or the TTLs of its leaves. Undo doesn't know the
+	// values and the TTLs of the deleted leaves so UndoFromData has to be used instead.
+	ErrUndoDataRequired = errors.New("undo data required to undo the deletions")
+
+	// ErrUnsupportedLeafData is returned by MapPollard when a leaf being added has a
+	// value or a TTL as MapPollard doesn't track them.
+	ErrUnsupportedLeafData = errors.New("leaf data not supported")
+)
+
 // Pollard is a representation of the utreexo forest using a collection of
 // binary trees. It may or may not contain the entire set.
 type Pollard struct {
@@ -42,8 +100,141 @@ type Pollard struct {
 	// Only Pollards that have the Full value set to true will be able to prove all
 	// the elements.
 	Full bool
+
+	// Hasher is the hash function used to calculate the parent hashes in the
+	// accumulator. The default hash function is used if it's nil.
+	Hasher Hasher
+
+	// RequireSortedAdds makes Modify return ErrUnsortedAdds if the additions are not
+	// in ascending order of their hashes.
+	RequireSortedAdds bool
+
+	// DisableHashCache makes Modify recalculate every cached hash in the accumulator
+	// from the leaves after every modify instead of only the hashes on the modified
+	// paths. The roots are the same either way. It's slow and is meant for ruling out
+	// stale hashes on the unmodified paths.
+	//
+	// NOTE The recalculated hashes overwrite the cached ones without being compared so
+	// wrong hashes are fixed silently. Use ParanoidMode to detect wrong hashes.
+	DisableHashCache bool
+
+	// DedupeReplays makes Modify return ErrReplayIgnored without modifying anything if
+	// it's called with the same adds, dels and targets as the modify that was just
+	// applied. It's useful for when the same block may be delivered more than once.
+	//
+	// NOTE Only an immediate replay of the last modify is detected. A block that was
+	// applied before the last modify is applied again. Blocks without any adds or
+	// dels are never ignored as they're indistinguishable from each other.
+	DedupeReplays bool
+
+	// lastModify is the signature of the last modify that was applied while
+	// DedupeReplays was set.
+	lastModify modifySignature
+
+	// ParanoidMode enables checking the hashes of all the modified trees after every
+	// modify. If any of the hashes are wrong, the modify is rolled back and an error is
+	// returned. It's slow and is meant for catching corruption early.
+	ParanoidMode bool
+
+	// ProgressInterval is the number of leaves between each call to OnProgress.
+	// Progress is not reported if it's 0.
+	ProgressInterval uint64
+
+	// OnProgress is called with the total number of leaves in the accumulator every
+	// time the NumLeaves reaches a multiple of ProgressInterval during an addition.
+	// It's useful for reporting progress on long syncs.
+	OnProgress func(numLeaves uint64)
+
+	// OnProveError is called with the details of the failure every time Prove fails.
+	// It doesn't change the error that Prove returns.
+	OnProveError func(ProveErrorEvent)
+
+	// RecordProofHashes makes Prove count how many times each hash was included in the
+	// returned proofs. The counts are returned by ProofHashFrequency.
+	RecordProofHashes bool
+
+	// proofHashFreq is the number of times each hash was included in a proof while
+	// RecordProofHashes was set.
+	proofHashFreq map[Hash]int
+
+	// TombstoneWindow is the number of blocks the hashes of deleted leaves are
+	// remembered for. Proving a remembered hash returns ErrLeafDeleted instead of a
+	// generic not found error. Tombstones are not kept if it's 0.
+	TombstoneWindow uint64
+
+	// tombstones maps the deleted hashes to the height they were deleted at.
+	tombstones map[miniHash]uint64
+
+	// height is the number of modifies that were applied to the accumulator minus the
+	// number of undos.
+	height uint64
+
+	// values maps the hashes of the live leaves to their values. Leaves with a
+	// value of 0 are not included.
+	values map[miniHash]uint64
+
+	// expiries maps the hashes of the cached leaves that were added with a TTL to the
+	// block they're expected to be deleted at.
+	expiries map[miniHash]int32
+
+	// totalValue is the sum of the values of all the live leaves.
+	totalValue uint64
+
+	// HistoryLength is the number of the most recent modifies that are remembered.
+	// The history is used to create patches for other accumulators. No history is
+	// kept if it's 0.
+	HistoryLength int
+
+	// history is the most recent modifies, oldest first.
+	history []historyEntry
+
+	// commitmentLog is where the commitments are written to after every modify and undo.
+	commitmentLog io.Writer
+
+	// hot is the set of leaves that PruneToSize keeps cached.
+	hot map[miniHash]struct{}
+
+	// TrackAddIndex makes the accumulator remember the order the cached leaves were
+	// added in so that they can be proven with ProveByIndex. Only the leaves added
+	// while it's set are tracked.
+	TrackAddIndex bool
+
+	// addIndexes maps the add index of the cached leaves to their hashes.
+	addIndexes map[uint64]Hash
+
+	// addIndexOf is the reverse of addIndexes.
+	addIndexOf map[miniHash]uint64
+
+	// epoch is incremented on every modify and undo.
+	epoch uint64
+
+	// compact is where CompactFor left off. It's nil if there's no compaction in
+	// progress.
+	compact *compactCursor
+
+	// metrics are the counters returned by Metrics.
+	metrics Metrics
+}
+
+// Metrics are the counters of the pollard's operations since it was created.
+type Metrics struct {
+	// ProveCacheHits is the number of calls to Prove where all the hashes and the
+	// proof hashes were cached.
+	ProveCacheHits uint64
+
+	// ProveCacheMisses is the number of calls to Prove that failed because a hash or
+	// a proof hash wasn't cached. Calls where only some of the hashes were cached are
+	// counted as misses as well.
+	ProveCacheMisses uint64
 }
 
+// CommitmentRollback is the height prefix that marks a rollback entry in the commitment
+// log. The commitment in the rollback entry is the state hash after the rollback.
+const CommitmentRollback = math.MaxUint64
+
+// CommitmentEntrySize is the size of a single entry in the commitment log.
+const CommitmentEntrySize = 8 + 32
+
 // NewAccumulator returns a initialized accumulator. To enable the generating proofs
 // for all elements, set Full to true.
 func NewAccumulator(full bool) Pollard {
@@ -54,11 +245,63 @@ func NewAccumulator(full bool) Pollard {
 	return p
 }
 
+// Metrics returns a copy of the counters of the pollard.
+func (p *Pollard) Metrics() Metrics {
+	return p.metrics
+}
+
+// ProofHashFrequency returns how many times each hash was included in the proofs returned
+// by Prove while RecordProofHashes was set. The hashes that are included the most are
+// the ones worth caching.
+func (p *Pollard) ProofHashFrequency() map[Hash]int {
+	freq := make(map[Hash]int, len(p.proofHashFreq))
+	for hash, count := range p.proofHashFreq {
+		freq[hash] = count
+	}
+
+	return freq
+}
+
+// ResetProofHashFrequency forgets all the counts returned by ProofHashFrequency.
+func (p *Pollard) ResetProofHashFrequency() {
+	p.proofHashFreq = nil
+}
+
 // GetNumLeaves returns the total number of leaves added to the accumulator.
 func (p *Pollard) GetNumLeaves() uint64 {
 	return p.NumLeaves
 }
 
+// IsFull returns true if the pollard keeps all the leaves in the accumulator.
+func (p *Pollard) IsFull() bool {
+	return p.Full
+}
+
+// IsEmpty returns true if the pollard has no live leaves and nothing cached. This is true
+// both for a pollard that never had any leaves added and for one that had all of its
+// leaves deleted. Only the former has a NumLeaves of 0. The latter still has its
+// NumLeaves and a root for each tree but all of the roots are empty.
+func (p *Pollard) IsEmpty() bool {
+	if p.NumLeaves != p.NumDels || len(p.NodeMap) != 0 {
+		return false
+	}
+	for _, root := range p.Roots {
+		if root.data != empty || root.lNiece != nil || root.rNiece != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasher returns the hash function of the pollard.
+func (p *Pollard) hasher() Hasher {
+	if p.Hasher == nil {
+		return parentHash
+	}
+	return p.Hasher
+}
+
 // GetTreeRows returns the total number of rows the accumulator has allocated for.
 func (p *Pollard) GetTreeRows() uint8 {
 	return treeRows(p.NumLeaves)
@@ -66,319 +309,1988 @@ func (p *Pollard) GetTreeRows() uint8 {
 
 // Modify takes in the additions and deletions and updates the accumulator accordingly.
 //
-// NOTE Modify does NOT do any validation and assumes that all the positions of the leaves
+// The additions and deletions are checked before anything is modified. A hash that's
+// deleted more than once is ErrDuplicateDeletion, adds that aren't sorted while
+// RequireSortedAdds is set are ErrUnsortedAdds, an add that collides with a cached node
+// or another add is ErrHashCollision, and a hash that's both added and deleted without
+// being an existing leaf is ErrAddDeleteConflict. If DedupeReplays is set, the same block
+// as the modify that was just applied is ErrReplayIgnored.
+//
+// NOTE Modify does NOT verify the proof and assumes that all the positions of the leaves
 // being deleted have already been verified.
 func (p *Pollard) Modify(adds []Leaf, delHashes []Hash, proof Proof) error {
-	// Make a copy to avoid mutating the deletion slice passed in.
-	delCount := len(proof.Targets)
-	dels := make([]uint64, delCount)
-	copy(dels, proof.Targets)
+	if p.ParanoidMode {
+		return p.paranoidModify(adds, delHashes, proof)
+	}
 
-	// Remove the delHashes from the map.
-	p.deleteFromMap(delHashes)
+	return p.modify(adds, delHashes, proof)
+}
 
-	// Perform the deletion. It's important that this must happen before the addition.
-	err := p.remove(dels)
-	if err != nil {
-		return err
+// paranoidModify performs the modify and checks the hashes of all the trees that were
+// modified. The pollard is rolled back to its state before the modify if the modify
+// fails or if any of the hashes are wrong.
+func (p *Pollard) paranoidModify(adds []Leaf, delHashes []Hash, proof Proof) error {
+	backup := p.clone()
+	prevRoots := make(map[Hash]struct{}, len(p.Roots))
+	for _, root := range p.Roots {
+		prevRoots[root.data] = struct{}{}
 	}
-	p.NumDels += uint64(delCount)
 
-	p.add(adds)
+	// Buffer the commitment so that nothing is written if the modify is rolled back.
+	var commitments bytes.Buffer
+	if p.commitmentLog != nil {
+		p.commitmentLog = &commitments
+	}
 
-	return nil
-}
+	err := p.modify(adds, delHashes, proof)
+	p.commitmentLog = backup.commitmentLog
+	if err != nil {
+		*p = backup
+		return err
+	}
 
-// add adds all the passed in leaves to the accumulator.
-func (p *Pollard) add(adds []Leaf) {
-	for _, add := range adds {
-		// Create a node from the hash. If the pollard is Full, then remember
-		// every node.
-		node := &polNode{data: add.Hash, remember: add.Remember}
-		if p.Full {
-			node.remember = true
+	// Only the trees with new roots were modified.
+	for _, root := range p.Roots {
+		_, found := prevRoots[root.data]
+		if found {
+			continue
 		}
 
-		// Add the hash to the map if this node is supposed to be remembered.
-		if node.remember {
-			p.NodeMap[add.mini()] = node
+		err = checkRootHashes(root, p.hasher())
+		if err != nil {
+			*p = backup
+			return fmt.Errorf("Modify fail. Rolled back as the hashes are "+
+				"inconsistent after the modify. %v", err)
 		}
+	}
 
-		newRoot := p.calculateNewRoot(node)
-		p.Roots = append(p.Roots, newRoot)
-
-		// Increment as we added a leaf.
-		p.NumLeaves++
+	if p.commitmentLog != nil {
+		_, err = p.commitmentLog.Write(commitments.Bytes())
+		if err != nil {
+			return err
+		}
 	}
-}
 
-// calculateNewRoot adds the node to the accumulator and calculates the new root.
-func (p *Pollard) calculateNewRoot(node *polNode) *polNode {
-	// We can tell where the roots are by looking at the binary representation
-	// of the NumLeaves. Wherever there's a 1, there's a root.
-	//
-	// NumLeaves of 8 will be '1000' in binary, so there will be one root at
-	// row 3. NumLeaves of 3 will be '11' in binary, so there's two roots. One at
-	// row 0 and one at row 1.
-	//
-	// In this loop below, we're looking for these roots by checking if there's
-	// a '1'. If there is a '1', we'll hash the root being added with that root
-	// until we hit a '0'.
-	for h := uint8(0); (p.NumLeaves>>h)&1 == 1; h++ {
-		// Grab and pop off the root that will become a node.
-		// NOTE Explicitly not niling out the polNode for GC as we still need it.
-		root := p.Roots[len(p.Roots)-1]
-		p.Roots = p.Roots[:len(p.Roots)-1]
+	return nil
+}
 
-		// If the root that we're gonna hash with is empty, move the current
-		// node up to the position of the parent.
-		//
-		// Example:
-		//
-		// 12
-		// |-------\
-		// 08      09
-		// |---\   |---\
-		// 00  01  02  03  --
-		//
-		// When we add 05 to this tree, 04 is empty so we move 05 to 10.
-		// The resulting tree looks like below. The hash at position 10
-		// is not hash(04 || 05) but just the hash of 05.
-		//
-		// 12
-		// |-------\
-		// 08      09      10
-		// |---\   |---\   |---\
-		// 00  01  02  03  --  --
-		if root.data == empty {
-			continue
-		}
+// modify takes in the additions and deletions and updates the accumulator accordingly.
+func (p *Pollard) modify(adds []Leaf, delHashes []Hash, proof Proof) error {
+	return p.modifyBlock(adds, delHashes, proof, nil, true)
+}
 
-		// Roots point to their children. Those children become nieces here.
-		swapNieces(root, node)
+// ModifyScratch holds the copy of the targets and the maps used by the duplicate and
+// collision checks of a modify so that they can be reused between calls to ModifyInto.
+// The deletions themselves are done in place and don't allocate buffers. The zero value
+// is ready to use. A ModifyScratch must not be used by more than one modify at a time.
+type ModifyScratch struct {
+	dels    []uint64
+	delSeen map[Hash]int
+	deleted map[miniHash]int
+	addSeen map[miniHash]int
+}
 
-		// Calculate the hash of the new root.
-		nHash := parentHash(root.data, node.data)
+// delBuf returns the targets buffer of the scratch. Returns nil if the scratch is nil.
+func (s *ModifyScratch) delBuf() []uint64 {
+	if s == nil {
+		return nil
+	}
+	return s.dels[:0]
+}
 
-		newRoot := &polNode{data: nHash, lNiece: root, rNiece: node}
-		if p.Full {
-			newRoot.remember = true
-		}
+// delSeenMap returns an empty map for checking duplicate deletions.
+func (s *ModifyScratch) delSeenMap(size int) map[Hash]int {
+	if s == nil {
+		return make(map[Hash]int, size)
+	}
+	if s.delSeen == nil {
+		s.delSeen = make(map[Hash]int, size)
+	}
+	for k := range s.delSeen {
+		delete(s.delSeen, k)
+	}
+	return s.delSeen
+}
 
-		// Set aunt.
-		updateAunt(newRoot)
-		newRoot.prune()
-		node = newRoot
+// deletedMap returns an empty map for marking the deleted hashes during the collision checks.
+func (s *ModifyScratch) deletedMap(size int) map[miniHash]int {
+	if s == nil {
+		return make(map[miniHash]int, size)
+	}
+	if s.deleted == nil {
+		s.deleted = make(map[miniHash]int, size)
 	}
+	for k := range s.deleted {
+		delete(s.deleted, k)
+	}
+	return s.deleted
+}
 
-	return node
+// addSeenMap returns an empty map for marking the added hashes during the collision checks.
+func (s *ModifyScratch) addSeenMap(size int) map[miniHash]int {
+	if s == nil {
+		return make(map[miniHash]int, size)
+	}
+	if s.addSeen == nil {
+		s.addSeen = make(map[miniHash]int, size)
+	}
+	for k := range s.addSeen {
+		delete(s.addSeen, k)
+	}
+	return s.addSeen
 }
 
-// remove removes all the positions that are passed in.
-func (p *Pollard) remove(dels []uint64) error {
-	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
+// ModifyInto is Modify with the deletions passed in as just the targets. The buffers
+// needed for the modify are taken from the scratch so that repeated calls don't
+// reallocate them. The scratch may be nil in which case it behaves exactly like Modify.
+func (p *Pollard) ModifyInto(adds []Leaf, delHashes []Hash, targets []uint64,
+	scratch *ModifyScratch) error {
 
-	totalRows := treeRows(p.NumLeaves)
-	dels = deTwin(dels, totalRows)
+	proof := Proof{Targets: targets}
+	if p.ParanoidMode {
+		return p.Modify(adds, delHashes, proof)
+	}
 
-	for _, del := range dels {
-		// If a root is being deleted, then we mark it and all the leaves below
-		// it to be deleted.
-		if isRootPosition(del, p.NumLeaves) {
-			err := p.deleteRoot(del)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := p.deleteSingle(del)
+	return p.modifyBlock(adds, delHashes, proof, scratch, true)
+}
+
+// ModifyMany applies each of the blocks in order. The resulting state is the same as
+// calling Modify for each of the blocks but a single ModifyScratch is reused between the
+// blocks. If DisableHashCache is set, the hashes are recalculated only once at the end.
+// The node map and the other indexes are still updated block by block.
+//
+// NOTE If a block fails, the error is returned and the blocks before it stay applied.
+func (p *Pollard) ModifyMany(blocks []ModifyOp) error {
+	// Paranoid mode checks and rolls back each block on its own.
+	if p.ParanoidMode {
+		for i, block := range blocks {
+			err := p.Modify(block.Adds, block.DelHashes, block.Proof)
 			if err != nil {
-				return err
+				return fmt.Errorf("ModifyMany fail at block %d. %w", i, err)
 			}
 		}
+		return nil
 	}
 
-	return nil
-}
-
-// delete root removes all the pointers to and from this root and places an
-// empty hash at this root.
-func (p *Pollard) deleteRoot(del uint64) error {
-	tree, _, _, err := detectOffset(del, p.NumLeaves)
-	if err != nil {
-		return err
-	}
-	if tree > uint8(len(p.Roots)-1) {
-		return fmt.Errorf("getNode error: couldn't fetch %d, "+
-			"calculated root index of %d but only have %d roots",
-			del, tree, len(p.Roots))
+	maxDels := 0
+	for _, block := range blocks {
+		if len(block.Proof.Targets) > maxDels {
+			maxDels = len(block.Proof.Targets)
+		}
 	}
+	scratch := &ModifyScratch{dels: make([]uint64, 0, maxDels)}
 
-	// Delete from map.
-	delete(p.NodeMap, p.Roots[tree].data.mini())
-
-	if p.Roots[tree].lNiece != nil {
-		p.Roots[tree].lNiece.aunt = nil
+	for i, block := range blocks {
+		err := p.modifyBlock(block.Adds, block.DelHashes, block.Proof, scratch, false)
+		if err != nil {
+			return fmt.Errorf("ModifyMany fail at block %d. %w", i, err)
+		}
 	}
-	if p.Roots[tree].rNiece != nil {
-		p.Roots[tree].rNiece.aunt = nil
+
+	if p.DisableHashCache {
+		for _, root := range p.Roots {
+			rehashRoot(root, p.hasher())
+		}
 	}
-	p.Roots[tree].chop()
-	p.Roots[tree].aunt = nil
-	p.Roots[tree].data = empty
 
 	return nil
 }
 
-// deleteSingle deletes one leaf from the accumulator and re-hashes the root.
-func (p *Pollard) deleteSingle(del uint64) error {
-	// Fetch all the needed nodes.
-	from := sibling(del)
-	fromNode, fromNodeSib, _, err := p.getNode(from)
+// modifyBlock is modify but the buffers are taken from the scratch if it's not nil. The
+// hashes are only recalculated for DisableHashCache if rehash is set.
+func (p *Pollard) modifyBlock(adds []Leaf, delHashes []Hash, proof Proof,
+	scratch *ModifyScratch, rehash bool) error {
+
+	// Empty blocks can't be told apart so they're never deduped.
+	dedupe := p.DedupeReplays && (len(adds) != 0 || len(delHashes) != 0)
+	var sig modifySignature
+	if dedupe {
+		sig = newModifySignature(adds, delHashes, proof.Targets)
+		if p.isReplay(sig) {
+			return ErrReplayIgnored
+		}
+	}
+
+	// Check for duplicate deletions before anything is mutated.
+	err := checkDuplicateDels(delHashes, scratch)
 	if err != nil {
 		return err
 	}
-	toNode, err := fromNodeSib.getParent()
+	if p.RequireSortedAdds {
+		err = checkSortedAdds(adds)
+		if err != nil {
+			return err
+		}
+	}
+	err = p.checkHashCollisions(adds, delHashes, proof.Targets, scratch)
 	if err != nil {
 		return err
 	}
-	toSib := fromNodeSib.aunt
-
-	// If the position I'm moving to has an aunt, I'm not becoming a root.
-	if toNode.aunt != nil {
-		// Move myself up.
-		transferAunt(fromNode, toNode)
-		transferNiece(fromNode, toNode)
-
-		// Move my children up.
-		transferNiece(toSib, fromNodeSib)
 
-		updateAunt(toNode.aunt)
-	} else {
-		// My data is given to the root.
-		*toNode = *fromNode
+	// Save the state before the modify if we're keeping history.
+	var entry historyEntry
+	if p.HistoryLength > 0 {
+		entry = p.newHistoryEntry(adds, delHashes, proof)
+	}
 
-		// Get my children from my sibling as I'm a root now.
-		transferNiece(toNode, fromNodeSib)
+	// Bump the epoch before anything is mutated so that the epoch changes even if
+	// the modify fails halfway.
+	p.epoch++
 
-		// Update all the nieces to point at me.
-		updateAunt(toNode)
+	// Make a copy to avoid mutating the deletion slice passed in.
+	delCount := len(proof.Targets)
+	dels := append(scratch.delBuf(), proof.Targets...)
+	if scratch != nil {
+		scratch.dels = dels
+	}
 
-		// Delete my former self.
-		delNode(fromNode)
+	// Remove the delHashes from the map.
+	p.deleteFromMap(delHashes)
+	p.removeValues(delHashes)
+
+	// Perform the deletion. It's important that this must happen before the addition.
+	err = p.remove(dels)
+	if err != nil {
+		return err
+	}
+	p.NumDels += uint64(delCount)
+
+	// The tombstones are updated before the adds so that the hashes added back in the
+	// same block are not left as deleted.
+	p.updateTombstones(delHashes, p.height+1)
+	p.add(adds)
+
+	if p.DisableHashCache && rehash {
+		for _, root := range p.Roots {
+			rehashRoot(root, p.hasher())
+		}
+	}
+
+	p.height++
+	p.appendHistory(entry)
+	if dedupe {
+		sig.stateHash = p.StateHash()
+		p.lastModify = sig
+	} else {
+		p.lastModify = modifySignature{}
+	}
+
+	return p.writeCommitment(p.height)
+}
+
+// modifySignature identifies a modify along with the state it resulted in.
+type modifySignature struct {
+	// block is the hash of the adds, the dels and the targets of the modify.
+	block Hash
+
+	// stateHash is the StateHash after the modify.
+	stateHash Hash
+}
+
+// newModifySignature returns the signature of the block without the resulting state.
+func newModifySignature(adds []Leaf, delHashes []Hash, targets []uint64) modifySignature {
+	h := sha256.New()
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(adds)))
+	h.Write(buf[:])
+	for _, add := range adds {
+		h.Write(add.Hash[:])
+	}
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(delHashes)))
+	h.Write(buf[:])
+	for _, delHash := range delHashes {
+		h.Write(delHash[:])
+	}
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(targets)))
+	h.Write(buf[:])
+	for _, target := range targets {
+		binary.LittleEndian.PutUint64(buf[:], target)
+		h.Write(buf[:])
+	}
+
+	var sig modifySignature
+	copy(sig.block[:], h.Sum(nil))
+	return sig
+}
+
+// isReplay returns true if the block of the signature is the same as the modify that was
+// just applied and the pollard is still in the state that modify resulted in.
+func (p *Pollard) isReplay(sig modifySignature) bool {
+	if p.lastModify == (modifySignature{}) || p.lastModify.block != sig.block {
+		return false
+	}
+
+	return p.lastModify.stateHash == p.StateHash()
+}
+
+// StateHash returns a commitment to the current state of the accumulator. It commits
+// to the numLeaves and all the roots.
+func (p *Pollard) StateHash() Hash {
+	return stateHash(p.NumLeaves, p.GetRoots())
+}
+
+// MatchesRoots returns an error if the numLeaves or the roots of the pollard are not the
+// same as the trusted ones. The error names the first root that doesn't match.
+func (p *Pollard) MatchesRoots(trusted []Hash, numLeaves uint64) error {
+	if p.NumLeaves != numLeaves {
+		return fmt.Errorf("MatchesRoots fail. Have %d leaves but the trusted "+
+			"numLeaves is %d", p.NumLeaves, numLeaves)
+	}
+	if len(p.Roots) != len(trusted) {
+		return fmt.Errorf("MatchesRoots fail. Have %d roots but got %d trusted roots",
+			len(p.Roots), len(trusted))
+	}
+
+	for i, root := range p.Roots {
+		if root.data != trusted[i] {
+			return fmt.Errorf("MatchesRoots fail. Root %d is %s but the trusted "+
+				"root is %s", i, root.data, trusted[i])
+		}
+	}
+
+	return nil
+}
+
+// LeafSetCommitment returns a commitment to the set of the cached leaves. It's the sha256
+// of the leaf hashes concatenated in ascending order so it doesn't depend on the shape
+// of the forest or the order the leaves were added in. For a full pollard, the cached
+// leaves are all the live leaves of the accumulator.
+func (p *Pollard) LeafSetCommitment() Hash {
+	hashes := make([]Hash, 0, len(p.NodeMap))
+	for _, node := range p.NodeMap {
+		hashes = append(hashes, node.data)
+	}
+
+	return leafSetHash(hashes)
+}
+
+// Fingerprint returns a short string of the numLeaves followed by the first 4 bytes of
+// each root in hex. e.g. "100:3f2a8c01|8c01d2e4|77ab0e1f". It's meant for comparing
+// states by eye in logs. Use StateHash for anything else as the fingerprint only has
+// a part of each root.
+func (p *Pollard) Fingerprint() string {
+	var sb strings.Builder
+	sb.WriteString(strconv.FormatUint(p.NumLeaves, 10))
+	sb.WriteByte(':')
+	for i, root := range p.Roots {
+		if i > 0 {
+			sb.WriteByte('|')
+		}
+		sb.WriteString(hex.EncodeToString(root.data[:4]))
+	}
+
+	return sb.String()
+}
+
+// CommitmentLog sets the writer that the commitments of the accumulator are written to.
+// After every modify, the height and the StateHash are written as an 8 byte little
+// endian height followed by the 32 byte commitment. After every undo, an entry with
+// the height of CommitmentRollback followed by the StateHash after the undo is written.
+// Passing in nil disables the log.
+//
+// NOTE If the write fails, the error is returned from Modify or Undo but the
+// accumulator is still modified.
+func (p *Pollard) CommitmentLog(w io.Writer) {
+	p.commitmentLog = w
+}
+
+// writeCommitment writes the height and the current StateHash to the commitment log
+// if it's enabled.
+func (p *Pollard) writeCommitment(height uint64) error {
+	if p.commitmentLog == nil {
+		return nil
+	}
+
+	var buf [CommitmentEntrySize]byte
+	binary.LittleEndian.PutUint64(buf[:8], height)
+	stateHash := p.StateHash()
+	copy(buf[8:], stateHash[:])
+
+	_, err := p.commitmentLog.Write(buf[:])
+	return err
+}
+
+// Height returns the number of modifies that were applied to the accumulator minus
+// the number of undos. Each modify is considered a block.
+//
+// NOTE The height is not serialized and starts from 0 for a restored pollard.
+func (p *Pollard) Height() uint64 {
+	return p.height
+}
+
+// Epoch returns a counter that's incremented on every Modify and Undo, including the
+// ones that return an error after the accumulator was changed. Unlike the height, it
+// never goes down so a cached proof is still valid if the epoch is the same as when
+// the proof was made. It's cheaper to compare than the roots.
+//
+// NOTE The epoch is not serialized and starts from 0 for a new or restored pollard.
+func (p *Pollard) Epoch() uint64 {
+	return p.epoch
+}
+
+// updateTombstones remembers the hashes deleted in the block at the given height and
+// forgets the hashes that were deleted outside of the tombstone window.
+func (p *Pollard) updateTombstones(delHashes []Hash, height uint64) {
+	if p.TombstoneWindow == 0 {
+		p.tombstones = nil
+		return
+	}
+	if p.tombstones == nil {
+		p.tombstones = make(map[miniHash]uint64)
+	}
+
+	for k, deletedAt := range p.tombstones {
+		if height-deletedAt >= p.TombstoneWindow {
+			delete(p.tombstones, k)
+		}
+	}
+	for _, delHash := range delHashes {
+		p.tombstones[delHash.mini()] = height
+	}
+}
+
+// clone returns a deep copy of the pollard.
+func (p *Pollard) clone() Pollard {
+	c := *p
+	c.compact = nil
+	c.NodeMap = make(map[miniHash]*polNode, len(p.NodeMap))
+	c.Roots = make([]*polNode, len(p.Roots))
+	for i, root := range p.Roots {
+		c.Roots[i] = p.cloneNode(root, nil, c.NodeMap)
+	}
+
+	if p.tombstones != nil {
+		c.tombstones = make(map[miniHash]uint64, len(p.tombstones))
+		for k, v := range p.tombstones {
+			c.tombstones[k] = v
+		}
+	}
+	if p.values != nil {
+		c.values = make(map[miniHash]uint64, len(p.values))
+		for k, v := range p.values {
+			c.values[k] = v
+		}
+	}
+	if p.expiries != nil {
+		c.expiries = make(map[miniHash]int32, len(p.expiries))
+		for k, v := range p.expiries {
+			c.expiries[k] = v
+		}
+	}
+	if p.hot != nil {
+		c.hot = make(map[miniHash]struct{}, len(p.hot))
+		for k := range p.hot {
+			c.hot[k] = struct{}{}
+		}
+	}
+	if p.addIndexes != nil {
+		c.addIndexes = make(map[uint64]Hash, len(p.addIndexes))
+		for k, v := range p.addIndexes {
+			c.addIndexes[k] = v
+		}
+		c.addIndexOf = make(map[miniHash]uint64, len(p.addIndexOf))
+		for k, v := range p.addIndexOf {
+			c.addIndexOf[k] = v
+		}
+	}
+	if p.proofHashFreq != nil {
+		c.proofHashFreq = make(map[Hash]int, len(p.proofHashFreq))
+		for k, v := range p.proofHashFreq {
+			c.proofHashFreq[k] = v
+		}
+	}
+	if p.history != nil {
+		c.history = append([]historyEntry(nil), p.history...)
+	}
+
+	return c
+}
+
+// detachedClone returns a deep copy of the pollard that's used internally for working on
+// a different state than the current one. Nothing is reported, recorded or written for
+// the copy so it doesn't have the callbacks, the commitment log, the proof hash counts
+// or the history. Replays aren't deduped for the copy either.
+func (p *Pollard) detachedClone() Pollard {
+	c := p.clone()
+	c.commitmentLog = nil
+	c.OnProgress = nil
+	c.OnProveError = nil
+	c.RecordProofHashes = false
+	c.proofHashFreq = nil
+	c.HistoryLength = 0
+	c.history = nil
+	c.DedupeReplays = false
+	c.lastModify = modifySignature{}
+
+	return c
+}
+
+// cloneNode returns a deep copy of the node and all its nieces. The copied nodes that
+// are in the node map of the pollard are added to the given node map.
+func (p *Pollard) cloneNode(n, aunt *polNode, nodeMap map[miniHash]*polNode) *polNode {
+	if n == nil {
+		return nil
+	}
+
+	c := &polNode{data: n.data, remember: n.remember, aunt: aunt}
+	c.lNiece = p.cloneNode(n.lNiece, c, nodeMap)
+	c.rNiece = p.cloneNode(n.rNiece, c, nodeMap)
+
+	if mapNode, found := p.NodeMap[n.data.mini()]; found && mapNode == n {
+		nodeMap[n.data.mini()] = c
+	}
+
+	return c
+}
+
+// checkHashCollisions returns ErrHashCollision if any of the adds collide with the
+// leaves in the node map, the other cached nodes, or each other. The leaves in the node
+// map are keyed by their miniHash so hashes that only share the miniHash collide as
+// well. The leaves being deleted are not considered as they're removed before the adds.
+//
+// A hash that's both added and deleted is only allowed if the deletion is of a leaf that
+// was already in the accumulator before the call, as the deletions happen before the
+// additions. Otherwise, it's ErrAddDeleteConflict. Spending an add within the same block
+// is done by leaving the hash out of both the adds and the dels.
+//
+// NOTE All the cached nodes are walked to find collisions with the internal nodes so
+// the check is linear in the number of cached nodes.
+func (p *Pollard) checkHashCollisions(adds []Leaf, delHashes []Hash, targets []uint64,
+	scratch *ModifyScratch) error {
+
+	if len(adds) == 0 {
+		return nil
+	}
+
+	deleted := scratch.deletedMap(len(delHashes))
+	for idx, delHash := range delHashes {
+		deleted[delHash.mini()] = idx
+	}
+
+	seen := scratch.addSeenMap(len(adds))
+	for idx, add := range adds {
+		mini := add.mini()
+		if foundIdx, found := seen[mini]; found {
+			return fmt.Errorf("%w: hash %s added at idx %d and %d",
+				ErrHashCollision, add.Hash, foundIdx, idx)
+		}
+		seen[mini] = idx
+
+		if delIdx, found := deleted[mini]; found {
+			sameHash := add.Hash == delHashes[delIdx]
+			if sameHash && !p.isExistingLeaf(add.Hash, targets, delIdx) {
+				return fmt.Errorf("%w: hash %s at add idx %d and del idx %d "+
+					"is not an existing leaf", ErrAddDeleteConflict, add.Hash,
+					idx, delIdx)
+			}
+			continue
+		}
+		if _, found := p.NodeMap[mini]; found {
+			return fmt.Errorf("%w: hash %s at idx %d is already in the node map",
+				ErrHashCollision, add.Hash, idx)
+		}
+	}
+
+	for _, root := range p.Roots {
+		err := checkNodeCollisions(root, adds, seen, deleted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNodeCollisions returns ErrHashCollision if any of the adds in seen have the same
+// hash as the node or any of the cached nodes under it. The nodes in deleted are
+// skipped. The children of a node are the nieces of its sibling so going down the
+// nieces of every node visits each of the nodes once.
+func checkNodeCollisions(n *polNode, adds []Leaf, seen, deleted map[miniHash]int) error {
+	if n == nil {
+		return nil
+	}
+
+	if n.data != empty {
+		mini := n.data.mini()
+		if _, found := deleted[mini]; !found {
+			if idx, found := seen[mini]; found {
+				return fmt.Errorf("%w: hash %s at idx %d is a cached node",
+					ErrHashCollision, adds[idx].Hash, idx)
+			}
+		}
+	}
+
+	err := checkNodeCollisions(n.lNiece, adds, seen, deleted)
+	if err != nil {
+		return err
+	}
+	return checkNodeCollisions(n.rNiece, adds, seen, deleted)
+}
+
+// isExistingLeaf returns true if the hash of the deletion at delIdx is known to be the
+// leaf at its target in the accumulator.
+//
+// NOTE A pollard that's not full only knows of the leaves it has cached. An existing
+// leaf that's not cached reads as empty at its target and isn't taken to be an existing
+// leaf. This is fine as the pollard can't delete leaves that it doesn't have cached.
+func (p *Pollard) isExistingLeaf(delHash Hash, targets []uint64, delIdx int) bool {
+	node, found := p.NodeMap[delHash.mini()]
+	if found && node.data == delHash {
+		return true
+	}
+
+	return delIdx < len(targets) && p.getHash(targets[delIdx]) == delHash
+}
+
+// ValidateLeaves returns the first problem found in the leaves before they're passed
+// into Modify as adds. A leaf with an empty hash is ErrZeroHashLeaf and a leaf that
+// collides with an earlier leaf is ErrHashCollision. Like in Modify, leaves that only
+// share the miniHash collide as well.
+func ValidateLeaves(leaves []Leaf) error {
+	seen := make(map[miniHash]int, len(leaves))
+	for idx, leaf := range leaves {
+		if leaf.Hash == empty {
+			return fmt.Errorf("%w at idx %d", ErrZeroHashLeaf, idx)
+		}
+
+		mini := leaf.mini()
+		if foundIdx, found := seen[mini]; found {
+			return fmt.Errorf("%w: hash %s added at idx %d and %d",
+				ErrHashCollision, leaf.Hash, foundIdx, idx)
+		}
+		seen[mini] = idx
+	}
+
+	return nil
+}
+
+// checkDuplicateDels returns an error if any of the passed in hashes appear more than once.
+// The scratch may be nil.
+func checkDuplicateDels(delHashes []Hash, scratch *ModifyScratch) error {
+	seen := scratch.delSeenMap(len(delHashes))
+	for idx, delHash := range delHashes {
+		foundIdx, found := seen[delHash]
+		if found {
+			return fmt.Errorf("%w: hash %s found at idx %d and %d",
+				ErrDuplicateDeletion, delHash, foundIdx, idx)
+		}
+		seen[delHash] = idx
+	}
+
+	return nil
+}
+
+// checkSortedAdds returns an error if the passed in adds are not in ascending order of
+// their hashes.
+func checkSortedAdds(adds []Leaf) error {
+	for i := 1; i < len(adds); i++ {
+		if bytes.Compare(adds[i-1].Hash[:], adds[i].Hash[:]) >= 0 {
+			return fmt.Errorf("%w: hash %s at idx %d is not less than hash %s at idx %d",
+				ErrUnsortedAdds, adds[i-1].Hash, i-1, adds[i].Hash, i)
+		}
+	}
+
+	return nil
+}
+
+// AddPreimages hashes each of the preimages into a leaf with HashLeaf and adds the
+// resulting leaves to the accumulator. The hashes of the added leaves are returned in
+// the same order as the preimages so that the caller may track them.
+func (p *Pollard) AddPreimages(preimages [][]byte, remember bool) ([]Hash, error) {
+	hashes := make([]Hash, len(preimages))
+	leaves := make([]Leaf, len(preimages))
+	for i, preimage := range preimages {
+		hashes[i] = HashLeaf(preimage)
+		leaves[i] = Leaf{Hash: hashes[i], Remember: remember}
+	}
+
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// MergeDisjoint merges the other pollard into this pollard. The other pollard must
+// represent the leaves added immediately after the leaves in this pollard. The result is
+// the same as the accumulator that would result from adding and deleting the leaves of
+// both pollards in order.
+//
+// The two can only be merged if the trees of the other pollard don't overlap with the
+// trees of this pollard. That is, the leaves of the other pollard must not be merged
+// with any of the existing trees when they're added. An error is returned if that's not
+// the case and neither of the pollards are modified.
+//
+// The resulting pollard is only full if both pollards were full. The nodes of the other
+// pollard are moved to this pollard so the other pollard shouldn't be used afterwards.
+func (p *Pollard) MergeDisjoint(other *Pollard) error {
+	if other.NumLeaves == 0 {
+		return nil
+	}
+
+	if p.NumLeaves != 0 {
+		// The lowest tree in this pollard determines how many leaves can be
+		// added before any of the existing trees get merged.
+		lowestTree := uint64(1) << bits.TrailingZeros64(p.NumLeaves)
+		if other.NumLeaves >= lowestTree {
+			return fmt.Errorf("MergeDisjoint fail. Can't merge %d leaves into "+
+				"%d leaves as the trees overlap. Can only merge up to %d leaves",
+				other.NumLeaves, p.NumLeaves, lowestTree-1)
+		}
+	}
+
+	for k := range other.NodeMap {
+		_, found := p.NodeMap[k]
+		if found {
+			return fmt.Errorf("MergeDisjoint fail. Hash %x exists in both pollards", k)
+		}
+	}
+
+	for k, v := range other.NodeMap {
+		p.NodeMap[k] = v
+	}
+	for k, v := range other.values {
+		if p.values == nil {
+			p.values = make(map[miniHash]uint64)
+		}
+		p.values[k] = v
+	}
+	for k, v := range other.expiries {
+		if p.expiries == nil {
+			p.expiries = make(map[miniHash]int32)
+		}
+		p.expiries[k] = v
+	}
+	p.totalValue += other.totalValue
+	p.Roots = append(p.Roots, other.Roots...)
+	p.NumLeaves += other.NumLeaves
+	p.NumDels += other.NumDels
+	p.Full = p.Full && other.Full
+
+	return nil
+}
+
+// Subforest returns a new pollard with only the trees of the roots at the given indexes.
+// The nodes of the selected trees are copied and this pollard is not modified.
+//
+// The NumLeaves of the new pollard is the sum of the leaves the selected trees were
+// allocated for. Since the shape of the forest is determined by NumLeaves, the positions
+// of the nodes in the new pollard are different from their positions in this pollard.
+// The NumDels of the new pollard is only set if this pollard is full as the number of
+// deleted leaves under a tree is otherwise unknown.
+func (p *Pollard) Subforest(rootIndices []int) (*Pollard, error) {
+	indices := make([]int, len(rootIndices))
+	copy(indices, rootIndices)
+	sort.Ints(indices)
+
+	// The rows of the roots from the biggest tree to the smallest.
+	rootRows := make([]uint8, 0, len(p.Roots))
+	for h := int(treeRows(p.NumLeaves)); h >= 0; h-- {
+		if rootExistsOnRow(p.NumLeaves, uint8(h)) {
+			rootRows = append(rootRows, uint8(h))
+		}
+	}
+
+	sub := NewAccumulator(p.Full)
+	sub.Hasher = p.Hasher
+	for i, idx := range indices {
+		if idx < 0 || idx >= len(p.Roots) {
+			return nil, fmt.Errorf("Subforest fail. Root index %d is out of "+
+				"range for %d roots", idx, len(p.Roots))
+		}
+		if i > 0 && indices[i-1] == idx {
+			return nil, fmt.Errorf("Subforest fail. Root index %d is duplicated", idx)
+		}
+
+		sub.Roots = append(sub.Roots, p.cloneNode(p.Roots[idx], nil, sub.NodeMap))
+		sub.NumLeaves += uint64(1) << rootRows[idx]
+	}
+
+	for k := range sub.NodeMap {
+		if v, found := p.values[k]; found {
+			if sub.values == nil {
+				sub.values = make(map[miniHash]uint64)
+			}
+			sub.values[k] = v
+			sub.totalValue += v
+		}
+		if v, found := p.expiries[k]; found {
+			if sub.expiries == nil {
+				sub.expiries = make(map[miniHash]int32)
+			}
+			sub.expiries[k] = v
+		}
+	}
+	if sub.Full {
+		sub.NumDels = sub.NumLeaves - uint64(len(sub.NodeMap))
+	}
+
+	return &sub, nil
+}
+
+// add adds all the passed in leaves to the accumulator.
+func (p *Pollard) add(adds []Leaf) {
+	for _, add := range adds {
+		// Create a node from the hash. If the pollard is Full, then remember
+		// every node.
+		node := &polNode{data: add.Hash, remember: add.Remember}
+		if p.Full {
+			node.remember = true
+		}
+
+		// A hash that's added again is no longer deleted.
+		delete(p.tombstones, add.mini())
+
+		// Add the hash to the map if this node is supposed to be remembered.
+		if node.remember {
+			p.NodeMap[add.mini()] = node
+
+			if p.TrackAddIndex {
+				p.setAddIndex(p.NumLeaves, add.Hash)
+			}
+		}
+
+		// The leaf is added in the block after the current height. Any expiry left
+		// over from an earlier add of the same hash is removed.
+		if add.TTL != 0 && node.remember {
+			if p.expiries == nil {
+				p.expiries = make(map[miniHash]int32)
+			}
+			p.expiries[add.mini()] = int32(p.height+1) + add.TTL
+		} else {
+			delete(p.expiries, add.mini())
+		}
+
+		if add.Value != 0 {
+			if p.values == nil {
+				p.values = make(map[miniHash]uint64)
+			}
+			p.values[add.mini()] = add.Value
+			p.totalValue += add.Value
+		}
+
+		newRoot := p.calculateNewRoot(node)
+		p.Roots = append(p.Roots, newRoot)
+
+		// Increment as we added a leaf.
+		p.NumLeaves++
+
+		if p.OnProgress != nil && p.ProgressInterval != 0 &&
+			p.NumLeaves%p.ProgressInterval == 0 {
+
+			p.OnProgress(p.NumLeaves)
+		}
+	}
+}
+
+// RootsChangedByAdd returns how many of the current roots would be merged away and how
+// many new roots would be created if n leaves were added to the accumulator. It's
+// calculated only from the NumLeaves and doesn't modify the accumulator.
+func (p *Pollard) RootsChangedByAdd(n uint64) (removed, added int) {
+	newNumLeaves := p.NumLeaves + n
+
+	// A root at row h stays a root only if none of the additions carry over to
+	// row h. That's the case if all the bits from row h and up remain the same.
+	for h := uint8(0); h < 64; h++ {
+		if (p.NumLeaves>>h)&1 == 1 && newNumLeaves>>h != p.NumLeaves>>h {
+			removed++
+		}
+	}
+	added = int(numRoots(newNumLeaves)) - (int(numRoots(p.NumLeaves)) - removed)
+
+	return removed, added
+}
+
+// calculateNewRoot adds the node to the accumulator and calculates the new root.
+func (p *Pollard) calculateNewRoot(node *polNode) *polNode {
+	// We can tell where the roots are by looking at the binary representation
+	// of the NumLeaves. Wherever there's a 1, there's a root.
+	//
+	// NumLeaves of 8 will be '1000' in binary, so there will be one root at
+	// row 3. NumLeaves of 3 will be '11' in binary, so there's two roots. One at
+	// row 0 and one at row 1.
+	//
+	// In this loop below, we're looking for these roots by checking if there's
+	// a '1'. If there is a '1', we'll hash the root being added with that root
+	// until we hit a '0'.
+	for h := uint8(0); (p.NumLeaves>>h)&1 == 1; h++ {
+		// Grab and pop off the root that will become a node.
+		// NOTE Explicitly not niling out the polNode for GC as we still need it.
+		root := p.Roots[len(p.Roots)-1]
+		p.Roots = p.Roots[:len(p.Roots)-1]
+
+		// If the root that we're gonna hash with is empty, move the current
+		// node up to the position of the parent.
+		//
+		// Example:
+		//
+		// 12
+		// |-------\
+		// 08      09
+		// |---\   |---\
+		// 00  01  02  03  --
+		//
+		// When we add 05 to this tree, 04 is empty so we move 05 to 10.
+		// The resulting tree looks like below. The hash at position 10
+		// is not hash(04 || 05) but just the hash of 05.
+		//
+		// 12
+		// |-------\
+		// 08      09      10
+		// |---\   |---\   |---\
+		// 00  01  02  03  --  --
+		if root.data == empty {
+			continue
+		}
+
+		// Roots point to their children. Those children become nieces here.
+		swapNieces(root, node)
+
+		// Calculate the hash of the new root.
+		nHash := p.hasher()(root.data, node.data)
+
+		newRoot := &polNode{data: nHash, lNiece: root, rNiece: node}
+		if p.Full {
+			newRoot.remember = true
+		}
+
+		// Set aunt.
+		updateAunt(newRoot)
+		newRoot.prune()
+		node = newRoot
+	}
+
+	return node
+}
+
+// remove removes all the positions that are passed in.
+func (p *Pollard) remove(dels []uint64) error {
+	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
+
+	totalRows := treeRows(p.NumLeaves)
+	dels = deTwin(dels, totalRows)
+
+	for _, del := range dels {
+		// If a root is being deleted, then we mark it and all the leaves below
+		// it to be deleted.
+		if isRootPosition(del, p.NumLeaves) {
+			err := p.deleteRoot(del)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := p.deleteSingle(del)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RemapPosition returns the position that the leaf at oldPos moves to after a modify
+// with the given deletions. numAddsBefore is the number of leaves the accumulator had
+// before the modify and the pollard is expected to be in the state after the modify.
+// The returned bool is false if the leaf at oldPos was deleted.
+func (p *Pollard) RemapPosition(oldPos uint64, dels []uint64, numAddsBefore uint64) (uint64, bool) {
+	forestRows := treeRows(numAddsBefore)
+
+	sortedDels := copySortedFunc(dels, uint64Less)
+	sortedDels = deTwin(sortedDels, forestRows)
+
+	pos := oldPos
+	for _, del := range sortedDels {
+		if del == pos || isAncestor(del, pos, forestRows) {
+			return 0, false
+		}
+
+		// Deleted roots become empty and don't move any of the other nodes.
+		if isRootPosition(del, numAddsBefore) {
+			continue
+		}
+
+		// If the sibling of the position or the sibling of an ancestor is
+		// deleted, the position moves up.
+		if isAncestor(parent(del, forestRows), pos, forestRows) {
+			var err error
+			pos, err = calcNextPosition(pos, del, forestRows)
+			if err != nil {
+				return 0, false
+			}
+		}
+	}
+
+	// The additions may have increased the rows of the forest.
+	afterRows := treeRows(p.NumLeaves)
+	if afterRows > forestRows {
+		pos = translatePos(pos, forestRows, afterRows)
+	}
+
+	return pos, true
+}
+
+// delete root removes all the pointers to and from this root and places an
+// empty hash at this root.
+func (p *Pollard) deleteRoot(del uint64) error {
+	tree, _, _, err := detectOffset(del, p.NumLeaves)
+	if err != nil {
+		return err
+	}
+	if tree > uint8(len(p.Roots)-1) {
+		return fmt.Errorf("getNode error: couldn't fetch %d, "+
+			"calculated root index of %d but only have %d roots",
+			del, tree, len(p.Roots))
+	}
+
+	// Delete from map.
+	delete(p.NodeMap, p.Roots[tree].data.mini())
+
+	if p.Roots[tree].lNiece != nil {
+		p.Roots[tree].lNiece.aunt = nil
+	}
+	if p.Roots[tree].rNiece != nil {
+		p.Roots[tree].rNiece.aunt = nil
+	}
+	p.Roots[tree].chop()
+	p.Roots[tree].aunt = nil
+	p.Roots[tree].data = empty
+
+	return nil
+}
+
+// deleteSingle deletes one leaf from the accumulator and re-hashes the root.
+func (p *Pollard) deleteSingle(del uint64) error {
+	// Fetch all the needed nodes.
+	from := sibling(del)
+	fromNode, fromNodeSib, _, err := p.getNode(from)
+	if err != nil {
+		return err
+	}
+	toNode, err := fromNodeSib.getParent()
+	if err != nil {
+		return err
+	}
+	toSib := fromNodeSib.aunt
+
+	// If the position I'm moving to has an aunt, I'm not becoming a root.
+	if toNode.aunt != nil {
+		// Move myself up.
+		transferAunt(fromNode, toNode)
+		transferNiece(fromNode, toNode)
+
+		// Move my children up.
+		transferNiece(toSib, fromNodeSib)
+
+		updateAunt(toNode.aunt)
+	} else {
+		// My data is given to the root.
+		*toNode = *fromNode
+
+		// Get my children from my sibling as I'm a root now.
+		transferNiece(toNode, fromNodeSib)
+
+		// Update all the nieces to point at me.
+		updateAunt(toNode)
+
+		// Delete my former self.
+		delNode(fromNode)
 
 		// If the node was a leaf, update the map to point to the root.
 		_, found := p.NodeMap[toNode.data.mini()]
 		if found {
-			p.NodeMap[toNode.data.mini()] = toNode
+			p.NodeMap[toNode.data.mini()] = toNode
+		}
+	}
+
+	// Delete the node from the map.
+	delete(p.NodeMap, fromNodeSib.data.mini())
+	delNode(fromNodeSib)
+
+	// If to position is a root, there's no parent hash to be calculated so
+	// return early.
+	totalRows := treeRows(p.NumLeaves)
+	to := parent(del, totalRows)
+	if isRootPosition(to, p.NumLeaves) {
+		toNode.aunt = nil
+		return nil
+	}
+	parentNode, err := toNode.getParent()
+	if err != nil {
+		return err
+	}
+
+	// Set aunt.
+	toNode.aunt, err = parentNode.getSibling()
+	if err != nil {
+		return err
+	}
+
+	// If there's no sibling, it means that toNode is a children of
+	// the root. Make it point to the parent.
+	if toNode.aunt == nil {
+		toNode.aunt = parentNode
+	}
+
+	// Hash this node and all the parents/ancestors of this node.
+	err = hashToRoot(parentNode, p.hasher())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// deleteFromMap deletes the hashes passed in from the node map.
+func (p *Pollard) deleteFromMap(delHashes []Hash) {
+	for _, del := range delHashes {
+		delete(p.NodeMap, del.mini())
+		delete(p.hot, del.mini())
+		delete(p.expiries, del.mini())
+		p.removeAddIndex(del)
+	}
+}
+
+// setAddIndex tracks the hash as the leaf added at the add index.
+func (p *Pollard) setAddIndex(addIndex uint64, hash Hash) {
+	if p.addIndexes == nil {
+		p.addIndexes = make(map[uint64]Hash)
+		p.addIndexOf = make(map[miniHash]uint64)
+	}
+	p.removeAddIndex(hash)
+	p.addIndexes[addIndex] = hash
+	p.addIndexOf[hash.mini()] = addIndex
+}
+
+// getAddIndex returns the add index of the hash and whether it's tracked.
+func (p *Pollard) getAddIndex(hash Hash) (uint64, bool) {
+	addIndex, found := p.addIndexOf[hash.mini()]
+	if !found || p.addIndexes[addIndex] != hash {
+		return 0, false
+	}
+
+	return addIndex, true
+}
+
+// removeAddIndex stops tracking the add index of the hash.
+func (p *Pollard) removeAddIndex(hash Hash) {
+	addIndex, found := p.getAddIndex(hash)
+	if !found {
+		return
+	}
+	delete(p.addIndexes, addIndex)
+	delete(p.addIndexOf, hash.mini())
+}
+
+// removeValues removes the values of the deleted hashes from the total value.
+func (p *Pollard) removeValues(delHashes []Hash) {
+	for _, del := range delHashes {
+		value, found := p.values[del.mini()]
+		if !found {
+			continue
+		}
+		p.totalValue -= value
+		delete(p.values, del.mini())
+	}
+}
+
+// TotalValue returns the sum of the values of all the leaves that are currently in the
+// accumulator. The values are a side statistic and don't affect the roots.
+//
+// NOTE Undo can't bring back the values of the deleted leaves so it returns
+// ErrUndoDataRequired for them. UndoFromData reverts all the changes to the total value.
+func (p *Pollard) TotalValue() uint64 {
+	return p.totalValue
+}
+
+// LeavesExpiringBy returns the cached leaves that were added with a TTL that ends at or
+// before the given block. The blocks are the heights of the accumulator so a leaf added
+// with a TTL of 10 in the modify that brought the height to 5 expires by block 15. The
+// leaves are sorted by when they expire and then by their positions.
+//
+// NOTE Undo can't bring back the TTLs of the leaves that were deleted in the undone
+// block so it returns ErrUndoDataRequired for them. UndoFromData brings them back.
+func (p *Pollard) LeavesExpiringBy(block int32) []Hash {
+	type expiringNode struct {
+		nodeAndPos
+		expiry int32
+	}
+	var expiring []expiringNode
+	for k, expiry := range p.expiries {
+		if expiry > block {
+			continue
+		}
+		node, found := p.NodeMap[k]
+		if !found {
+			continue
+		}
+		expiring = append(expiring,
+			expiringNode{nodeAndPos{node, p.calculatePosition(node)}, expiry})
+	}
+	sort.Slice(expiring, func(a, b int) bool {
+		if expiring[a].expiry != expiring[b].expiry {
+			return expiring[a].expiry < expiring[b].expiry
+		}
+		return expiring[a].pos < expiring[b].pos
+	})
+
+	hashes := make([]Hash, len(expiring))
+	for i, e := range expiring {
+		hashes[i] = e.node.data
+	}
+
+	return hashes
+}
+
+// Undo reverts the most recent modify that happened to the accumulator. The passed in numAdds,
+// dels and delHashes should correspond to block being un-done. prevRoots should be of the block
+// that the caller is trying to go back to.
+//
+// Ex: If the caller is trying to go back to block 9, the numAdds, dels, and delHashes should be
+// the adds and dels that happened to get to block 10. prevRoots should be the roots at block 9.
+//
+// If the pollard tracks the values or the TTLs of its leaves, ErrUndoDataRequired is
+// returned for a modify that deleted leaves as their values and TTLs aren't known.
+// UndoFromData has to be used for those.
+//
+// NOTE The add indexes of the deleted leaves are not brought back. Use UndoFromData to
+// revert them as well.
+func (p *Pollard) Undo(numAdds uint64, proof Proof, delHashes []Hash, prevRoots []Hash) error {
+	if len(delHashes) > 0 && (p.values != nil || p.expiries != nil) {
+		return fmt.Errorf("Undo fail. %w", ErrUndoDataRequired)
+	}
+
+	return p.undo(numAdds, proof, delHashes, prevRoots)
+}
+
+// undo is Undo without the check for the values and the TTLs of the deleted leaves. The
+// values and the TTLs of the added leaves are still removed.
+func (p *Pollard) undo(numAdds uint64, proof Proof, delHashes []Hash, prevRoots []Hash) error {
+	p.epoch++
+
+	for i := 0; i < int(numAdds); i++ {
+		p.undoSingleAdd()
+	}
+	err := p.undoEmptyRoots(numAdds, proof.Targets, prevRoots)
+	if err != nil {
+		return err
+	}
+
+	err = p.undoDels(proof.Targets, delHashes)
+	if err != nil {
+		return err
+	}
+
+	// The deleted leaves are back in the accumulator.
+	for _, delHash := range delHashes {
+		delete(p.tombstones, delHash.mini())
+	}
+	p.lastModify = modifySignature{}
+	if p.height > 0 {
+		p.height--
+	}
+	if len(p.history) > 0 {
+		p.history = p.history[:len(p.history)-1]
+	}
+
+	return p.writeCommitment(CommitmentRollback)
+}
+
+// UndoData is all the data needed to undo a modify. It's returned by ModifyWithUndo
+// and consumed by UndoFromData.
+type UndoData struct {
+	// NumAdds is the number of leaves that were added.
+	NumAdds uint64
+
+	// DelHashes are the hashes of the leaves that were deleted.
+	DelHashes []Hash
+
+	// Targets are the positions of the deleted leaves before they were deleted.
+	Targets []uint64
+
+	// PrevRoots are the roots before the modify.
+	PrevRoots []Hash
+
+	// AddHashes are the hashes of the leaves that were added.
+	AddHashes []Hash
+
+	// DelValues are the values of the deleted leaves in the same order as DelHashes.
+	DelValues []uint64
+
+	// DelAddIndexes maps the deleted leaves that had their add index tracked to their
+	// add indexes.
+	DelAddIndexes map[Hash]uint64
+
+	// DelExpiries maps the deleted leaves that were added with a TTL to the block they
+	// were expected to be deleted at.
+	DelExpiries map[Hash]int32
+}
+
+// ModifyWithUndo is Modify but it also returns the data needed to undo the modify with
+// UndoFromData. targets are the positions of the leaves being deleted.
+func (p *Pollard) ModifyWithUndo(adds []Leaf, dels []Hash, targets []uint64) (UndoData, error) {
+	ud := UndoData{
+		NumAdds:   uint64(len(adds)),
+		DelHashes: make([]Hash, len(dels)),
+		Targets:   make([]uint64, len(targets)),
+		PrevRoots: p.GetRoots(),
+		AddHashes: make([]Hash, len(adds)),
+		DelValues: make([]uint64, len(dels)),
+	}
+	copy(ud.DelHashes, dels)
+	copy(ud.Targets, targets)
+	for i, add := range adds {
+		ud.AddHashes[i] = add.Hash
+	}
+	for i, del := range dels {
+		ud.DelValues[i] = p.values[del.mini()]
+	}
+	for _, del := range dels {
+		addIndex, found := p.getAddIndex(del)
+		if !found {
+			continue
+		}
+		if ud.DelAddIndexes == nil {
+			ud.DelAddIndexes = make(map[Hash]uint64)
+		}
+		ud.DelAddIndexes[del] = addIndex
+	}
+	for _, del := range dels {
+		expiry, found := p.expiries[del.mini()]
+		if !found {
+			continue
+		}
+		if ud.DelExpiries == nil {
+			ud.DelExpiries = make(map[Hash]int32)
+		}
+		ud.DelExpiries[del] = expiry
+	}
+
+	err := p.Modify(adds, dels, Proof{Targets: targets})
+	if err != nil {
+		return UndoData{}, err
+	}
+
+	return ud, nil
+}
+
+// UndoFromData reverts the most recent modify with the undo data that was returned
+// by ModifyWithUndo. Unlike Undo, the values, the TTLs and the add indexes of the
+// deleted leaves are brought back as well.
+func (p *Pollard) UndoFromData(ud UndoData) error {
+	err := p.undo(ud.NumAdds, Proof{Targets: ud.Targets}, ud.DelHashes, ud.PrevRoots)
+	if err != nil {
+		return err
+	}
+
+	// Undo already removed the values of the adds that were cached.
+	p.removeValues(ud.AddHashes)
+	for i, value := range ud.DelValues {
+		if value == 0 || i >= len(ud.DelHashes) {
+			continue
+		}
+		if p.values == nil {
+			p.values = make(map[miniHash]uint64)
+		}
+		p.values[ud.DelHashes[i].mini()] = value
+		p.totalValue += value
+	}
+	for hash, addIndex := range ud.DelAddIndexes {
+		p.setAddIndex(addIndex, hash)
+	}
+	for hash, expiry := range ud.DelExpiries {
+		if p.expiries == nil {
+			p.expiries = make(map[miniHash]int32)
+		}
+		p.expiries[hash.mini()] = expiry
+	}
+
+	return nil
+}
+
+// undoEmptyRoots places empty roots back in after undoing the additions.
+func (p *Pollard) undoEmptyRoots(numAdds uint64, origDels []uint64, prevRoots []Hash) error {
+	if len(p.Roots) >= int(numRoots(p.NumLeaves)) {
+		return nil
+	}
+	dels := make([]uint64, len(origDels))
+	copy(dels, origDels)
+
+	// Sort before detwining.
+	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
+	dels = deTwin(dels, treeRows(p.NumLeaves))
+
+	// Copy to avoid mutating the original.
+	copyRoots := make([]Hash, len(prevRoots))
+	copy(copyRoots, prevRoots)
+
+	// Add in the empty roots that was removed by the deletions to the prevRoots.
+	for _, del := range dels {
+		if isRootPosition(del, p.NumLeaves) {
+			tree, _, _, err := detectOffset(del, p.NumLeaves)
+			if err != nil {
+				return err
+			}
+			copyRoots[tree] = empty
+		}
+	}
+
+	// Add empty roots that was present in the previous roots.
+	for i, prevRoot := range copyRoots {
+		if prevRoot == empty {
+			for i >= len(p.Roots) {
+				p.Roots = append(p.Roots, &polNode{remember: p.Full})
+				continue
+			}
+			if p.Roots[i].data != empty {
+				p.Roots = append(p.Roots, nil)
+				copy(p.Roots[i+1:], p.Roots[i:])
+				p.Roots[i] = &polNode{data: prevRoot, remember: p.Full}
+			}
+		}
+	}
+
+	return nil
+}
+
+// undoSingleAdd undoes one leaf that was added to the accumulator.
+func (p *Pollard) undoSingleAdd() {
+	lowestRootRow := getLowestRoot(p.NumLeaves, treeRows(p.NumLeaves))
+	for row := int(lowestRootRow); row >= 0; row-- {
+		lowestRoot := p.Roots[len(p.Roots)-1]
+		p.Roots = p.Roots[:len(p.Roots)-1]
+
+		lNiece, rNiece := lowestRoot.lNiece, lowestRoot.rNiece
+
+		if lNiece != nil {
+			swapNieces(lNiece, rNiece)
+			lNiece.aunt, rNiece.aunt = nil, nil
+			p.Roots = append(p.Roots, lNiece, rNiece)
+		} else {
+			row = -1
+		}
+
+		delete(p.NodeMap, lowestRoot.data.mini())
+		delete(p.expiries, lowestRoot.data.mini())
+		p.removeValues([]Hash{lowestRoot.data})
+		delNode(lowestRoot)
+	}
+	p.NumLeaves--
+	if hash, found := p.addIndexes[p.NumLeaves]; found {
+		p.removeAddIndex(hash)
+	}
+}
+
+func (p *Pollard) undoDels(dels []uint64, delHashes []Hash) error {
+	if len(dels) != len(delHashes) {
+		return fmt.Errorf("Got %d targets to be deleted but have %d hashes",
+			len(dels), len(delHashes))
+	}
+
+	pnps := make([]nodeAndPos, len(dels))
+	for i := range dels {
+		pn := &polNode{data: delHashes[i], remember: p.Full}
+		pnps[i] = nodeAndPos{pn, dels[i]}
+
+		p.NodeMap[delHashes[i].mini()] = pn
+	}
+	sort.Slice(pnps, func(a, b int) bool { return pnps[a].pos < pnps[b].pos })
+
+	totalRows := treeRows(p.NumLeaves)
+	pnps = deTwinPolNode(pnps, totalRows, p.hasher())
+
+	// Go through all the de-twined nodes and all from the highest position first.
+	for i := len(pnps) - 1; i >= 0; i-- {
+		pnp := pnps[i]
+
+		if isRootPosition(pnp.pos, p.NumLeaves) {
+			tree, _, _, err := detectOffset(pnp.pos, p.NumLeaves)
+			if err != nil {
+				return err
+			}
+			p.Roots[tree] = pnp.node
+			continue
+		} else {
+			err := p.undoSingleDel(pnp.node, pnp.pos)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	p.NumDels -= uint64(len(delHashes))
+
+	return nil
+}
+
+func (p *Pollard) undoSingleDel(node *polNode, pos uint64) error {
+	totalRows := treeRows(p.NumLeaves)
+
+	siblingPos := parent(pos, totalRows)
+	sibling, aunt, _, err := p.getNode(siblingPos)
+	if err != nil {
+		return fmt.Errorf("Couldn't undo %s at position %d, err: %v",
+			hex.EncodeToString(node.data[:]), pos, err)
+	}
+
+	pHash := calculateParentHash(pos, node, sibling, p.hasher())
+	parent := &polNode{data: pHash, remember: p.Full}
+
+	// If the original parent of the deleted node is not a root.
+	if sibling.aunt != nil {
+		transferAunt(parent, sibling)
+		transferNiece(parent, sibling)
+		updateAunt(parent)
+
+		auntLNiece := aunt.lNiece
+		auntRNiece := aunt.rNiece
+		if isLeftNiece(pos) {
+			aunt.lNiece = node
+			aunt.rNiece = sibling
+		} else {
+			aunt.lNiece = sibling
+			aunt.rNiece = node
+		}
+		updateAunt(aunt)
+
+		transferNiece(sibling, node)
+
+		node.lNiece = auntLNiece
+		node.rNiece = auntRNiece
+		updateAunt(node)
+	} else {
+		// We're moving the parent to sibling position.
+		*sibling, *parent = *parent, *sibling
+		sibling, parent = parent, sibling
+
+		if isLeftNiece(pos) {
+			parent.lNiece = node
+			parent.rNiece = sibling
+		} else {
+			parent.lNiece = sibling
+			parent.rNiece = node
+		}
+		updateAunt(parent)
+		updateAunt(sibling)
+
+		swapNieces(parent.lNiece, parent.rNiece)
+
+		_, found := p.NodeMap[sibling.data.mini()]
+		if found {
+			p.NodeMap[sibling.data.mini()] = sibling
+		}
+
+		return nil
+	}
+
+	err = hashToRoot(parent, p.hasher())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRoots returns the hashes of all the roots.
+func (p *Pollard) GetRoots() []Hash {
+	roots := make([]Hash, 0, len(p.Roots))
+
+	for _, root := range p.Roots {
+		roots = append(roots, root.data)
+	}
+
+	return roots
+}
+
+// String is a wrapper around utreexo.String(). Returns a string representation of the pollard
+// that's less than 6 rows tall.
+func (p *Pollard) String() string {
+	return String(p)
+}
+
+// CachedLeaves returns the hashes of all the leaves that are cached in the pollard,
+// sorted by their positions. For a full pollard, these are all the leaves in the
+// accumulator.
+func (p *Pollard) CachedLeaves() []Hash {
+	nodes := make([]nodeAndPos, 0, len(p.NodeMap))
+	for _, node := range p.NodeMap {
+		nodes = append(nodes, nodeAndPos{node, p.calculatePosition(node)})
+	}
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].pos < nodes[b].pos })
+
+	hashes := make([]Hash, len(nodes))
+	for i, n := range nodes {
+		hashes[i] = n.node.data
+	}
+
+	return hashes
+}
+
+// FindByPrefix returns the hashes of all the cached leaves that start with the given
+// prefix, sorted by their positions. An empty prefix matches all the cached leaves.
+// It's meant for debugging where only part of a leaf hash is known.
+func (p *Pollard) FindByPrefix(prefix []byte) ([]Hash, error) {
+	if len(prefix) > len(Hash{}) {
+		return nil, fmt.Errorf("FindByPrefix fail. Prefix is %d bytes but "+
+			"hashes are only %d bytes", len(prefix), len(Hash{}))
+	}
+
+	var nodes []nodeAndPos
+	for _, node := range p.NodeMap {
+		if bytes.HasPrefix(node.data[:], prefix) {
+			nodes = append(nodes, nodeAndPos{node, p.calculatePosition(node)})
+		}
+	}
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].pos < nodes[b].pos })
+
+	hashes := make([]Hash, len(nodes))
+	for i, n := range nodes {
+		hashes[i] = n.node.data
+	}
+
+	return hashes, nil
+}
+
+// EmptyPositions returns the positions in the forest that are empty due to deletions.
+//
+// NOTE When a leaf that's not a root is deleted, its sibling moves up to the parent
+// position right away and no empty position is left behind. Only the roots are left
+// empty when they're deleted and they stay empty until an addition merges over them.
+// So the returned positions are the positions of the empty roots.
+func (p *Pollard) EmptyPositions() []uint64 {
+	var positions []uint64
+	rootPositions := RootPositions(p.NumLeaves, treeRows(p.NumLeaves))
+	for i, root := range p.Roots {
+		if root.data == empty {
+			positions = append(positions, rootPositions[i])
+		}
+	}
+
+	return positions
+}
+
+// CheckSubtree checks that all the hashes in the tree under the root at rootIdx are
+// the hash of their children. It's a cheaper alternative to checking the whole forest
+// when only the trees that were modified need to be checked.
+func (p *Pollard) CheckSubtree(rootIdx int) error {
+	if rootIdx < 0 || rootIdx >= len(p.Roots) {
+		return fmt.Errorf("CheckSubtree fail. Root index %d is out of range "+
+			"for %d roots", rootIdx, len(p.Roots))
+	}
+
+	err := checkRootHashes(p.Roots[rootIdx], p.hasher())
+	if err != nil {
+		return fmt.Errorf("CheckSubtree fail for root %d. %v", rootIdx, err)
+	}
+
+	return nil
+}
+
+// RecomputeRoot recalculates the hash of the root at rootIdx from the cached leaves under
+// it without using any of the cached intermediate hashes. An error is returned if any
+// of the nodes needed to recalculate the root aren't cached. The hash of an empty root
+// is the empty hash.
+func (p *Pollard) RecomputeRoot(rootIdx int) (Hash, error) {
+	if rootIdx < 0 || rootIdx >= len(p.Roots) {
+		return empty, fmt.Errorf("RecomputeRoot fail. Root index %d is out of range "+
+			"for %d roots", rootIdx, len(p.Roots))
+	}
+
+	root := p.Roots[rootIdx]
+	if root.data == empty {
+		return empty, nil
+	}
+
+	forestRows := treeRows(p.NumLeaves)
+	rootPos := RootPositions(p.NumLeaves, forestRows)[rootIdx]
+	hash, err := p.recomputeNode(root, nil, true, rootPos, forestRows)
+	if err != nil {
+		return empty, fmt.Errorf("RecomputeRoot fail for root %d. %v", rootIdx, err)
+	}
+
+	return hash, nil
+}
+
+// recomputeNode recalculates the hash of the node at pos from the cached leaves under
+// it. sibling is the sibling of the node which points to the children of the node.
+func (p *Pollard) recomputeNode(n, sibling *polNode, isRoot bool, pos uint64,
+	forestRows uint8) (Hash, error) {
+
+	// Leaves that moved up from the bottom row are in the node map.
+	if n != nil {
+		mapNode, found := p.NodeMap[n.data.mini()]
+		if (found && mapNode == n) || detectRow(pos, forestRows) == 0 {
+			return n.data, nil
+		}
+	}
+
+	// The node itself doesn't need to be cached as long as its children are. The
+	// children of a root are pointed to by the root and the children of other nodes are
+	// pointed to by their sibling.
+	var lChild, rChild *polNode
+	switch {
+	case isRoot:
+		lChild, rChild = n.lNiece, n.rNiece
+	case sibling != nil && detectRow(pos, forestRows) != 0:
+		lChild, rChild = sibling.lNiece, sibling.rNiece
+	default:
+		return empty, fmt.Errorf("position %d isn't cached", pos)
+	}
+
+	left, err := p.recomputeNode(lChild, rChild, false, leftChild(pos, forestRows), forestRows)
+	if err != nil {
+		return empty, err
+	}
+	right, err := p.recomputeNode(rChild, lChild, false, rightChild(pos, forestRows), forestRows)
+	if err != nil {
+		return empty, err
+	}
+
+	return p.hasher()(left, right), nil
+}
+
+// CheckPositionConsistency checks that every node in the node map is the node that's
+// at the position calculated for it and that it's keyed by its own hash.
+func (p *Pollard) CheckPositionConsistency() error {
+	for mHash, node := range p.NodeMap {
+		if node == nil {
+			return fmt.Errorf("Node in nodemap is nil. Key: %s",
+				hex.EncodeToString(mHash[:]))
+		}
+		if node.data.mini() != mHash {
+			return fmt.Errorf("Node %s in nodemap is under the key %s",
+				hex.EncodeToString(node.data[:]), hex.EncodeToString(mHash[:]))
+		}
+
+		pos := p.calculatePosition(node)
+		gotNode, _, _, err := p.getNode(pos)
+		if err != nil {
+			return err
+		}
+
+		if gotNode == nil {
+			return fmt.Errorf("Couldn't fetch pos %d, expected %s",
+				pos, hex.EncodeToString(node.data[:]))
+		}
+
+		if gotNode != node {
+			return fmt.Errorf("Calculated pos %d for node %s but read a "+
+				"different node %s",
+				pos, hex.EncodeToString(node.data[:]),
+				hex.EncodeToString(gotNode.data[:]))
+		}
+	}
+
+	return nil
+}
+
+// RebuildNodeMap re-derives the node map from the roots and their nieces and returns the
+// number of entries that were fixed. Stale entries and entries pointing to the wrong
+// node are removed and the missing leaves are added back. A leaf is a remembered node
+// without any children.
+//
+// NOTE The node map isn't modified if an error is returned.
+func (p *Pollard) RebuildNodeMap() (int, error) {
+	leaves := make(map[miniHash]*polNode, len(p.NodeMap))
+	for _, root := range p.Roots {
+		err := collectRootLeaves(root, leaves)
+		if err != nil {
+			return 0, fmt.Errorf("RebuildNodeMap fail. %w", err)
 		}
 	}
 
-	// Delete the node from the map.
-	delete(p.NodeMap, fromNodeSib.data.mini())
-	delNode(fromNodeSib)
+	fixed := 0
+	for mHash, node := range p.NodeMap {
+		if leaves[mHash] != node {
+			delete(p.NodeMap, mHash)
+			fixed++
+		}
+	}
+	for mHash, node := range leaves {
+		if _, found := p.NodeMap[mHash]; !found {
+			p.NodeMap[mHash] = node
+			fixed++
+		}
+	}
 
-	// If to position is a root, there's no parent hash to be calculated so
-	// return early.
-	totalRows := treeRows(p.NumLeaves)
-	to := parent(del, totalRows)
-	if isRootPosition(to, p.NumLeaves) {
-		toNode.aunt = nil
-		return nil
+	return fixed, nil
+}
+
+// LiveLeavesPerRoot returns the number of live leaves under each of the roots in the
+// same order as the roots. The sum of the counts is numLeaves - numDels.
+//
+// NOTE The pollard must be full as the leaves that aren't cached can't be counted.
+func (p *Pollard) LiveLeavesPerRoot() ([]uint64, error) {
+	if !p.Full {
+		return nil, fmt.Errorf("LiveLeavesPerRoot fail. Pollard is not full and " +
+			"doesn't have all the leaves")
 	}
-	parentNode, err := toNode.getParent()
-	if err != nil {
-		return err
+
+	counts := make([]uint64, len(p.Roots))
+	leaves := make(map[miniHash]*polNode, len(p.NodeMap))
+	for i, root := range p.Roots {
+		before := len(leaves)
+		err := collectRootLeaves(root, leaves)
+		if err != nil {
+			return nil, fmt.Errorf("LiveLeavesPerRoot fail. %w", err)
+		}
+		counts[i] = uint64(len(leaves) - before)
 	}
 
-	// Set aunt.
-	toNode.aunt, err = parentNode.getSibling()
-	if err != nil {
-		return err
+	return counts, nil
+}
+
+// ToPositionalArray returns the hashes of the accumulator in the classic forest layout
+// along with the numLeaves. The hash at each index is the hash at that position and the
+// positions that are empty or outside of the forest are the empty hash. The array is
+// empty if there are no leaves.
+//
+// NOTE The pollard must be full as the positions that aren't cached can't be filled in.
+func (p *Pollard) ToPositionalArray() ([]Hash, uint64, error) {
+	if !p.Full {
+		return nil, 0, fmt.Errorf("ToPositionalArray fail. Pollard is not full and " +
+			"doesn't have all the hashes")
+	}
+	if p.NumLeaves == 0 {
+		return []Hash{}, 0, nil
 	}
 
-	// If there's no sibling, it means that toNode is a children of
-	// the root. Make it point to the parent.
-	if toNode.aunt == nil {
-		toNode.aunt = parentNode
+	forestRows := treeRows(p.NumLeaves)
+	hashes := make([]Hash, maxPosition(forestRows))
+	for pos := range hashes {
+		if !inForest(uint64(pos), p.NumLeaves, forestRows) {
+			continue
+		}
+		hashes[pos] = p.getHash(uint64(pos))
 	}
 
-	// Hash this node and all the parents/ancestors of this node.
-	err = hashToRoot(parentNode)
-	if err != nil {
-		return err
+	return hashes, p.NumLeaves, nil
+}
+
+// collectRootLeaves adds all the remembered leaves under the root to the leaves map. The
+// root itself is a leaf if it doesn't have any children.
+func collectRootLeaves(root *polNode, leaves map[miniHash]*polNode) error {
+	if root.deadEnd() {
+		return collectLeaf(root, leaves)
+	}
+	return collectLeaves(root.lNiece, root.rNiece, leaves)
+}
+
+// collectLeaves adds all the remembered leaves under the sibling pair to the leaves map.
+// The children of each of the siblings are pointed to by the other sibling so the
+// children of a sibling may be cached even if the sibling itself isn't.
+func collectLeaves(left, right *polNode, leaves map[miniHash]*polNode) error {
+	for _, pair := range [2][2]*polNode{{left, right}, {right, left}} {
+		n, sibling := pair[0], pair[1]
+		if sibling != nil && !sibling.deadEnd() {
+			err := collectLeaves(sibling.lNiece, sibling.rNiece, leaves)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := collectLeaf(n, leaves)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// deleteFromMap deletes the hashes passed in from the node map.
-func (p *Pollard) deleteFromMap(delHashes []Hash) {
-	for _, del := range delHashes {
-		delete(p.NodeMap, del.mini())
+// collectLeaf adds the node to the leaves map if it's a remembered leaf. The node must
+// not have any children.
+func collectLeaf(n *polNode, leaves map[miniHash]*polNode) error {
+	if n == nil || !n.remember || n.data == empty {
+		return nil
+	}
+
+	mHash := n.data.mini()
+	if _, found := leaves[mHash]; found {
+		return fmt.Errorf("%w: leaf %s found more than once", ErrHashCollision, n.data)
 	}
+	leaves[mHash] = n
+
+	return nil
 }
 
-// Undo reverts the most recent modify that happened to the accumulator. The passed in numAdds,
-// dels and delHashes should correspond to block being un-done. prevRoots should be of the block
-// that the caller is trying to go back to.
+// Rehash returns a new pollard with all the leaves of this pollard added with the
+// given hasher. The leaves are added in position order so the new pollard only has
+// the live leaves and doesn't have any of the deleted ones. The pollard being
+// rehashed is not modified.
 //
-// Ex: If the caller is trying to go back to block 9, the numAdds, dels, and delHashes should be
-// the adds and dels that happened to get to block 10. prevRoots should be the roots at block 9.
-func (p *Pollard) Undo(numAdds uint64, proof Proof, delHashes []Hash, prevRoots []Hash) error {
-	for i := 0; i < int(numAdds); i++ {
-		p.undoSingleAdd()
+// NOTE The pollard must be full as all the live leaves are needed to rebuild the
+// accumulator.
+func (p *Pollard) Rehash(newHasher Hasher) (*Pollard, error) {
+	if !p.Full {
+		return nil, fmt.Errorf("Rehash fail. Pollard is not full and doesn't " +
+			"have all the leaves")
 	}
-	err := p.undoEmptyRoots(numAdds, proof.Targets, prevRoots)
-	if err != nil {
-		return err
+
+	cached := p.CachedLeaves()
+	adds := make([]Leaf, len(cached))
+	for i, hash := range cached {
+		adds[i] = Leaf{Hash: hash, Remember: true, Value: p.values[hash.mini()]}
 	}
 
-	err = p.undoDels(proof.Targets, delHashes)
+	newPollard := NewAccumulator(true)
+	newPollard.Hasher = newHasher
+	err := newPollard.Modify(adds, nil, Proof{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &newPollard, nil
 }
 
-// undoEmptyRoots places empty roots back in after undoing the additions.
-func (p *Pollard) undoEmptyRoots(numAdds uint64, origDels []uint64, prevRoots []Hash) error {
-	if len(p.Roots) >= int(numRoots(p.NumLeaves)) {
-		return nil
+// AllSubTreesToString is a wrapper around utreexo.AllSubTreesToString(). Returns a string representation
+// of each of the subtrees in the pollard that's less than 6 rows tall.
+func (p *Pollard) AllSubTreesToString() string {
+	return AllSubTreesToString(p)
+}
+
+// GetTotalCount returns the count of all the polNodes in the pollard.
+func (p *Pollard) GetTotalCount() int64 {
+	var size int64
+	for _, root := range p.Roots {
+		size += getCount(root)
 	}
-	dels := make([]uint64, len(origDels))
-	copy(dels, origDels)
 
-	// Sort before detwining.
-	sort.Slice(dels, func(a, b int) bool { return dels[a] < dels[b] })
-	dels = deTwin(dels, treeRows(p.NumLeaves))
+	return size
+}
 
-	// Copy to avoid mutating the original.
-	copyRoots := make([]Hash, len(prevRoots))
-	copy(copyRoots, prevRoots)
+// WalkNodes calls fn for every cached node in the pollard with its position and hash.
+// isLeaf is true for the nodes on the bottom row and for the leaves in the node map.
+// The trees are walked from the biggest to the smallest and each tree is walked
+// depth-first with the left child first. Empty roots are skipped. The walk stops
+// and the error is returned if fn returns an error.
+//
+// NOTE For pollards that aren't full, a leaf that isn't remembered and has moved up
+// from the bottom row is not reported as a leaf.
+func (p *Pollard) WalkNodes(fn func(pos uint64, h Hash, isLeaf bool) error) error {
+	totalRows := treeRows(p.NumLeaves)
+	for i, rootPos := range RootPositions(p.NumLeaves, totalRows) {
+		root := p.Roots[i]
+		if root.data == empty {
+			continue
+		}
 
-	// Add in the empty roots that was removed by the deletions to the prevRoots.
-	for _, del := range dels {
-		if isRootPosition(del, p.NumLeaves) {
-			tree, _, _, err := detectOffset(del, p.NumLeaves)
-			if err != nil {
-				return err
-			}
-			copyRoots[tree] = empty
+		err := p.walkNode(root, rootPos, totalRows, fn)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Add empty roots that was present in the previous roots.
-	for i, prevRoot := range copyRoots {
-		if prevRoot == empty {
-			for i >= len(p.Roots) {
-				p.Roots = append(p.Roots, &polNode{remember: p.Full})
-				continue
-			}
-			if p.Roots[i].data != empty {
-				p.Roots = append(p.Roots, nil)
-				copy(p.Roots[i+1:], p.Roots[i:])
-				p.Roots[i] = &polNode{data: prevRoot, remember: p.Full}
+		// Roots point to their children.
+		if !root.deadEnd() {
+			err = p.walkSiblings(root.lNiece, root.rNiece, leftChild(rootPos, totalRows),
+				totalRows, fn)
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -386,188 +2298,316 @@ func (p *Pollard) undoEmptyRoots(numAdds uint64, origDels []uint64, prevRoots []
 	return nil
 }
 
-// undoSingleAdd undoes one leaf that was added to the accumulator.
-func (p *Pollard) undoSingleAdd() {
-	lowestRootRow := getLowestRoot(p.NumLeaves, treeRows(p.NumLeaves))
-	for row := int(lowestRootRow); row >= 0; row-- {
-		lowestRoot := p.Roots[len(p.Roots)-1]
-		p.Roots = p.Roots[:len(p.Roots)-1]
+// walkNode calls fn for the given node.
+func (p *Pollard) walkNode(n *polNode, pos uint64, totalRows uint8,
+	fn func(pos uint64, h Hash, isLeaf bool) error) error {
 
-		lNiece, rNiece := lowestRoot.lNiece, lowestRoot.rNiece
+	mapNode, found := p.NodeMap[n.data.mini()]
+	isLeaf := detectRow(pos, totalRows) == 0 || (found && mapNode == n)
+	return fn(pos, n.data, isLeaf)
+}
 
-		if lNiece != nil {
-			swapNieces(lNiece, rNiece)
-			lNiece.aunt, rNiece.aunt = nil, nil
-			p.Roots = append(p.Roots, lNiece, rNiece)
-		} else {
-			row = -1
-		}
+// walkSiblings walks the left sibling and its children and then the right sibling and
+// its children. The children of a node are the nieces of its sibling. Either of the
+// siblings may be nil if the pollard is pruned.
+func (p *Pollard) walkSiblings(left, right *polNode, leftPos uint64, totalRows uint8,
+	fn func(pos uint64, h Hash, isLeaf bool) error) error {
 
-		delete(p.NodeMap, lowestRoot.data.mini())
-		delNode(lowestRoot)
+	rightPos := rightSib(leftPos)
+
+	if left != nil {
+		err := p.walkNode(left, leftPos, totalRows, fn)
+		if err != nil {
+			return err
+		}
+	}
+	if right != nil && !right.deadEnd() {
+		err := p.walkSiblings(right.lNiece, right.rNiece, leftChild(leftPos, totalRows),
+			totalRows, fn)
+		if err != nil {
+			return err
+		}
 	}
-	p.NumLeaves--
-}
 
-func (p *Pollard) undoDels(dels []uint64, delHashes []Hash) error {
-	if len(dels) != len(delHashes) {
-		return fmt.Errorf("Got %d targets to be deleted but have %d hashes",
-			len(dels), len(delHashes))
+	if right != nil {
+		err := p.walkNode(right, rightPos, totalRows, fn)
+		if err != nil {
+			return err
+		}
+	}
+	if left != nil && !left.deadEnd() {
+		err := p.walkSiblings(left.lNiece, left.rNiece, leftChild(rightPos, totalRows),
+			totalRows, fn)
+		if err != nil {
+			return err
+		}
 	}
 
-	pnps := make([]nodeAndPos, len(dels))
-	for i := range dels {
-		pn := &polNode{data: delHashes[i], remember: p.Full}
-		pnps[i] = nodeAndPos{pn, dels[i]}
+	return nil
+}
 
-		p.NodeMap[delHashes[i].mini()] = pn
+// MarkHot marks the given hashes as hot. PruneToSize keeps the hot leaves cached
+// and only forgets the leaves that aren't hot. A hash stops being hot once it's
+// deleted from the accumulator.
+func (p *Pollard) MarkHot(hashes []Hash) {
+	if p.hot == nil {
+		p.hot = make(map[miniHash]struct{}, len(hashes))
 	}
-	sort.Slice(pnps, func(a, b int) bool { return pnps[a].pos < pnps[b].pos })
+	for _, hash := range hashes {
+		p.hot[hash.mini()] = struct{}{}
+	}
+}
 
-	totalRows := treeRows(p.NumLeaves)
-	pnps = deTwinPolNode(pnps, totalRows)
+// PruneToSize forgets the cached leaves that aren't hot until the pollard has at most
+// maxNodes nodes. The leaves are forgotten in the order of their positions, lowest
+// first. The hot leaves are always kept so the pollard may still have more than
+// maxNodes nodes if the proofs of the hot leaves alone take up more nodes.
+func (p *Pollard) PruneToSize(maxNodes int64) error {
+	if p.Full {
+		return fmt.Errorf("PruneToSize fail. Can't prune a full pollard")
+	}
 
-	// Go through all the de-twined nodes and all from the highest position first.
-	for i := len(pnps) - 1; i >= 0; i-- {
-		pnp := pnps[i]
+	count := p.GetTotalCount()
+	if count <= maxNodes {
+		return nil
+	}
 
-		if isRootPosition(pnp.pos, p.NumLeaves) {
-			tree, _, _, err := detectOffset(pnp.pos, p.NumLeaves)
-			if err != nil {
-				return err
-			}
-			p.Roots[tree] = pnp.node
+	type leafAndPos struct {
+		node *polNode
+		pos  uint64
+	}
+	cold := make([]leafAndPos, 0, len(p.NodeMap))
+	for k, node := range p.NodeMap {
+		if _, found := p.hot[k]; found {
 			continue
-		} else {
-			err := p.undoSingleDel(pnp.node, pnp.pos)
-			if err != nil {
-				return err
-			}
 		}
+		cold = append(cold, leafAndPos{node, p.calculatePosition(node)})
 	}
+	sort.Slice(cold, func(a, b int) bool { return cold[a].pos < cold[b].pos })
 
-	p.NumDels -= uint64(len(delHashes))
+	// The nodes that a compaction in progress is on may be pruned.
+	p.compact = nil
+
+	for _, leaf := range cold {
+		if count <= maxNodes {
+			break
+		}
+		count -= p.forgetLeaf(leaf.node)
+	}
 
 	return nil
 }
 
-func (p *Pollard) undoSingleDel(node *polNode, pos uint64) error {
-	totalRows := treeRows(p.NumLeaves)
+// prunable returns true if the node is not needed by any of the remembered leaves.
+func prunable(n *polNode) bool {
+	return n == nil || (n.deadEnd() && !n.remember)
+}
 
-	siblingPos := parent(pos, totalRows)
-	sibling, aunt, _, err := p.getNode(siblingPos)
-	if err != nil {
-		return fmt.Errorf("Couldn't undo %s at position %d, err: %v",
-			hex.EncodeToString(node.data[:]), pos, err)
+// forgetLeaf removes the leaf from the node map and prunes all the nodes that were only
+// needed to prove the leaf. Returns the number of nodes that were pruned.
+func (p *Pollard) forgetLeaf(n *polNode) int64 {
+	delete(p.NodeMap, n.data.mini())
+	delete(p.expiries, n.data.mini())
+	p.removeAddIndex(n.data)
+	n.remember = false
+
+	var pruned int64
+	for n.aunt != nil {
+		aunt := n.aunt
+		if !prunable(aunt.lNiece) || !prunable(aunt.rNiece) {
+			break
+		}
+
+		pruned += getCount(aunt.lNiece) + getCount(aunt.rNiece)
+		aunt.chop()
+		n = aunt
 	}
 
-	pHash := calculateParentHash(pos, node, sibling)
-	parent := &polNode{data: pHash, remember: p.Full}
+	return pruned
+}
 
-	// If the original parent of the deleted node is not a root.
-	if sibling.aunt != nil {
-		transferAunt(parent, sibling)
-		transferNiece(parent, sibling)
-		updateAunt(parent)
+// compactCursor is where a compaction left off.
+type compactCursor struct {
+	// epoch is the epoch of the pollard when the compaction started.
+	epoch uint64
 
-		auntLNiece := aunt.lNiece
-		auntRNiece := aunt.rNiece
-		if isLeftNiece(pos) {
-			aunt.lNiece = node
-			aunt.rNiece = sibling
-		} else {
-			aunt.lNiece = sibling
-			aunt.rNiece = node
-		}
-		updateAunt(aunt)
+	// rootIdx is the index of the next root to be compacted.
+	rootIdx int
 
-		transferNiece(sibling, node)
+	// stack is the nodes of the current tree that are being compacted.
+	stack []compactFrame
+}
 
-		node.lNiece = auntLNiece
-		node.rNiece = auntRNiece
-		updateAunt(node)
-	} else {
-		// We're moving the parent to sibling position.
-		*sibling, *parent = *parent, *sibling
-		sibling, parent = parent, sibling
+// compactFrame is a node in the compaction stack.
+type compactFrame struct {
+	node *polNode
 
-		if isLeftNiece(pos) {
-			parent.lNiece = node
-			parent.rNiece = sibling
-		} else {
-			parent.lNiece = sibling
-			parent.rNiece = node
+	// expanded is true once the nieces of the node have been pushed onto the stack.
+	expanded bool
+}
+
+// compactCheckInterval is the number of nodes CompactFor visits between checking the
+// deadline.
+const compactCheckInterval = 64
+
+// CompactFor prunes the nodes that aren't needed by any of the remembered leaves until
+// the compaction is done or until the duration is up. The number of nodes freed in
+// this call is returned along with whether the compaction is done. A compaction that
+// isn't done is resumed on the next call. It always makes some progress even with a
+// duration of 0.
+//
+// The pollard is consistent after each call so it can be used as usual between the
+// calls. A modify or an undo in between the calls restarts the compaction from the
+// beginning. A full pollard keeps all of its nodes so nothing is freed.
+func (p *Pollard) CompactFor(d time.Duration) (freed int, done bool) {
+	if p.Full {
+		return 0, true
+	}
+
+	deadline := time.Now().Add(d)
+	if p.compact == nil || p.compact.epoch != p.epoch {
+		p.compact = &compactCursor{epoch: p.epoch}
+	}
+	c := p.compact
+
+	// Visit the nodes in post-order so that the nieces are compacted before the
+	// node that points to them.
+	for steps := 1; ; steps++ {
+		if steps%compactCheckInterval == 0 && time.Now().After(deadline) {
+			return freed, false
 		}
-		updateAunt(parent)
-		updateAunt(sibling)
 
-		swapNieces(parent.lNiece, parent.rNiece)
+		if len(c.stack) == 0 {
+			if c.rootIdx >= len(p.Roots) {
+				p.compact = nil
+				return freed, true
+			}
+			c.stack = append(c.stack, compactFrame{node: p.Roots[c.rootIdx]})
+			c.rootIdx++
+			continue
+		}
 
-		_, found := p.NodeMap[sibling.data.mini()]
-		if found {
-			p.NodeMap[sibling.data.mini()] = sibling
+		top := &c.stack[len(c.stack)-1]
+		if !top.expanded {
+			top.expanded = true
+			n := top.node
+			if n.rNiece != nil {
+				c.stack = append(c.stack, compactFrame{node: n.rNiece})
+			}
+			if n.lNiece != nil {
+				c.stack = append(c.stack, compactFrame{node: n.lNiece})
+			}
+			continue
 		}
 
-		return nil
+		n := top.node
+		c.stack = c.stack[:len(c.stack)-1]
+		if !n.deadEnd() && prunable(n.lNiece) && prunable(n.rNiece) {
+			freed += int(getCount(n.lNiece) + getCount(n.rNiece))
+			n.chop()
+		}
 	}
+}
 
-	err = hashToRoot(parent)
-	if err != nil {
-		return err
+// Capacity returns the total amount of positions the forest has allocated for at the
+// current tree rows, the amount of leaves that are currently in the accumulator, and
+// the ratio of the current leaves to the maximum amount of leaves the forest can hold
+// at the current tree rows. A low fill ratio indicates that many of the leaves have
+// been deleted.
+func (p *Pollard) Capacity() (totalPositions uint64, usedLeaves uint64, fillRatio float64) {
+	if p.NumLeaves == 0 {
+		return 0, 0, 0
 	}
 
-	return nil
+	forestRows := p.GetTreeRows()
+	totalPositions = maxPosition(forestRows)
+	usedLeaves = p.NumLeaves - p.NumDels
+	fillRatio = float64(usedLeaves) / float64(maxLeafCount(forestRows))
+
+	return totalPositions, usedLeaves, fillRatio
 }
 
-// GetRoots returns the hashes of all the roots.
-func (p *Pollard) GetRoots() []Hash {
-	roots := make([]Hash, 0, len(p.Roots))
+// SerializeSize returns how many bytes it'd take to serialize the pollard.
+func (p *Pollard) SerializeSize() int {
+	count := p.GetTotalCount()
+	// header + 32 byte hashes + 1 byte leaf-ness + 1 byte niece-ness + 32 byte checksum
+	return int(headerSize + (count * 32) + (count * 2) + checksumSize)
+}
 
-	for _, root := range p.Roots {
-		roots = append(roots, root.data)
-	}
+// serializeVersion is the version of the serialization format that's written at the
+// start of a serialized pollard. It's bumped every time the format changes.
+//
+// Version 1 has the full flag in the header and a flag for each node telling which of
+// its nieces are present.
+//
+// Version 2 adds the sha256 checksum of all the data at the end.
+const serializeVersion byte = 2
 
-	return roots
-}
+// headerSize is the size of the header of a serialized pollard. It's the 1 byte version,
+// the 8 byte NumLeaves, the 8 byte NumDels, and the 1 byte full-ness.
+const headerSize = 1 + 8 + 8 + 1
 
-// String is a wrapper around utreexo.String(). Returns a string representation of the pollard
-// that's less than 6 rows tall.
-func (p *Pollard) String() string {
-	return String(p)
-}
+// checksumSize is the size of the sha256 checksum written at the end of a serialized
+// pollard.
+const checksumSize = sha256.Size
 
-// AllSubTreesToString is a wrapper around utreexo.AllSubTreesToString(). Returns a string representation
-// of each of the subtrees in the pollard that's less than 6 rows tall.
-func (p *Pollard) AllSubTreesToString() string {
-	return AllSubTreesToString(p)
-}
+// Write to writes all the data of the pollard to the writer. The data is followed by a
+// sha256 checksum of it so that corruption can be detected when it's read back.
+func (p *Pollard) WriteTo(w io.Writer) (int64, error) {
+	checksum := sha256.New()
+	body := io.MultiWriter(w, checksum)
 
-// GetTotalCount returns the count of all the polNodes in the pollard.
-func (p *Pollard) GetTotalCount() int64 {
-	var size int64
+	totalBytes, err := p.writeHeader(body)
+	if err != nil {
+		return totalBytes, err
+	}
+
+	// Then write the entire pollard to the writer.
 	for _, root := range p.Roots {
-		size += getCount(root)
+		bytes, err := p.writeOne(root, body)
+		if err != nil {
+			return totalBytes, err
+		}
+
+		totalBytes += bytes
 	}
 
-	return size
+	bytes, err := w.Write(checksum.Sum(nil))
+	totalBytes += int64(bytes)
+	return totalBytes, err
 }
 
-// SerializeSize returns how many bytes it'd take to serialize the pollard.
-func (p *Pollard) SerializeSize() int {
-	count := p.GetTotalCount()
-	// 32 byte hashes + 8 byte NumLeaves + 8 byte NumDels +
-	// 1 byte leaf-ness + 1 byte niece-ness
-	return int((count * 32) + 16 + (count * 2))
+// readChecksum reads the checksum from the reader and returns ErrChecksumMismatch if it
+// doesn't match the checksum of the data that was read.
+func readChecksum(r io.Reader, checksum hash.Hash) (int64, error) {
+	var buf [checksumSize]byte
+	readBytes, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return int64(readBytes), err
+	}
+	if !bytes.Equal(buf[:], checksum.Sum(nil)) {
+		return int64(readBytes), ErrChecksumMismatch
+	}
+
+	return int64(readBytes), nil
 }
 
-// Write to writes all the data of the pollard to the writer.
-func (p *Pollard) WriteTo(w io.Writer) (int64, error) {
+// writeHeader writes the serialization version, the number of leaves, the number of
+// deletions, and whether the pollard is full to the writer.
+func (p *Pollard) writeHeader(w io.Writer) (int64, error) {
 	totalBytes := int64(0)
 
-	// First write the num leaves.
+	// First write the version.
+	bytes, err := w.Write([]byte{serializeVersion})
+	if err != nil {
+		return totalBytes, err
+	}
+	totalBytes += int64(bytes)
+
+	// Then write the num leaves.
 	var buf [8]byte
 	binary.LittleEndian.PutUint64(buf[:], p.NumLeaves)
-	bytes, err := w.Write(buf[:])
+	bytes, err = w.Write(buf[:])
 	if err != nil {
 		return totalBytes, err
 	}
@@ -581,92 +2621,148 @@ func (p *Pollard) WriteTo(w io.Writer) (int64, error) {
 	}
 	totalBytes += int64(bytes)
 
-	// Then write the entire pollard to the writer.
-	for _, root := range p.Roots {
-		bytes, err := writeOne(root, w)
-		if err != nil {
-			return totalBytes, err
-		}
-
-		totalBytes += bytes
+	// Then write if the pollard is full.
+	var full byte
+	if p.Full {
+		full = 1
 	}
+	bytes, err = w.Write([]byte{full})
+	if err != nil {
+		return totalBytes, err
+	}
+	totalBytes += int64(bytes)
 
 	return totalBytes, nil
 }
 
-func writeOne(n *polNode, w io.Writer) (int64, error) {
-	totalBytes := int64(0)
+// Values of the niece flag written after each node by writeOne.
+const (
+	nieceNone      byte = 0
+	nieceBoth      byte = 1
+	nieceLeftOnly  byte = 2
+	nieceRightOnly byte = 3
+)
 
+// writeOne writes the node and all of its nieces to the writer.
+func (p *Pollard) writeOne(n *polNode, w io.Writer) (int64, error) {
 	if n == nil {
-		return totalBytes, nil
-	}
-	wroteBytes, err := w.Write(n.data[:])
-	if err != nil {
-		return totalBytes, err
+		return 0, nil
 	}
-	totalBytes += int64(wroteBytes)
 
-	// Mark leaf-ness. If we don't have any children, we're a leaf.
-	lChild, rChild, err := n.getChildren()
+	totalBytes, err := p.writeNode(n, w)
 	if err != nil {
 		return totalBytes, err
 	}
-	if lChild == nil && rChild == nil {
-		wroteBytes, err := w.Write([]byte{1})
-		if err != nil {
-			return totalBytes, err
-		}
-		totalBytes += int64(wroteBytes)
-	} else {
-		wroteBytes, err := w.Write([]byte{0})
-		if err != nil {
-			return totalBytes, err
-		}
-		totalBytes += int64(wroteBytes)
-	}
-
-	// If nieces are present, then call writeOne on those nieces as well and
-	// mark as nieces being present. If they don't exist, just mark as nieces
-	// missing and move on.
-	if n.lNiece != nil && n.rNiece != nil {
-		wroteBytes, err := w.Write([]byte{1})
-		if err != nil {
-			return totalBytes, err
-		}
-		totalBytes += int64(wroteBytes)
 
-		leftBytes, err := writeOne(n.lNiece, w)
+	if n.lNiece != nil {
+		leftBytes, err := p.writeOne(n.lNiece, w)
 		if err != nil {
 			return totalBytes, err
 		}
 		totalBytes += leftBytes
+	}
 
-		rightBytes, err := writeOne(n.rNiece, w)
+	if n.rNiece != nil {
+		rightBytes, err := p.writeOne(n.rNiece, w)
 		if err != nil {
 			return totalBytes, err
 		}
 		totalBytes += rightBytes
-	} else {
-		wroteBytes, err := w.Write([]byte{0})
-		if err != nil {
-			return totalBytes, err
-		}
-		totalBytes += int64(wroteBytes)
 	}
 
 	return totalBytes, nil
 }
 
-// RestorePollardFrom restores the pollard from the reader.
+// writeNode writes the hash of the node, whether it's a leaf, and which of its nieces
+// are present to the writer. The nieces themselves are not written.
+func (p *Pollard) writeNode(n *polNode, w io.Writer) (int64, error) {
+	var buf [32 + 1 + 1]byte
+	copy(buf[:32], n.data[:])
+
+	// Mark leaf-ness. Only the leaves are in the node map.
+	mapNode, found := p.NodeMap[n.data.mini()]
+	if found && mapNode == n {
+		buf[32] = 1
+	}
+
+	// Mark which nieces are present. A pruned pollard may only have one of its
+	// nieces cached.
+	switch {
+	case n.lNiece != nil && n.rNiece != nil:
+		buf[33] = nieceBoth
+	case n.lNiece != nil:
+		buf[33] = nieceLeftOnly
+	case n.rNiece != nil:
+		buf[33] = nieceRightOnly
+	default:
+		buf[33] = nieceNone
+	}
+
+	wroteBytes, err := w.Write(buf[:])
+	return int64(wroteBytes), err
+}
+
+// RestorePollardFrom restores the pollard from the reader. ErrUnknownVersion is returned
+// if the data was written with a different serialization version and
+// ErrChecksumMismatch is returned if the data doesn't match the checksum written after
+// it.
 func RestorePollardFrom(r io.Reader) (int64, *Pollard, error) {
+	checksum := sha256.New()
+	body := io.TeeReader(r, checksum)
+
 	p := NewAccumulator(true)
+	totalBytes, err := p.readHeader(body)
+	if err != nil {
+		return totalBytes, nil, err
+	}
+
+	// For each of the roots that we have, initialize the polnodes
+	// with readOne.
+	for i := range p.Roots {
+		readBytes, err := p.readOne(p.Roots[i], body)
+		if err != nil {
+			return totalBytes, nil, err
+		}
+
+		totalBytes += readBytes
+	}
+
+	readBytes, err := readChecksum(r, checksum)
+	totalBytes += readBytes
+	if err != nil {
+		return totalBytes, nil, fmt.Errorf("RestorePollard fail. %w", err)
+	}
+
+	err = p.checkRestoredLeafCount()
+	if err != nil {
+		return totalBytes, nil, err
+	}
+
+	return totalBytes, &p, nil
+}
+
+// readHeader reads the serialization version, the number of leaves, the number of
+// deletions, and whether the pollard is full from the reader. The roots are allocated
+// but not filled in. ErrUnknownVersion is returned if the version isn't supported.
+func (p *Pollard) readHeader(r io.Reader) (int64, error) {
 	totalBytes := int64(0)
 
-	// Read numleaves.
+	// Read the version.
 	var buf [8]byte
-	readBytes, err := r.Read(buf[:])
+	readBytes, err := io.ReadFull(r, buf[:1])
 	if err != nil {
-		return totalBytes, nil, err
+		return totalBytes, err
+	}
+	totalBytes += int64(readBytes)
+	if buf[0] != serializeVersion {
+		return totalBytes, fmt.Errorf("RestorePollard fail. %w %d. Only "+
+			"version %d is supported", ErrUnknownVersion, buf[0], serializeVersion)
+	}
+
+	// Read numleaves.
+	readBytes, err = r.Read(buf[:])
+	if err != nil {
+		return totalBytes, err
 	}
 	totalBytes += int64(readBytes)
 	p.NumLeaves = binary.LittleEndian.Uint64(buf[:])
@@ -674,32 +2770,45 @@ func RestorePollardFrom(r io.Reader) (int64, *Pollard, error) {
 	// Read NumDels.
 	readBytes, err = r.Read(buf[:])
 	if err != nil {
-		return totalBytes, nil, err
+		return totalBytes, err
 	}
 	totalBytes += int64(readBytes)
 	p.NumDels = binary.LittleEndian.Uint64(buf[:])
 
-	// For each of the roots that we have, initialize the polnodes
-	// with readOne.
+	// Read if the pollard is full.
+	readBytes, err = r.Read(buf[:1])
+	if err != nil {
+		return totalBytes, err
+	}
+	totalBytes += int64(readBytes)
+	switch buf[0] {
+	case 0:
+		p.Full = false
+	case 1:
+		p.Full = true
+	default:
+		return totalBytes, fmt.Errorf("RestorePollard fail. Invalid full "+
+			"flag of %d", buf[0])
+	}
+
 	p.Roots = make([]*polNode, numRoots(p.NumLeaves))
 	for i := range p.Roots {
 		p.Roots[i] = new(polNode)
-		readBytes, err := p.readOne(p.Roots[i], r)
-		if err != nil {
-			return totalBytes, nil, err
-		}
-
-		totalBytes += readBytes
 	}
 
-	// Sanity check.
-	if len(p.NodeMap) != int(p.NumLeaves-p.NumDels) {
-		err = fmt.Errorf("RestorePollard fail. Expect a total or %d "+
+	return totalBytes, nil
+}
+
+// checkRestoredLeafCount checks that a restored full pollard has all of its leaves in
+// the node map.
+func (p *Pollard) checkRestoredLeafCount() error {
+	// Sanity check. Only a full pollard has all the leaves.
+	if p.Full && len(p.NodeMap) != int(p.NumLeaves-p.NumDels) {
+		return fmt.Errorf("RestorePollard fail. Expect a total or %d "+
 			"leaves but only have %d leaves in the map", p.NumLeaves-p.NumDels, len(p.NodeMap))
-		return totalBytes, nil, err
 	}
 
-	return totalBytes, &p, nil
+	return nil
 }
 
 func (p *Pollard) readOne(n *polNode, r io.Reader) (int64, error) {
@@ -716,43 +2825,217 @@ func (p *Pollard) readOne(n *polNode, r io.Reader) (int64, error) {
 	}
 	totalBytes += int64(readBytes)
 
-	// Read leaf-ness. If this node is a leaf, then we need to store it in
-	// the map.
-	var buf [1]byte
-	readBytes, err = r.Read(buf[:])
+	readBytes, err = p.readNodeFlags(n, r)
 	if err != nil {
 		return totalBytes, err
 	}
 	totalBytes += int64(readBytes)
+
+	if n.lNiece != nil {
+		leftBytes, err := p.readOne(n.lNiece, r)
+		if err != nil {
+			return totalBytes, err
+		}
+		totalBytes += leftBytes
+	}
+
+	if n.rNiece != nil {
+		rightBytes, err := p.readOne(n.rNiece, r)
+		if err != nil {
+			return totalBytes, err
+		}
+		totalBytes += rightBytes
+	}
+
+	return totalBytes, nil
+}
+
+// readNodeFlags reads whether the node is a leaf and which of its nieces are present.
+// The node is added to the node map if it's a leaf and empty nieces are created for
+// the nieces that are present.
+func (p *Pollard) readNodeFlags(n *polNode, r io.Reader) (int, error) {
+	var buf [2]byte
+	readBytes, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return readBytes, err
+	}
+
+	// If this node is a leaf, then we need to store it in the map.
 	if buf[0] == 1 {
 		if n.data != empty {
 			p.NodeMap[n.data.mini()] = n
+			n.remember = true
 		}
 	}
+	if p.Full {
+		n.remember = true
+	}
 
-	// Read if the node has nieces. If the node does have nieces, then we call readOne
-	// for the nieces as well.
-	readBytes, err = r.Read(buf[:])
+	nieceFlag := buf[1]
+	if nieceFlag > nieceRightOnly {
+		return readBytes, fmt.Errorf("readOne error: invalid niece flag %d", nieceFlag)
+	}
+	if nieceFlag == nieceBoth || nieceFlag == nieceLeftOnly {
+		n.lNiece = &polNode{aunt: n}
+	}
+	if nieceFlag == nieceBoth || nieceFlag == nieceRightOnly {
+		n.rNiece = &polNode{aunt: n}
+	}
+
+	return readBytes, nil
+}
+
+// SerializeCursor keeps track of how far a chunked serialization or deserialization of
+// the pollard has gotten. The zero value with ChunkNodes set starts from the beginning.
+type SerializeCursor struct {
+	// ChunkNodes is the maximum number of nodes that's processed in a single chunk.
+	// A value of 0 or less processes the entire pollard in one chunk.
+	ChunkNodes int
+
+	// started is true once the header has been processed.
+	started bool
+
+	// rootIdx is the index of the next root to be processed.
+	rootIdx int
+
+	// stack is the nodes of the current tree that are left to be processed. The
+	// last element is processed next.
+	stack []*polNode
+
+	// restored is the pollard being deserialized.
+	restored *Pollard
+
+	// checksum is the checksum of the data processed so far.
+	checksum hash.Hash
+}
+
+// advance returns the next node to be processed in pre-order. Returns nil if there are
+// no more nodes.
+func (c *SerializeCursor) advance(roots []*polNode) *polNode {
+	if len(c.stack) == 0 {
+		if c.rootIdx >= len(roots) {
+			return nil
+		}
+		c.stack = append(c.stack, roots[c.rootIdx])
+		c.rootIdx++
+	}
+
+	n := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	return n
+}
+
+// push adds the nieces of the node so that the left niece is processed before the
+// right niece.
+func (c *SerializeCursor) push(n *polNode) {
+	if n.rNiece != nil {
+		c.stack = append(c.stack, n.rNiece)
+	}
+	if n.lNiece != nil {
+		c.stack = append(c.stack, n.lNiece)
+	}
+}
+
+// done returns true if all the nodes have been processed.
+func (c *SerializeCursor) done(roots []*polNode) bool {
+	return c.started && len(c.stack) == 0 && c.rootIdx >= len(roots)
+}
+
+// SerializeChunk writes up to cursor.ChunkNodes nodes of the pollard to the writer and
+// returns the cursor to pass in for the next chunk along with whether all of the pollard
+// has been written. The concatenation of all the chunks is the same as what WriteTo
+// writes.
+//
+// The pollard must not be modified until all the chunks are written as the cursor holds
+// pointers into the pollard.
+func (p *Pollard) SerializeChunk(w io.Writer, cursor SerializeCursor) (SerializeCursor, bool, error) {
+	if !cursor.started {
+		cursor.checksum = sha256.New()
+		_, err := p.writeHeader(io.MultiWriter(w, cursor.checksum))
+		if err != nil {
+			return cursor, false, err
+		}
+		cursor.started = true
+	}
+	body := io.MultiWriter(w, cursor.checksum)
+
+	for i := 0; cursor.ChunkNodes <= 0 || i < cursor.ChunkNodes; i++ {
+		n := cursor.advance(p.Roots)
+		if n == nil {
+			break
+		}
+
+		_, err := p.writeNode(n, body)
+		if err != nil {
+			return cursor, false, err
+		}
+		cursor.push(n)
+	}
+
+	if !cursor.done(p.Roots) {
+		return cursor, false, nil
+	}
+
+	_, err := w.Write(cursor.checksum.Sum(nil))
 	if err != nil {
-		return totalBytes, err
+		return cursor, false, err
 	}
-	totalBytes += int64(readBytes)
+	cursor.checksum = nil
 
-	if buf[0] == 1 {
-		n.lNiece = &polNode{aunt: n}
-		leftBytes, err := p.readOne(n.lNiece, r)
+	return cursor, true, nil
+}
+
+// DeserializeChunk reads up to cursor.ChunkNodes nodes written by SerializeChunk or
+// WriteTo from the reader and returns the cursor to pass in for the next chunk. Once
+// all of the pollard has been read, the restored pollard is returned. The returned
+// pollard is nil until then. ErrChecksumMismatch is returned with the last chunk if the
+// data doesn't match the checksum written after it.
+func DeserializeChunk(r io.Reader, cursor SerializeCursor) (SerializeCursor, *Pollard, error) {
+	if !cursor.started {
+		cursor.checksum = sha256.New()
+		p := NewAccumulator(true)
+		_, err := p.readHeader(io.TeeReader(r, cursor.checksum))
 		if err != nil {
-			return totalBytes, err
+			return cursor, nil, err
 		}
-		totalBytes += leftBytes
+		cursor.restored = &p
+		cursor.started = true
+	}
+	p := cursor.restored
+	body := io.TeeReader(r, cursor.checksum)
 
-		n.rNiece = &polNode{aunt: n}
-		rightBytes, err := p.readOne(n.rNiece, r)
+	for i := 0; cursor.ChunkNodes <= 0 || i < cursor.ChunkNodes; i++ {
+		n := cursor.advance(p.Roots)
+		if n == nil {
+			break
+		}
+
+		_, err := io.ReadFull(body, n.data[:])
 		if err != nil {
-			return totalBytes, err
+			return cursor, nil, err
 		}
-		totalBytes += rightBytes
+		_, err = p.readNodeFlags(n, body)
+		if err != nil {
+			return cursor, nil, err
+		}
+		cursor.push(n)
 	}
 
-	return totalBytes, nil
+	if !cursor.done(p.Roots) {
+		return cursor, nil, nil
+	}
+
+	_, err := readChecksum(r, cursor.checksum)
+	if err != nil {
+		return cursor, nil, fmt.Errorf("DeserializeChunk fail. %w", err)
+	}
+	cursor.checksum = nil
+
+	err = p.checkRestoredLeafCount()
+	if err != nil {
+		return cursor, nil, err
+	}
+	cursor.restored = nil
+
+	return cursor, p, nil
 }
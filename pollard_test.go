@@ -2,12 +2,16 @@ package utreexo
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Assert that Pollard implements the UtreexoTest interface.
@@ -54,117 +58,96 @@ func (p *Pollard) posMapSanity() error {
 		return err
 	}
 
-	for mHash, node := range p.NodeMap {
-		if node == nil {
-			return fmt.Errorf("Node in nodemap is nil. Key: %s",
-				hex.EncodeToString(mHash[:]))
-		}
-
-		pos := p.calculatePosition(node)
-		gotNode, _, _, err := p.getNode(pos)
-		if err != nil {
-			return err
-		}
-
-		if gotNode == nil {
-			return fmt.Errorf("Couldn't fetch pos %d, expected %s",
-				pos, hex.EncodeToString(node.data[:]))
-		}
-
-		if gotNode.data != node.data {
-			return fmt.Errorf("Calculated pos %d for node %s but read %s",
-				pos, hex.EncodeToString(node.data[:]),
-				hex.EncodeToString(gotNode.data[:]))
-		}
-	}
+	return p.CheckPositionConsistency()
+}
 
-	return nil
+// undoTests are the test cases for undoing a modify. startAdds and startDels are applied
+// to build the starting accumulator and modifyAdds and modifyDels are the modify that
+// gets undone.
+var undoTests = []struct {
+	startAdds []Hash
+	startDels []Hash
+
+	modifyAdds []Hash
+	modifyDels []Hash
+}{
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}},
+		nil,
+
+		[]Hash{{7}, {8}},
+		[]Hash{{6}, {4}, {2}, {1}, {3}},
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		nil,
+
+		nil,
+		[]Hash{{5}, {6}},
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		nil,
+
+		nil,
+		[]Hash{{4}, {5}},
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		nil,
+
+		[]Hash{{9}, {10}},
+		nil,
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		nil,
+
+		[]Hash{{9}, {10}},
+		[]Hash{{4}, {5}},
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		nil,
+
+		[]Hash{{9}, {10}},
+		[]Hash{{2}, {3}, {7}},
+	},
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
+		nil,
+
+		[]Hash{{8}, {9}},
+		[]Hash{{5}, {6}},
+	},
+
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
+		nil,
+
+		[]Hash{{14}, {15}, {16}, {17}},
+		nil,
+	},
+
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}},
+
+		[]Hash{{8}},
+		nil,
+	},
+
+	{
+		[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
+		[]Hash{{1}, {2}, {3}, {4}, {6}, {7}},
+
+		[]Hash{{8}},
+		nil,
+	},
 }
 
 func testUndo(t *testing.T, utreexo UtreexoTest) {
-	var tests = []struct {
-		startAdds []Hash
-		startDels []Hash
-
-		modifyAdds []Hash
-		modifyDels []Hash
-	}{
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}},
-			nil,
-
-			[]Hash{{7}, {8}},
-			[]Hash{{6}, {4}, {2}, {1}, {3}},
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
-			nil,
-
-			nil,
-			[]Hash{{5}, {6}},
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
-			nil,
-
-			nil,
-			[]Hash{{4}, {5}},
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
-			nil,
-
-			[]Hash{{9}, {10}},
-			nil,
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
-			nil,
-
-			[]Hash{{9}, {10}},
-			[]Hash{{4}, {5}},
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
-			nil,
-
-			[]Hash{{9}, {10}},
-			[]Hash{{2}, {3}, {7}},
-		},
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
-			nil,
-
-			[]Hash{{8}, {9}},
-			[]Hash{{5}, {6}},
-		},
-
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
-			nil,
-
-			[]Hash{{14}, {15}, {16}, {17}},
-			nil,
-		},
-
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}},
-
-			[]Hash{{8}},
-			nil,
-		},
-
-		{
-			[]Hash{{1}, {2}, {3}, {4}, {5}, {6}, {7}},
-			[]Hash{{1}, {2}, {3}, {4}, {6}, {7}},
-
-			[]Hash{{8}},
-			nil,
-		},
-	}
-
-	for i, test := range tests {
+	for i, test := range undoTests {
 		switch utreexo.(type) {
 		case *Pollard:
 			v := NewAccumulator(true)
@@ -285,13 +268,82 @@ func TestUndo(t *testing.T) {
 	testUndo(t, &MapPollard{})
 }
 
+func TestModifyWithUndo(t *testing.T) {
+	t.Parallel()
+
+	for i, test := range undoTests {
+		p := NewAccumulator(true)
+
+		adds := make([]Leaf, len(test.startAdds))
+		for i := range adds {
+			adds[i] = Leaf{Hash: test.startAdds[i], Remember: true}
+		}
+		err := p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteHashes(&p, test.startDels)
+		if err != nil {
+			t.Fatalf("TestModifyWithUndo failed %d: error %v", i, err)
+		}
+
+		beforeRoots := p.GetRoots()
+		beforePositions := leafPositions(&p)
+		beforeStr := p.String()
+
+		modifyAdds := make([]Leaf, len(test.modifyAdds))
+		for i := range modifyAdds {
+			modifyAdds[i] = Leaf{Hash: test.modifyAdds[i]}
+		}
+		modifyProof, err := p.Prove(test.modifyDels)
+		if err != nil {
+			t.Fatalf("TestModifyWithUndo failed %d: error %v", i, err)
+		}
+
+		ud, err := p.ModifyWithUndo(modifyAdds, test.modifyDels, modifyProof.Targets)
+		if err != nil {
+			t.Fatalf("TestModifyWithUndo failed %d: error %v", i, err)
+		}
+
+		err = p.UndoFromData(ud)
+		if err != nil {
+			t.Fatalf("TestModifyWithUndo failed %d: error %v", i, err)
+		}
+
+		if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+			t.Fatalf("TestModifyWithUndo failed %d: roots don't equal."+
+				"\nbefore roots:\n%v\nafter roots:\n%v\nbefore:\n\n%s\nundo:\n\n%s",
+				i, printHashes(beforeRoots), printHashes(p.GetRoots()),
+				beforeStr, p.String())
+		}
+		if !reflect.DeepEqual(beforePositions, leafPositions(&p)) {
+			t.Fatalf("TestModifyWithUndo failed %d: node maps don't equal."+
+				"\nbefore:\n%v\nafter:\n%v", i, beforePositions, leafPositions(&p))
+		}
+		err = p.sanityCheck()
+		if err != nil {
+			t.Fatalf("TestModifyWithUndo failed %d: error %v", i, err)
+		}
+	}
+}
+
+// leafPositions returns the positions of all the leaves in the node map.
+func leafPositions(p *Pollard) map[miniHash]uint64 {
+	positions := make(map[miniHash]uint64, len(p.NodeMap))
+	for k, node := range p.NodeMap {
+		positions[k] = p.calculatePosition(node)
+	}
+
+	return positions
+}
+
 // checkHashes moves down the tree and calculates the parent hash from the children.
 // It errors if the calculated hash doesn't match the hash found in the pollard.
 func checkHashes(node, sibling *polNode, p *Pollard) error {
 	// If node has a niece, then we can calculate the hash of the sibling because
 	// every tree is a perfect binary tree.
 	if node.lNiece != nil {
-		calculated := parentHash(node.lNiece.data, node.rNiece.data)
+		calculated := p.hasher()(node.lNiece.data, node.rNiece.data)
 		if sibling.data != calculated {
 			return fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 				p.calculatePosition(sibling),
@@ -307,7 +359,7 @@ func checkHashes(node, sibling *polNode, p *Pollard) error {
 	}
 
 	if sibling.lNiece != nil {
-		calculated := parentHash(sibling.lNiece.data, sibling.rNiece.data)
+		calculated := p.hasher()(sibling.lNiece.data, sibling.rNiece.data)
 		if node.data != calculated {
 			return fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 				p.calculatePosition(node),
@@ -331,7 +383,7 @@ func (p *Pollard) checkHashes() error {
 	for _, root := range p.Roots {
 		if root.lNiece != nil && root.rNiece != nil {
 			// First check the root hash.
-			calculatedHash := parentHash(root.lNiece.data, root.rNiece.data)
+			calculatedHash := p.hasher()(root.lNiece.data, root.rNiece.data)
 			if calculatedHash != root.data {
 				err := fmt.Errorf("For position %d, calculated %s from left %s, right %s but read %s",
 					p.calculatePosition(root),
@@ -396,6 +448,9 @@ type simChain struct {
 	durationMask uint32
 	lookahead    int32
 	rnd          *rand.Rand
+
+	// live are the leaves added with NextBlockWithDels that haven't been deleted yet.
+	live []Hash
 }
 
 // newSimChain initializes and returns a simchain
@@ -436,6 +491,31 @@ func (s *simChain) BackOne(leaves []Leaf, durations []int32, dels []Hash) {
 	s.blockHeight--
 }
 
+// NextBlockWithDels outputs a new simulation block with numAdds additions and exactly
+// numDels deletions, or all the live leaves if there are fewer. The deletions are picked
+// at random from the leaves added with NextBlockWithDels instead of being set by their
+// durations so the simChain should be made with a duration of 0.
+func (s *simChain) NextBlockWithDels(numAdds, numDels uint32) ([]Leaf, []Hash) {
+	if int(numDels) > len(s.live) {
+		numDels = uint32(len(s.live))
+	}
+	delHashes := make([]Hash, numDels)
+	for i := range delHashes {
+		idx := s.rnd.Intn(len(s.live))
+		delHashes[i] = s.live[idx]
+		s.live[idx] = s.live[len(s.live)-1]
+		s.live = s.live[:len(s.live)-1]
+	}
+
+	adds, _, ttlDels := s.NextBlock(numAdds)
+	delHashes = append(delHashes, ttlDels...)
+	for _, add := range adds {
+		s.live = append(s.live, add.Hash)
+	}
+
+	return adds, delHashes
+}
+
 // NextBlock outputs a new simulation block given the additions for the block
 // to be outputed
 func (s *simChain) NextBlock(numAdds uint32) ([]Leaf, []int32, []Hash) {
@@ -670,66 +750,91 @@ func FuzzModifyChain(f *testing.F) {
 	f.Fuzz(func(t *testing.T, numAdds, duration uint32, seed int64) {
 		t.Parallel()
 
-		// simulate blocks with simchain
-		sc := newSimChainWithSeed(duration, seed)
-
-		p := NewAccumulator(true)
-		var totalAdds, totalDels int
-		for b := 0; b <= 100; b++ {
-			adds, _, delHashes := sc.NextBlock(numAdds)
-			totalAdds += len(adds)
-			totalDels += len(delHashes)
+		err := ReplayFuzzModifyChain(numAdds, duration, seed, 101)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
 
-			proof, err := p.Prove(delHashes)
-			if err != nil {
-				t.Fatalf("FuzzModifyChain fail at block %d. Error: %v", b, err)
-			}
+// ReplayFuzzModifyChain is the body of FuzzModifyChain. It runs the checks for the given
+// fuzz inputs and returns the first error. The blocks are generated deterministically
+// from the inputs so a failing input found by the fuzzer can be pinned as a regular test.
+func ReplayFuzzModifyChain(numAdds, duration uint32, seed int64, blocks int) error {
+	if blocks < 0 {
+		return fmt.Errorf("ReplayFuzzModifyChain fail. Got negative blocks %d", blocks)
+	}
 
-			err = p.Verify(delHashes, proof, false)
-			if err != nil {
-				t.Fatal(err)
-			}
+	// simulate blocks with simchain
+	sc := newSimChainWithSeed(duration, seed)
 
-			for _, target := range proof.Targets {
-				n, _, _, err := p.getNode(target)
-				if err != nil {
-					t.Fatalf("FuzzModifyChain fail at block %d. Error: %v", b, err)
-				}
-				if n == nil {
-					t.Fatalf("FuzzModifyChain fail to read %d at block %d.", target, b)
-				}
-			}
+	p := NewAccumulator(true)
+	for b := 0; b < blocks; b++ {
+		adds, _, delHashes := sc.NextBlock(numAdds)
 
-			err = p.Modify(adds, delHashes, proof)
-			if err != nil {
-				t.Fatalf("FuzzModifyChain fail at block %d. Error: %v", b, err)
-			}
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
+		}
 
-			if b%10 == 0 {
-				err = p.checkHashes()
-				if err != nil {
-					t.Fatal(err)
-				}
-			}
+		err = p.Verify(delHashes, proof, false)
+		if err != nil {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
+		}
 
-			err = p.posMapSanity()
+		for _, target := range proof.Targets {
+			n, _, _, err := p.getNode(target)
 			if err != nil {
-				t.Fatalf("FuzzModifyChain fail at block %d. Error: %v",
-					b, err)
+				return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
 			}
-			if uint64(len(p.NodeMap)) != p.NumLeaves-p.NumDels {
-				err := fmt.Errorf("FuzzModifyChain fail at block %d: "+
-					"have %d leaves in map but only %d leaves in total",
-					b, len(p.NodeMap), p.NumLeaves-p.NumDels)
-				t.Fatal(err)
+			if n == nil {
+				return fmt.Errorf("ReplayFuzzModifyChain fail to read %d at block %d.", target, b)
 			}
+		}
+
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
+		}
 
-			err = p.positionSanity()
+		if b%10 == 0 {
+			err = p.checkHashes()
 			if err != nil {
-				t.Fatal(err)
+				return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
 			}
 		}
-	})
+
+		err = p.CheckPositionConsistency()
+		if err != nil {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
+		}
+		if uint64(len(p.NodeMap)) != p.NumLeaves-p.NumDels {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d: "+
+				"have %d leaves in map but only %d leaves in total",
+				b, len(p.NodeMap), p.NumLeaves-p.NumDels)
+		}
+
+		err = p.positionSanity()
+		if err != nil {
+			return fmt.Errorf("ReplayFuzzModifyChain fail at block %d. Error: %v", b, err)
+		}
+	}
+
+	return nil
+}
+
+func TestReplayFuzzModifyChain(t *testing.T) {
+	t.Parallel()
+
+	err := ReplayFuzzModifyChain(3, 0x07, 0x07, 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ReplayFuzzModifyChain(3, 0x07, 0x07, -1)
+	if err == nil {
+		t.Fatalf("expected an error for a negative number of blocks")
+	}
 }
 
 func FuzzUndo(f *testing.F) {
@@ -1339,3 +1444,2856 @@ func compareNodeMap(mapA, mapB map[miniHash]*polNode) error {
 
 	return fmt.Errorf(str)
 }
+
+func TestAddPreimages(t *testing.T) {
+	t.Parallel()
+
+	preimages := [][]byte{[]byte("utxo1"), []byte("utxo2"), []byte("utxo3"), []byte("utxo4"), []byte("utxo5")}
+
+	p := NewAccumulator(true)
+	hashes, err := p.AddPreimages(preimages, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := NewAccumulator(true)
+	leaves := make([]Leaf, len(preimages))
+	for i, preimage := range preimages {
+		leaves[i] = Leaf{Hash: sha256.Sum256(preimage)}
+	}
+	err = expect.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range leaves {
+		if hashes[i] != leaves[i].Hash {
+			t.Fatalf("expected hash %s at index %d but got %s", leaves[i].Hash, i, hashes[i])
+		}
+	}
+	if !reflect.DeepEqual(p.GetRoots(), expect.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(expect.GetRoots()), printHashes(p.GetRoots()))
+	}
+	err = p.sanityCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModifyDuplicateDeletion(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := make([]Leaf, 8)
+	for i := range leaves {
+		leaves[i] = Leaf{Hash: Hash{uint8(i + 1)}, Remember: true}
+	}
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[2].Hash, leaves[5].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Targets = append(proof.Targets, proof.Targets[0])
+	delHashes = append(delHashes, delHashes[0])
+
+	beforeRoots := p.GetRoots()
+	beforeStr := p.String()
+	beforeNumLeaves, beforeNumDels := p.NumLeaves, p.NumDels
+	beforeMapLen := len(p.NodeMap)
+
+	err = p.Modify(nil, delHashes, proof)
+	if !errors.Is(err, ErrDuplicateDeletion) {
+		t.Fatalf("expected %v but got %v", ErrDuplicateDeletion, err)
+	}
+
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("roots changed after failed modify. Before:\n%s\nafter:\n%s",
+			printHashes(beforeRoots), printHashes(p.GetRoots()))
+	}
+	if beforeStr != p.String() {
+		t.Fatalf("pollard changed after failed modify. Before:\n%s\nafter:\n%s",
+			beforeStr, p.String())
+	}
+	if beforeNumLeaves != p.NumLeaves || beforeNumDels != p.NumDels {
+		t.Fatalf("expected numleaves %d, numdels %d but got numleaves %d, numdels %d",
+			beforeNumLeaves, beforeNumDels, p.NumLeaves, p.NumDels)
+	}
+	if beforeMapLen != len(p.NodeMap) {
+		t.Fatalf("expected nodemap len %d but got %d", beforeMapLen, len(p.NodeMap))
+	}
+}
+
+func TestProgressCallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		startLeaves int
+		adds        int
+		interval    uint64
+		expected    []uint64
+	}{
+		{0, 10, 3, []uint64{3, 6, 9}},
+		{0, 9, 3, []uint64{3, 6, 9}},
+		{2, 5, 2, []uint64{4, 6}},
+		{0, 5, 0, nil},
+		{0, 2, 5, nil},
+	}
+
+	for _, test := range tests {
+		p := NewAccumulator(true)
+		noProgress := NewAccumulator(true)
+
+		err := p.Modify(makeTestLeaves(test.startLeaves, 0), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = noProgress.Modify(makeTestLeaves(test.startLeaves, 0), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []uint64
+		p.ProgressInterval = test.interval
+		p.OnProgress = func(numLeaves uint64) {
+			got = append(got, numLeaves)
+		}
+
+		adds := makeTestLeaves(test.adds, test.startLeaves)
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = noProgress.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("expected progress calls %v but got %v", test.expected, got)
+		}
+
+		// The callback should not affect the results.
+		if !reflect.DeepEqual(p.GetRoots(), noProgress.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(noProgress.GetRoots()), printHashes(p.GetRoots()))
+		}
+	}
+}
+
+// makeTestLeaves returns count leaves with unique hashes starting from the offset.
+func makeTestLeaves(count, offset int) []Leaf {
+	leaves := make([]Leaf, count)
+	for i := range leaves {
+		binary.LittleEndian.PutUint64(leaves[i].Hash[:], uint64(offset+i+1))
+	}
+
+	return leaves
+}
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numAdds        int
+		numDels        int
+		totalPositions uint64
+		usedLeaves     uint64
+		fillRatio      float64
+	}{
+		{0, 0, 0, 0, 0},
+		{1, 0, 1, 1, 1},
+		{2, 1, 3, 1, 0.5},
+		{5, 0, 15, 5, 0.625},
+		{8, 0, 15, 8, 1},
+		{8, 4, 15, 4, 0.5},
+		{9, 3, 31, 6, 0.375},
+	}
+
+	for _, test := range tests {
+		p := NewAccumulator(true)
+		leaves := makeTestLeaves(test.numAdds, 0)
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.numDels > 0 {
+			delHashes := make([]Hash, test.numDels)
+			for i := range delHashes {
+				delHashes[i] = leaves[i].Hash
+			}
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.Modify(nil, delHashes, proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		totalPositions, usedLeaves, fillRatio := p.Capacity()
+		if totalPositions != test.totalPositions {
+			t.Fatalf("expected %d total positions but got %d",
+				test.totalPositions, totalPositions)
+		}
+		if usedLeaves != test.usedLeaves {
+			t.Fatalf("expected %d used leaves but got %d", test.usedLeaves, usedLeaves)
+		}
+		if fillRatio != test.fillRatio {
+			t.Fatalf("expected fill ratio %v but got %v", test.fillRatio, fillRatio)
+		}
+		if usedLeaves > totalPositions {
+			t.Fatalf("used leaves %d is greater than total positions %d",
+				usedLeaves, totalPositions)
+		}
+	}
+}
+
+func TestMergeDisjoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		firstLeaves  int
+		secondLeaves int
+		expectErr    bool
+	}{
+		{0, 5, false},
+		{5, 0, false},
+		{12, 3, false},
+		{16, 15, false},
+		{8, 7, false},
+		{12, 4, true},
+		{5, 1, true},
+		{8, 8, true},
+	}
+
+	for _, test := range tests {
+		leaves := makeTestLeaves(test.firstLeaves+test.secondLeaves, 0)
+
+		// Delete a leaf from each of the ranges if possible.
+		var firstDels, secondDels []Hash
+		if test.firstLeaves > 1 {
+			firstDels = []Hash{leaves[1].Hash}
+		}
+		if test.secondLeaves > 1 {
+			secondDels = []Hash{leaves[test.firstLeaves].Hash}
+		}
+
+		// Build the single-threaded result.
+		expect := NewAccumulator(true)
+		err := expect.Modify(leaves[:test.firstLeaves], nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteHashes(&expect, firstDels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = expect.Modify(leaves[test.firstLeaves:], nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteHashes(&expect, secondDels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Build the two pollards in parallel.
+		first := NewAccumulator(true)
+		err = first.Modify(leaves[:test.firstLeaves], nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteHashes(&first, firstDels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second := NewAccumulator(true)
+		err = second.Modify(leaves[test.firstLeaves:], nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = deleteHashes(&second, secondDels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		beforeStr := first.String()
+		err = first.MergeDisjoint(&second)
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("expected an error merging %d leaves into %d leaves",
+					test.secondLeaves, test.firstLeaves)
+			}
+			if beforeStr != first.String() {
+				t.Fatalf("pollard changed after a failed merge")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(expect.GetRoots(), first.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(expect.GetRoots()), printHashes(first.GetRoots()))
+		}
+		if expect.NumLeaves != first.NumLeaves || expect.NumDels != first.NumDels {
+			t.Fatalf("expected numleaves %d, numdels %d but got numleaves %d, numdels %d",
+				expect.NumLeaves, expect.NumDels, first.NumLeaves, first.NumDels)
+		}
+		if expect.String() != first.String() {
+			t.Fatalf("expected:\n%s\ngot:\n%s", expect.String(), first.String())
+		}
+		err = first.sanityCheck()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// deleteHashes proves and deletes the given hashes from the pollard.
+func deleteHashes(p *Pollard, delHashes []Hash) error {
+	if len(delHashes) == 0 {
+		return nil
+	}
+
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		return err
+	}
+
+	return p.Modify(nil, delHashes, proof)
+}
+
+func TestTotalValue(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	noValues := NewAccumulator(true)
+
+	leaves := makeTestLeaves(10, 0)
+	var expected uint64
+	for i := range leaves {
+		leaves[i].Value = uint64(i * 100)
+		expected += leaves[i].Value
+	}
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = noValues.Modify(makeTestLeaves(10, 0), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != expected {
+		t.Fatalf("expected total value %d but got %d", expected, p.TotalValue())
+	}
+
+	delHashes := []Hash{leaves[0].Hash, leaves[3].Hash, leaves[7].Hash}
+	expected -= leaves[0].Value + leaves[3].Value + leaves[7].Value
+	err = deleteHashes(&p, delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&noValues, delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != expected {
+		t.Fatalf("expected total value %d but got %d", expected, p.TotalValue())
+	}
+
+	adds := makeTestLeaves(2, len(leaves))
+	adds[1].Value = 55
+	expected += 55
+	err = p.Modify(adds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != expected {
+		t.Fatalf("expected total value %d but got %d", expected, p.TotalValue())
+	}
+
+	// The values must not affect the roots.
+	err = noValues.Modify(makeTestLeaves(2, len(leaves)), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.GetRoots(), noValues.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(noValues.GetRoots()), printHashes(p.GetRoots()))
+	}
+
+	// Undo removes the values of the adds.
+	beforeRoots := p.GetRoots()
+	valued := makeTestLeaves(3, 20)
+	valued[0].Value, valued[2].Value = 7, 9
+	err = p.Modify(valued, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != expected+16 {
+		t.Fatalf("expected total value %d but got %d", expected+16, p.TotalValue())
+	}
+	err = p.Undo(uint64(len(valued)), Proof{}, nil, beforeRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != expected {
+		t.Fatalf("expected total value %d after the undo but got %d",
+			expected, p.TotalValue())
+	}
+
+	// UndoFromData also brings back the values of the deleted leaves.
+	before := p.TotalValue()
+	dels := []Hash{leaves[5].Hash, leaves[9].Hash}
+	proof, err := p.Prove(dels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud, err := p.ModifyWithUndo(valued, dels, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != before+16-leaves[5].Value-leaves[9].Value {
+		t.Fatalf("expected total value %d but got %d",
+			before+16-leaves[5].Value-leaves[9].Value, p.TotalValue())
+	}
+	err = p.UndoFromData(ud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TotalValue() != before {
+		t.Fatalf("expected total value %d after the undo but got %d",
+			before, p.TotalValue())
+	}
+
+	// Undo doesn't know the values of the deleted leaves.
+	beforeRoots = p.GetRoots()
+	_, err = p.ModifyWithUndo(valued, dels, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterRoots := p.GetRoots()
+	err = p.Undo(uint64(len(valued)), proof, dels, beforeRoots)
+	if !errors.Is(err, ErrUndoDataRequired) {
+		t.Fatalf("expected %v but got %v", ErrUndoDataRequired, err)
+	}
+	if !reflect.DeepEqual(afterRoots, p.GetRoots()) {
+		t.Fatalf("pollard changed after the failed undo")
+	}
+
+	// The values of the adds that aren't cached are removed as well.
+	pruned := NewAccumulator(false)
+	err = pruned.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before = pruned.TotalValue()
+	ud, err = pruned.ModifyWithUndo(valued, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.UndoFromData(ud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned.TotalValue() != before {
+		t.Fatalf("expected total value %d after the undo but got %d",
+			before, pruned.TotalValue())
+	}
+}
+
+func TestRootsChangedByAdd(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		startLeaves int
+		adds        int
+		delIdx      []int
+	}{
+		{0, 0, nil},
+		{0, 1, nil},
+		{1, 1, nil},
+		{7, 1, nil},
+		{7, 9, nil},
+		{8, 3, nil},
+		{13, 6, nil},
+		{15, 17, []int{0, 1, 2, 3}},
+		{21, 11, []int{20}},
+		{6, 2, []int{4, 5}},
+	}
+
+	for _, test := range tests {
+		p := NewAccumulator(true)
+		leaves := makeTestLeaves(test.startLeaves, 0)
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		delHashes := make([]Hash, len(test.delIdx))
+		for i, idx := range test.delIdx {
+			delHashes[i] = leaves[idx].Hash
+		}
+		err = deleteHashes(&p, delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		removed, added := p.RootsChangedByAdd(uint64(test.adds))
+
+		before := p.GetRoots()
+		err = p.Modify(makeTestLeaves(test.adds, test.startLeaves), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		after := p.GetRoots()
+
+		if len(after) != len(before)-removed+added {
+			t.Fatalf("start %d, adds %d: predicted -%d +%d roots but went from %d to %d roots",
+				test.startLeaves, test.adds, removed, added, len(before), len(after))
+		}
+		// The roots that weren't removed must remain untouched.
+		kept := len(before) - removed
+		if !reflect.DeepEqual(before[:kept], after[:kept]) {
+			t.Fatalf("start %d, adds %d: expected the first %d roots to be kept. "+
+				"Before:\n%s\nafter:\n%s", test.startLeaves, test.adds, kept,
+				printHashes(before), printHashes(after))
+		}
+	}
+}
+
+func TestCachedLeaves(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChain(0x07)
+	p := NewAccumulator(true)
+	live := make(map[Hash]struct{})
+
+	for b := 0; b < 50; b++ {
+		adds, _, delHashes := sc.NextBlock(8)
+
+		err := deleteHashes(&p, delHashes)
+		if err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+
+		for _, delHash := range delHashes {
+			delete(live, delHash)
+		}
+		for _, add := range adds {
+			live[add.Hash] = struct{}{}
+		}
+
+		cached := p.CachedLeaves()
+		if uint64(len(cached)) != p.NumLeaves-p.NumDels {
+			t.Fatalf("block %d: expected %d cached leaves but got %d",
+				b, p.NumLeaves-p.NumDels, len(cached))
+		}
+		if len(cached) != len(live) {
+			t.Fatalf("block %d: expected %d cached leaves but got %d",
+				b, len(live), len(cached))
+		}
+
+		var prevPos uint64
+		for i, hash := range cached {
+			_, found := live[hash]
+			if !found {
+				t.Fatalf("block %d: cached leaf %s is not live", b, hash)
+			}
+
+			pos := p.calculatePosition(p.NodeMap[hash.mini()])
+			if i > 0 && pos <= prevPos {
+				t.Fatalf("block %d: cached leaves not in position order. "+
+					"Position %d came after %d", b, pos, prevPos)
+			}
+			prevPos = pos
+		}
+	}
+
+	// A partially cached pollard only returns the remembered leaves.
+	partial := NewAccumulator(false)
+	leaves := makeTestLeaves(10, 0)
+	leaves[2].Remember = true
+	leaves[7].Remember = true
+	err := partial.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hash{leaves[2].Hash, leaves[7].Hash}
+	if !reflect.DeepEqual(expected, partial.CachedLeaves()) {
+		t.Fatalf("expected:\n%s\ngot:\n%s",
+			printHashes(expected), printHashes(partial.CachedLeaves()))
+	}
+}
+
+func TestRehash(t *testing.T) {
+	t.Parallel()
+
+	sha256Hasher := func(l, r Hash) Hash {
+		return sha256.Sum256(append(l[:], r[:]...))
+	}
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[1].Hash, leaves[8].Hash, leaves[13].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+	beforeStr := p.String()
+
+	rehashed, err := p.Rehash(sha256Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The original must be unchanged.
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) || beforeStr != p.String() {
+		t.Fatalf("pollard changed after rehash")
+	}
+
+	// The live leaves must be the same.
+	if !reflect.DeepEqual(p.CachedLeaves(), rehashed.CachedLeaves()) {
+		t.Fatalf("expected leaves:\n%s\ngot:\n%s",
+			printHashes(p.CachedLeaves()), printHashes(rehashed.CachedLeaves()))
+	}
+
+	// The roots must be the same as a pollard built with the same leaves and the
+	// new hasher but different from one built with the default hasher.
+	expect := NewAccumulator(true)
+	expect.Hasher = sha256Hasher
+	defaultHashed := NewAccumulator(true)
+	for _, hash := range p.CachedLeaves() {
+		err = expect.Modify([]Leaf{{Hash: hash}}, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = defaultHashed.Modify([]Leaf{{Hash: hash}}, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(expect.GetRoots(), rehashed.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(expect.GetRoots()), printHashes(rehashed.GetRoots()))
+	}
+	if reflect.DeepEqual(defaultHashed.GetRoots(), rehashed.GetRoots()) {
+		t.Fatalf("expected the roots to differ from the default hasher")
+	}
+	err = rehashed.checkHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Proofs from the rehashed pollard verify with the new hasher.
+	delHashes := []Hash{leaves[4].Hash, leaves[19].Hash}
+	proof, err := rehashed.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rehashed.Verify(delHashes, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := Stump{Roots: rehashed.GetRoots(), NumLeaves: rehashed.NumLeaves}
+	_, err = VerifyWithHasher(stump, delHashes, proof, sha256Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rehashed.Modify(nil, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rehashed.checkHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partial pollards can't be rehashed.
+	partial := NewAccumulator(false)
+	_, err = partial.Rehash(sha256Hasher)
+	if err == nil {
+		t.Fatalf("expected an error rehashing a partial pollard")
+	}
+}
+
+func TestRemapPosition(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		numLeaves int
+		delIdx    []int
+		numAdds   int
+	}{
+		{8, []int{0}, 0},
+		{8, []int{0, 1}, 0},
+		{8, []int{2, 5, 6}, 0},
+		{8, []int{0, 1, 2, 3}, 0},
+		{13, []int{12}, 0},
+		{13, []int{3, 8, 10}, 4},
+		{15, []int{0, 7, 14}, 2},
+		{32, []int{1, 2, 3, 17, 30}, 10},
+	}
+
+	for _, test := range tests {
+		p := NewAccumulator(true)
+		leaves := makeTestLeaves(test.numLeaves, 0)
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oldPositions := make([]uint64, len(leaves))
+		for i, leaf := range leaves {
+			oldPositions[i] = p.calculatePosition(p.NodeMap[leaf.mini()])
+		}
+
+		delHashes := make([]Hash, len(test.delIdx))
+		deleted := make(map[int]struct{})
+		for i, idx := range test.delIdx {
+			delHashes[i] = leaves[idx].Hash
+			deleted[idx] = struct{}{}
+		}
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		numLeavesBefore := p.NumLeaves
+		err = p.Modify(makeTestLeaves(test.numAdds, len(leaves)), delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, leaf := range leaves {
+			got, found := p.RemapPosition(oldPositions[i], proof.Targets, numLeavesBefore)
+			_, wasDeleted := deleted[i]
+			if wasDeleted {
+				if found {
+					t.Fatalf("leaf %d was deleted but got position %d", i, got)
+				}
+				continue
+			}
+			if !found {
+				t.Fatalf("leaf %d at %d was not deleted but got not found", i, oldPositions[i])
+			}
+
+			expected := p.calculatePosition(p.NodeMap[leaf.mini()])
+			if got != expected {
+				t.Fatalf("numleaves %d, dels %v: expected leaf %d at %d to move to %d "+
+					"but got %d", test.numLeaves, test.delIdx, i, oldPositions[i],
+					expected, got)
+			}
+		}
+	}
+}
+
+func TestParanoidMode(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.ParanoidMode = true
+	p.HistoryLength = 5
+	var log bytes.Buffer
+	p.CommitmentLog(&log)
+
+	// Normal modifies work as usual.
+	leaves := makeTestLeaves(7, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[1].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt a leaf in the tree of 4 leaves.
+	node := p.NodeMap[leaves[2].mini()]
+	node.data[31] ^= 0xff
+	corrupted := node.data
+
+	beforeRoots := p.GetRoots()
+	beforeStr := p.String()
+	beforeNumLeaves, beforeNumDels := p.NumLeaves, p.NumDels
+	if log.Len() != 2*CommitmentEntrySize {
+		t.Fatalf("expected 2 commitments but got %d bytes", log.Len())
+	}
+
+	// Adding a leaf merges all the trees and hashes over the corrupted leaf.
+	err = p.Modify(makeTestLeaves(1, len(leaves)), nil, Proof{})
+	if err == nil {
+		t.Fatalf("expected paranoid mode to catch the corrupted leaf")
+	}
+
+	// Check that everything got rolled back.
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(beforeRoots), printHashes(p.GetRoots()))
+	}
+	if beforeStr != p.String() {
+		t.Fatalf("expected:\n%s\ngot:\n%s", beforeStr, p.String())
+	}
+	if beforeNumLeaves != p.NumLeaves || beforeNumDels != p.NumDels {
+		t.Fatalf("expected numleaves %d, numdels %d but got numleaves %d, numdels %d",
+			beforeNumLeaves, beforeNumDels, p.NumLeaves, p.NumDels)
+	}
+	node, found := p.NodeMap[corrupted.mini()]
+	if !found || p.calculatePosition(node) != 2 {
+		t.Fatalf("expected the node map to be restored")
+	}
+	err = p.posMapSanity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written to the commitment log or kept in the history for the
+	// modify that was rolled back.
+	if log.Len() != 2*CommitmentEntrySize {
+		t.Fatalf("expected 2 commitments after the rollback but got %d bytes", log.Len())
+	}
+	if len(p.history) != 2 || p.Height() != 2 {
+		t.Fatalf("expected 2 history entries at height 2 but got %d at height %d",
+			len(p.history), p.Height())
+	}
+
+	// Without paranoid mode, the corruption goes unnoticed.
+	p.ParanoidMode = false
+	err = p.Modify(makeTestLeaves(1, len(leaves)), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Len() != 3*CommitmentEntrySize {
+		t.Fatalf("expected 3 commitments but got %d bytes", log.Len())
+	}
+	if p.checkHashes() == nil {
+		t.Fatalf("expected the hashes to be inconsistent")
+	}
+}
+
+func TestRequireSortedAdds(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.RequireSortedAdds = true
+
+	unsorted := []Leaf{{Hash: Hash{3}}, {Hash: Hash{1}}, {Hash: Hash{2}}}
+	err := p.Modify(unsorted, nil, Proof{})
+	if !errors.Is(err, ErrUnsortedAdds) {
+		t.Fatalf("expected %v but got %v", ErrUnsortedAdds, err)
+	}
+	if p.NumLeaves != 0 || len(p.Roots) != 0 || len(p.NodeMap) != 0 {
+		t.Fatalf("expected the pollard to be unmodified but got:\n%s", p.String())
+	}
+
+	duplicates := []Leaf{{Hash: Hash{1}}, {Hash: Hash{1}}}
+	err = p.Modify(duplicates, nil, Proof{})
+	if !errors.Is(err, ErrUnsortedAdds) {
+		t.Fatalf("expected %v but got %v", ErrUnsortedAdds, err)
+	}
+
+	sorted := []Leaf{{Hash: Hash{1}}, {Hash: Hash{2}}, {Hash: Hash{3}}}
+	err = p.Modify(sorted, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.NumLeaves != 3 {
+		t.Fatalf("expected 3 leaves but got %d", p.NumLeaves)
+	}
+
+	// The order is only checked when the option is set.
+	p.RequireSortedAdds = false
+	err = p.Modify([]Leaf{{Hash: Hash{9}}, {Hash: Hash{8}}}, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmptyPositions(t *testing.T) {
+	t.Parallel()
+
+	// 28
+	// |-------------------------------\
+	// 24                              25
+	// |---------------\               |---------------\
+	// 16      17      18      19      20
+	// |---\   |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07  08  09  10
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(11, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting leaves that aren't roots doesn't leave empty positions.
+	err = deleteHashes(&p, []Hash{leaves[1].Hash, leaves[5].Hash, leaves[9].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.EmptyPositions()) != 0 {
+		t.Fatalf("expected no empty positions but got %v", p.EmptyPositions())
+	}
+
+	// Deleting the root at 10 and all the leaves under the root at 20 leave both
+	// of the roots empty.
+	err = deleteHashes(&p, []Hash{leaves[10].Hash, leaves[8].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{20, 10}
+	if !reflect.DeepEqual(expected, p.EmptyPositions()) {
+		t.Fatalf("expected empty positions %v but got %v", expected, p.EmptyPositions())
+	}
+
+	// Every root is either empty or has live leaves under it.
+	totalPositions, usedLeaves, _ := p.Capacity()
+	if uint64(len(p.EmptyPositions()))+usedLeaves > totalPositions {
+		t.Fatalf("%d empty positions and %d used leaves don't fit in %d positions",
+			len(p.EmptyPositions()), usedLeaves, totalPositions)
+	}
+
+	// Adding a leaf merges over both of the empty roots.
+	err = p.Modify(makeTestLeaves(1, len(leaves)), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = nil
+	if !reflect.DeepEqual(expected, p.EmptyPositions()) {
+		t.Fatalf("expected empty positions %v but got %v", expected, p.EmptyPositions())
+	}
+}
+
+func TestWriteAndReadFullFlag(t *testing.T) {
+	t.Parallel()
+
+	for _, full := range []bool{true, false} {
+		p := NewAccumulator(full)
+		leaves := makeTestLeaves(15, 0)
+		leaves[3].Remember = true
+		leaves[12].Remember = true
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		wroteBytes, err := p.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wroteBytes != int64(p.SerializeSize()) {
+			t.Fatalf("wrote %d bytes but serialize size is %d", wroteBytes, p.SerializeSize())
+		}
+
+		_, restored, err := RestorePollardFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.IsFull() != full {
+			t.Fatalf("expected IsFull %v but got %v", full, restored.IsFull())
+		}
+		if !reflect.DeepEqual(p.GetRoots(), restored.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(p.GetRoots()), printHashes(restored.GetRoots()))
+		}
+		err = compareNodeMap(p.NodeMap, restored.NodeMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The remembered leaves are still provable.
+		delHashes := []Hash{leaves[3].Hash, leaves[12].Hash}
+		proof, err := restored.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = restored.Verify(delHashes, proof, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Add more leaves and check that the caching behavior is the same.
+		adds := makeTestLeaves(9, len(leaves))
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = restored.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.GetRoots(), restored.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(p.GetRoots()), printHashes(restored.GetRoots()))
+		}
+		if p.String() != restored.String() {
+			t.Fatalf("expected:\n%s\ngot:\n%s", p.String(), restored.String())
+		}
+		_, cached := restored.NodeMap[adds[0].mini()]
+		if cached != full {
+			t.Fatalf("full %v: expected the added leaf cached to be %v", full, full)
+		}
+		if full {
+			if uint64(len(restored.NodeMap)) != restored.NumLeaves {
+				t.Fatalf("expected %d leaves in the map but got %d",
+					restored.NumLeaves, len(restored.NodeMap))
+			}
+		} else if len(restored.NodeMap) != 2 {
+			t.Fatalf("expected 2 leaves in the map but got %d", len(restored.NodeMap))
+		}
+	}
+}
+
+func TestRestoreUnknownVersion(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	err := p.Modify(makeTestLeaves(15, 0), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = p.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Bytes()[0] != serializeVersion {
+		t.Fatalf("expected version %d but got %d", serializeVersion, buf.Bytes()[0])
+	}
+
+	for _, version := range []byte{0, serializeVersion + 1, 0xff} {
+		serialized := append([]byte(nil), buf.Bytes()...)
+		serialized[0] = version
+
+		_, restored, err := RestorePollardFrom(bytes.NewReader(serialized))
+		if !errors.Is(err, ErrUnknownVersion) {
+			t.Fatalf("version %d: expected %v but got %v", version, ErrUnknownVersion, err)
+		}
+		if restored != nil {
+			t.Fatalf("version %d: expected no pollard to be restored", version)
+		}
+
+		_, restored, err = DeserializeChunk(bytes.NewReader(serialized),
+			SerializeCursor{ChunkNodes: 5})
+		if !errors.Is(err, ErrUnknownVersion) {
+			t.Fatalf("version %d: expected %v but got %v", version, ErrUnknownVersion, err)
+		}
+		if restored != nil {
+			t.Fatalf("version %d: expected no pollard to be restored", version)
+		}
+	}
+}
+
+func TestPruneToSize(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(false)
+	leaves := makeTestLeaves(64, 0)
+	for i := range leaves {
+		leaves[i].Remember = true
+	}
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := []Hash{leaves[3].Hash, leaves[40].Hash, leaves[41].Hash}
+	p.MarkHot(hot)
+
+	before := p.GetTotalCount()
+	err = p.PruneToSize(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTotalCount() >= before {
+		t.Fatalf("expected fewer than %d nodes after pruning but have %d",
+			before, p.GetTotalCount())
+	}
+
+	// The hot leaves are still provable.
+	proof, err := p.Prove(hot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}, hot, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cold leaves are forgotten.
+	for i, leaf := range leaves {
+		if i == 3 || i == 40 || i == 41 {
+			continue
+		}
+		_, err = p.Prove([]Hash{leaf.Hash})
+		if err == nil {
+			t.Fatalf("expected leaf %d to be forgotten", i)
+		}
+	}
+
+	// A budget that's already met doesn't forget anything.
+	count := p.GetTotalCount()
+	err = p.PruneToSize(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GetTotalCount() != count {
+		t.Fatalf("expected %d nodes but have %d", count, p.GetTotalCount())
+	}
+
+	full := NewAccumulator(true)
+	err = full.PruneToSize(0)
+	if err == nil {
+		t.Fatalf("expected an error pruning a full pollard")
+	}
+}
+
+func TestCheckSubtree(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	err := p.Modify(makeTestLeaves(15, 0), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range p.Roots {
+		err = p.CheckSubtree(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupt a node in the middle of the first tree.
+	p.Roots[0].lNiece.rNiece.data[0] ^= 0xff
+	err = p.CheckSubtree(0)
+	if err == nil {
+		t.Fatalf("expected the corruption under root 0 to be caught")
+	}
+	if p.checkHashes() == nil {
+		t.Fatalf("expected checkHashes to catch the corruption")
+	}
+	err = p.CheckSubtree(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, idx := range []int{-1, len(p.Roots)} {
+		err = p.CheckSubtree(idx)
+		if err == nil {
+			t.Fatalf("expected an error for root index %d", idx)
+		}
+	}
+}
+
+func TestCheckPositionConsistency(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.CheckPositionConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the node of a leaf around and put it back in the map after it's deleted.
+	stale := p.NodeMap[leaves[2].mini()]
+	err = deleteHashes(&p, []Hash{leaves[2].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.CheckPositionConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.NodeMap[leaves[2].mini()] = stale
+	err = p.CheckPositionConsistency()
+	if err == nil {
+		t.Fatalf("expected the stale node map entry to be caught")
+	}
+	delete(p.NodeMap, leaves[2].mini())
+
+	// A node under the wrong key must be caught as well.
+	p.NodeMap[leaves[2].mini()] = p.NodeMap[leaves[3].mini()]
+	err = p.CheckPositionConsistency()
+	if err == nil {
+		t.Fatalf("expected the node under the wrong key to be caught")
+	}
+}
+
+func TestDisableHashCache(t *testing.T) {
+	t.Parallel()
+
+	sc := newSimChain(0x07)
+	cached := NewAccumulator(true)
+	uncached := NewAccumulator(true)
+	uncached.DisableHashCache = true
+
+	for b := 0; b < 50; b++ {
+		adds, _, delHashes := sc.NextBlock(10)
+
+		for _, p := range []*Pollard{&cached, &uncached} {
+			proof, err := p.Prove(delHashes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.Modify(adds, delHashes, proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !reflect.DeepEqual(cached.GetRoots(), uncached.GetRoots()) {
+			t.Fatalf("block %d: roots don't match.\ncached:\n%s\nuncached:\n%s", b,
+				printHashes(cached.GetRoots()), printHashes(uncached.GetRoots()))
+		}
+	}
+
+	err := uncached.sanityCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkModifyHashCache(b *testing.B) {
+	for _, disable := range []bool{false, true} {
+		name := "cached"
+		if disable {
+			name = "uncached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			hashes := 0
+			p := NewAccumulator(true)
+			p.Hasher = func(l, r Hash) Hash {
+				hashes++
+				return parentHash(l, r)
+			}
+			err := p.Modify(makeTestLeaves(1<<12, 0), nil, Proof{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			p.DisableHashCache = disable
+
+			hashes = 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err = p.Modify(makeTestLeaves(1, int(p.NumLeaves)), nil, Proof{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(hashes)/float64(b.N), "hashes/op")
+		})
+	}
+}
+
+func TestSubforest(t *testing.T) {
+	t.Parallel()
+
+	// 15 leaves makes trees with 8, 4, 2, and 1 leaves.
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[9].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+
+	sub, err := p.Subforest([]int{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.NumLeaves != 5 {
+		t.Fatalf("expected 5 leaves but got %d", sub.NumLeaves)
+	}
+	expectRoots := []Hash{beforeRoots[1], beforeRoots[3]}
+	if !reflect.DeepEqual(expectRoots, sub.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(expectRoots), printHashes(sub.GetRoots()))
+	}
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) {
+		t.Fatalf("pollard changed after taking a subforest")
+	}
+	err = sub.sanityCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leaves under the selected trees are provable in the subforest.
+	proveHashes := []Hash{leaves[8].Hash, leaves[10].Hash, leaves[14].Hash}
+	proof, err := sub.Prove(proveHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: sub.GetRoots(), NumLeaves: sub.NumLeaves}, proveHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaves under the other trees are not in the subforest.
+	_, err = sub.Prove([]Hash{leaves[0].Hash})
+	if err == nil {
+		t.Fatalf("expected leaf 0 to not be in the subforest")
+	}
+
+	for _, indices := range [][]int{{4}, {-1}, {1, 1}} {
+		_, err = p.Subforest(indices)
+		if err == nil {
+			t.Fatalf("expected an error for root indices %v", indices)
+		}
+	}
+}
+
+func TestWalkNodes(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(27, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[1].Hash, leaves[8].Hash, leaves[9].Hash,
+		leaves[26].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encode the nodes into a custom format.
+	var buf bytes.Buffer
+	err = p.WalkNodes(func(pos uint64, h Hash, isLeaf bool) error {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], pos)
+		buf.Write(b[:])
+		buf.Write(h[:])
+		if isLeaf {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode and check that every node is at its position.
+	hashes := make(map[uint64]Hash)
+	isLeaves := make(map[uint64]bool)
+	for buf.Len() > 0 {
+		var b [8 + 32 + 1]byte
+		_, err = buf.Read(b[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := binary.LittleEndian.Uint64(b[:8])
+		var h Hash
+		copy(h[:], b[8:40])
+
+		if _, found := hashes[pos]; found {
+			t.Fatalf("position %d visited twice", pos)
+		}
+		if p.getHash(pos) != h {
+			t.Fatalf("expected %s at position %d but got %s", p.getHash(pos), pos, h)
+		}
+		hashes[pos] = h
+		isLeaves[pos] = b[40] == 1
+	}
+
+	var cached int64
+	for _, root := range p.Roots {
+		if root.data != empty {
+			cached += getCount(root)
+		}
+	}
+	if int64(len(hashes)) != cached {
+		t.Fatalf("expected %d nodes but visited %d", cached, len(hashes))
+	}
+
+	// Recompute the roots from the leaves.
+	totalRows := treeRows(p.NumLeaves)
+	var hashAt func(pos uint64) Hash
+	hashAt = func(pos uint64) Hash {
+		if isLeaves[pos] {
+			return hashes[pos]
+		}
+		return parentHash(hashAt(leftChild(pos, totalRows)), hashAt(rightChild(pos, totalRows)))
+	}
+	for i, rootPos := range RootPositions(p.NumLeaves, totalRows) {
+		if p.Roots[i].data == empty {
+			continue
+		}
+		if got := hashAt(rootPos); got != p.Roots[i].data {
+			t.Fatalf("expected root %s but calculated %s", p.Roots[i].data, got)
+		}
+	}
+
+	// The walk stops on the first error.
+	stopErr := fmt.Errorf("stop")
+	visits := 0
+	err = p.WalkNodes(func(uint64, Hash, bool) error {
+		visits++
+		if visits == 3 {
+			return stopErr
+		}
+		return nil
+	})
+	if err != stopErr || visits != 3 {
+		t.Fatalf("expected the walk to stop after 3 visits with the error. "+
+			"visits %d, err %v", visits, err)
+	}
+
+	// Only the cached nodes of a pruned pollard are visited.
+	pruned := NewAccumulator(false)
+	prunedLeaves := append([]Leaf(nil), leaves...)
+	prunedLeaves[5].Remember = true
+	prunedLeaves[17].Remember = true
+	err = pruned.Modify(prunedLeaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	visits = 0
+	err = pruned.WalkNodes(func(pos uint64, h Hash, _ bool) error {
+		visits++
+		if pruned.getHash(pos) != h {
+			return fmt.Errorf("expected %s at position %d but got %s",
+				pruned.getHash(pos), pos, h)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(visits) != pruned.GetTotalCount() {
+		t.Fatalf("expected %d nodes but visited %d", pruned.GetTotalCount(), visits)
+	}
+}
+
+func TestHashCollision(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(6, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+	beforeStr := p.String()
+
+	// The first root is the root of the leaves 0 to 3 and its left child is the parent
+	// of the leaves 0 and 1.
+	root := Leaf{Hash: p.Roots[0].data}
+	internal := Leaf{Hash: p.Roots[0].lNiece.data}
+
+	// Only shares the miniHash with an existing leaf.
+	sameMini := Leaf{Hash: leaves[2].Hash}
+	sameMini.Hash[31] ^= 0xff
+
+	tests := []struct {
+		name string
+		adds []Leaf
+	}{
+		{"root", []Leaf{root}},
+		{"internal node", []Leaf{internal}},
+		{"existing leaf", makeTestLeaves(1, 3)},
+		{"same miniHash", []Leaf{sameMini}},
+		{"duplicate adds", []Leaf{{Hash: Hash{0xaa}}, {Hash: Hash{0xbb}}, {Hash: Hash{0xaa}}}},
+	}
+	for _, test := range tests {
+		err = p.Modify(test.adds, nil, Proof{})
+		if !errors.Is(err, ErrHashCollision) {
+			t.Fatalf("%s: expected ErrHashCollision but got %v", test.name, err)
+		}
+		if !reflect.DeepEqual(beforeRoots, p.GetRoots()) || beforeStr != p.String() {
+			t.Fatalf("%s: pollard changed after the collision", test.name)
+		}
+	}
+
+	// Re-adding a leaf that's deleted in the same modify is not a collision.
+	proof, err := p.Prove([]Hash{leaves[5].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify([]Leaf{leaves[5]}, []Hash{leaves[5].Hash}, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddDeleteConflict(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(6, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+	beforeStr := p.String()
+
+	// The hash is added and deleted but it's not a leaf in the accumulator.
+	proof, err := p.Prove([]Hash{leaves[1].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := makeTestLeaves(1, len(leaves))
+	err = p.Modify(pending, []Hash{pending[0].Hash}, proof)
+	if !errors.Is(err, ErrAddDeleteConflict) {
+		t.Fatalf("expected %v but got %v", ErrAddDeleteConflict, err)
+	}
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) || beforeStr != p.String() {
+		t.Fatalf("pollard changed after the conflict")
+	}
+
+	// Deleting an existing leaf and adding it back is allowed.
+	adds := append([]Leaf{leaves[1]}, pending...)
+	err = p.Modify(adds, []Hash{leaves[1].Hash}, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pruned pollard can't tell an existing leaf that it doesn't have cached apart
+	// from a new one.
+	pruned := NewAccumulator(false)
+	err = pruned.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.Modify(adds, []Hash{leaves[1].Hash}, proof)
+	if !errors.Is(err, ErrAddDeleteConflict) {
+		t.Fatalf("expected %v but got %v", ErrAddDeleteConflict, err)
+	}
+	if !reflect.DeepEqual(beforeRoots, pruned.GetRoots()) {
+		t.Fatalf("pruned pollard changed after the conflict")
+	}
+}
+
+func TestSerializeChunk(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(100, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delHashes := []Hash{leaves[1].Hash, leaves[40].Hash, leaves[77].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	_, err = p.WriteTo(&expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each node is a 32 byte hash, a leaf flag, and a niece flag.
+	numNodes := (expected.Len() - headerSize - checksumSize) / 34
+	cursor := SerializeCursor{ChunkNodes: (numNodes + 9) / 10}
+
+	var buf bytes.Buffer
+	chunks := 0
+	for done := false; !done; {
+		cursor, done, err = p.SerializeChunk(&buf, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks++
+	}
+	if chunks != 10 {
+		t.Fatalf("expected 10 chunks but got %d", chunks)
+	}
+	if !bytes.Equal(expected.Bytes(), buf.Bytes()) {
+		t.Fatalf("chunked serialization differs from WriteTo")
+	}
+
+	readCursor := SerializeCursor{ChunkNodes: cursor.ChunkNodes}
+	var restored *Pollard
+	for chunks = 0; restored == nil; chunks++ {
+		readCursor, restored, err = DeserializeChunk(&buf, readCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if chunks != 10 {
+		t.Fatalf("expected 10 chunks but got %d", chunks)
+	}
+	if !reflect.DeepEqual(p.GetRoots(), restored.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(p.GetRoots()), printHashes(restored.GetRoots()))
+	}
+	err = compareNodeMap(p.NodeMap, restored.NodeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restored.checkHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreChecksumMismatch(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(31, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = p.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()
+
+	// Flip a byte in the hash of the node in the middle. The data still reads fine
+	// but the checksum doesn't match. Flipping the checksum itself is caught as well.
+	numNodes := (len(serialized) - headerSize - checksumSize) / 34
+	for _, idx := range []int{headerSize + (numNodes/2)*34 + 16, len(serialized) - 1} {
+		corrupted := append([]byte(nil), serialized...)
+		corrupted[idx] ^= 0x01
+
+		_, restored, err := RestorePollardFrom(bytes.NewReader(corrupted))
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("idx %d: expected %v but got %v", idx, ErrChecksumMismatch, err)
+		}
+		if restored != nil {
+			t.Fatalf("idx %d: expected no pollard to be restored", idx)
+		}
+
+		r := bytes.NewReader(corrupted)
+		cursor := SerializeCursor{ChunkNodes: 5}
+		for {
+			cursor, restored, err = DeserializeChunk(r, cursor)
+			if err != nil || restored != nil {
+				break
+			}
+		}
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("idx %d: expected %v but got %v", idx, ErrChecksumMismatch, err)
+		}
+	}
+
+	// Data from before the checksum was added is rejected for its version before the
+	// missing checksum is noticed.
+	old := append([]byte(nil), serialized[:len(serialized)-checksumSize]...)
+	old[0] = 1
+	_, _, err = RestorePollardFrom(bytes.NewReader(old))
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected %v but got %v", ErrUnknownVersion, err)
+	}
+
+	_, restored, err := RestorePollardFrom(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.GetRoots(), restored.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(p.GetRoots()), printHashes(restored.GetRoots()))
+	}
+}
+
+func TestEpoch(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	if p.Epoch() != 0 {
+		t.Fatalf("expected epoch 0 but got %d", p.Epoch())
+	}
+
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Epoch() != 1 {
+		t.Fatalf("expected epoch 1 but got %d", p.Epoch())
+	}
+	prevRoots := p.GetRoots()
+
+	// Read only operations don't change the epoch.
+	delHashes := []Hash{leaves[0].Hash, leaves[7].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify(delHashes, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Epoch() != 1 {
+		t.Fatalf("expected epoch 1 but got %d", p.Epoch())
+	}
+
+	adds := makeTestLeaves(3, len(leaves))
+	err = p.Modify(adds, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Epoch() != 2 {
+		t.Fatalf("expected epoch 2 but got %d", p.Epoch())
+	}
+
+	// The epoch keeps going up on an undo even though the height goes down.
+	err = p.Undo(uint64(len(adds)), proof, delHashes, prevRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Epoch() != 3 {
+		t.Fatalf("expected epoch 3 but got %d", p.Epoch())
+	}
+	if p.Height() != 1 {
+		t.Fatalf("expected height 1 but got %d", p.Height())
+	}
+}
+
+func TestFindByPrefix(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	for i := range leaves {
+		// Give every fourth leaf the same prefix.
+		if i%4 == 0 {
+			leaves[i].Hash[1] = leaves[i].Hash[0]
+			leaves[i].Hash[0] = 0xde
+		}
+	}
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := p.FindByPrefix([]byte{0xde})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hash{leaves[0].Hash, leaves[4].Hash, leaves[8].Hash, leaves[12].Hash, leaves[16].Hash}
+	if !reflect.DeepEqual(expected, found) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(found))
+	}
+
+	// Delete one of the found leaves and it's no longer found.
+	err = deleteHashes(&p, found[1:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err = p.FindByPrefix([]byte{0xde})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected[:1], expected[2:]...)
+	if !reflect.DeepEqual(expected, found) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(found))
+	}
+
+	// The whole hash is a valid prefix.
+	found, err = p.FindByPrefix(leaves[3].Hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != leaves[3].Hash {
+		t.Fatalf("expected only %s but got:\n%s", leaves[3].Hash, printHashes(found))
+	}
+
+	_, err = p.FindByPrefix(make([]byte, 33))
+	if err == nil {
+		t.Fatalf("expected an error for a prefix longer than 32 bytes")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	if p.Fingerprint() != "0:" {
+		t.Fatalf("expected fingerprint \"0:\" but got %q", p.Fingerprint())
+	}
+
+	leaves := makeTestLeaves(7, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := p.GetRoots()
+	expected := fmt.Sprintf("7:%x|%x|%x", roots[0][:4], roots[1][:4], roots[2][:4])
+	if p.Fingerprint() != expected {
+		t.Fatalf("expected fingerprint %q but got %q", expected, p.Fingerprint())
+	}
+
+	// The same state gives the same fingerprint.
+	other := NewAccumulator(false)
+	err = other.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fingerprint() != other.Fingerprint() {
+		t.Fatalf("expected the same fingerprint but got %q and %q",
+			p.Fingerprint(), other.Fingerprint())
+	}
+
+	// A different state gives a different fingerprint.
+	err = deleteHashes(&p, []Hash{leaves[6].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fingerprint() == other.Fingerprint() {
+		t.Fatalf("expected different fingerprints but both are %q", p.Fingerprint())
+	}
+}
+
+// makeModifyOps returns the blocks of a simulated chain along with the roots after
+// applying all of them.
+func makeModifyOps(numBlocks int, numAdds uint32) ([]ModifyOp, []Hash, error) {
+	p := NewAccumulator(true)
+	sc := newSimChain(0x07)
+
+	blocks := make([]ModifyOp, 0, numBlocks)
+	for b := 0; b < numBlocks; b++ {
+		adds, _, delHashes := sc.NextBlock(numAdds)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks = append(blocks, copyModifyOp(adds, delHashes, proof))
+
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return blocks, p.GetRoots(), nil
+}
+
+func TestModifyMany(t *testing.T) {
+	t.Parallel()
+
+	blocks, expectedRoots, err := makeModifyOps(100, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, disableHashCache := range []bool{false, true} {
+		p := NewAccumulator(true)
+		p.DisableHashCache = disableHashCache
+		err = p.ModifyMany(blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expectedRoots, p.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(expectedRoots), printHashes(p.GetRoots()))
+		}
+		if p.Height() != uint64(len(blocks)) {
+			t.Fatalf("expected height %d but got %d", len(blocks), p.Height())
+		}
+		err = p.checkHashes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.posMapSanity()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The blocks before the failing one stay applied.
+	p := NewAccumulator(true)
+	bad := append([]ModifyOp(nil), blocks[:3]...)
+	bad = append(bad, ModifyOp{DelHashes: []Hash{{1}, {1}}, Proof: Proof{Targets: []uint64{0, 1}}})
+	err = p.ModifyMany(bad)
+	if !errors.Is(err, ErrDuplicateDeletion) {
+		t.Fatalf("expected %v but got %v", ErrDuplicateDeletion, err)
+	}
+	if p.Height() != 3 {
+		t.Fatalf("expected height 3 but got %d", p.Height())
+	}
+}
+
+func BenchmarkModifyMany(b *testing.B) {
+	blocks, _, err := makeModifyOps(100, 100)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("ModifyMany", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewAccumulator(true)
+			err := p.ModifyMany(blocks)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Modify loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := NewAccumulator(true)
+			for _, block := range blocks {
+				err := p.Modify(block.Adds, block.DelHashes, block.Proof)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestModifyInto(t *testing.T) {
+	t.Parallel()
+
+	blocks, _, err := makeModifyOps(200, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scratch := range []*ModifyScratch{nil, {}} {
+		p := NewAccumulator(true)
+		expected := NewAccumulator(true)
+		for i, block := range blocks {
+			err = p.ModifyInto(block.Adds, block.DelHashes, block.Proof.Targets, scratch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = expected.Modify(block.Adds, block.DelHashes, block.Proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(expected.GetRoots(), p.GetRoots()) {
+				t.Fatalf("block %d: expected roots:\n%s\ngot:\n%s", i,
+					printHashes(expected.GetRoots()), printHashes(p.GetRoots()))
+			}
+			if expected.NumLeaves != p.NumLeaves || expected.NumDels != p.NumDels {
+				t.Fatalf("block %d: expected numLeaves %d numDels %d but got %d %d", i,
+					expected.NumLeaves, expected.NumDels, p.NumLeaves, p.NumDels)
+			}
+		}
+		err = compareNodeMap(expected.NodeMap, p.NodeMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.checkHashes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.posMapSanity()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The errors are the same as Modify and the scratch is still usable after.
+	scratch := &ModifyScratch{}
+	p := NewAccumulator(true)
+	err = p.ModifyInto(nil, []Hash{{1}, {1}}, []uint64{0, 1}, scratch)
+	if !errors.Is(err, ErrDuplicateDeletion) {
+		t.Fatalf("expected %v but got %v", ErrDuplicateDeletion, err)
+	}
+	err = p.ModifyInto(blocks[0].Adds, blocks[0].DelHashes, blocks[0].Proof.Targets, scratch)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkModifyInto(b *testing.B) {
+	// After the first few blocks, the sim chain deletes about as many leaves as it
+	// adds every block.
+	blocks, _, err := makeModifyOps(1000, 32)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Modify", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := NewAccumulator(true)
+			for _, block := range blocks {
+				err := p.Modify(block.Adds, block.DelHashes, block.Proof)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("ModifyInto", func(b *testing.B) {
+		b.ReportAllocs()
+		scratch := &ModifyScratch{}
+		for i := 0; i < b.N; i++ {
+			p := NewAccumulator(true)
+			for _, block := range blocks {
+				err := p.ModifyInto(block.Adds, block.DelHashes,
+					block.Proof.Targets, scratch)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestIsEmpty(t *testing.T) {
+	t.Parallel()
+
+	// A fresh pollard is empty.
+	p := NewAccumulator(true)
+	if !p.IsEmpty() {
+		t.Fatalf("expected a fresh pollard to be empty")
+	}
+	err := VerifyEmpty(p.GetRoots(), p.NumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pollard with leaves isn't empty.
+	leaves := makeTestLeaves(7, 0)
+	err = p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsEmpty() {
+		t.Fatalf("expected a pollard with %d leaves to not be empty", p.NumLeaves)
+	}
+
+	// Deleting all but one leaf still leaves it not empty.
+	delHashes := make([]Hash, 0, len(leaves))
+	for _, leaf := range leaves {
+		delHashes = append(delHashes, leaf.Hash)
+	}
+	err = deleteHashes(&p, delHashes[:6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsEmpty() {
+		t.Fatalf("expected a pollard with a live leaf to not be empty")
+	}
+
+	// A pollard with all of its leaves deleted is empty but it's not a fresh
+	// accumulator.
+	err = deleteHashes(&p, delHashes[6:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsEmpty() {
+		t.Fatalf("expected a pollard with all of its leaves deleted to be empty")
+	}
+	err = VerifyEmpty(p.GetRoots(), p.NumLeaves)
+	if err == nil {
+		t.Fatalf("expected an error for an accumulator with all of its leaves deleted")
+	}
+}
+
+func TestCommitmentLog(t *testing.T) {
+	t.Parallel()
+
+	type commitment struct {
+		height    uint64
+		stateHash Hash
+	}
+
+	p := NewAccumulator(true)
+	var log bytes.Buffer
+	p.CommitmentLog(&log)
+
+	var expected []commitment
+	var undos []UndoData
+	sc := newSimChain(0x07)
+	for b := 0; b < 5; b++ {
+		adds, _, delHashes := sc.NextBlock(6)
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ud, err := p.ModifyWithUndo(adds, delHashes, proof.Targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		undos = append(undos, ud)
+		expected = append(expected, commitment{uint64(b + 1), p.StateHash()})
+	}
+
+	// Undo the last 2 blocks. Each undo is a rollback entry with the state after it.
+	for i := len(undos) - 1; i >= 3; i-- {
+		err := p.UndoFromData(undos[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, commitment{CommitmentRollback, p.StateHash()})
+	}
+	if p.StateHash() != expected[2].stateHash {
+		t.Fatalf("expected the state at height 3 after the undos")
+	}
+
+	// The height continues from where the undos left it.
+	err := p.Modify(makeTestLeaves(3, 1000), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, commitment{4, p.StateHash()})
+
+	// Parse the log.
+	if log.Len()%CommitmentEntrySize != 0 {
+		t.Fatalf("log of %d bytes isn't a multiple of the entry size", log.Len())
+	}
+	var got []commitment
+	for log.Len() > 0 {
+		entry := log.Next(CommitmentEntrySize)
+		c := commitment{height: binary.LittleEndian.Uint64(entry[:8])}
+		copy(c.stateHash[:], entry[8:])
+		got = append(got, c)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected commitments %v but got %v", expected, got)
+	}
+
+	// Nothing is written once the log is disabled.
+	p.CommitmentLog(nil)
+	err = p.Modify(makeTestLeaves(3, 2000), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.Len() != 0 {
+		t.Fatalf("expected nothing to be written but got %d bytes", log.Len())
+	}
+}
+
+func TestLeafSetCommitment(t *testing.T) {
+	t.Parallel()
+
+	leaves := makeTestLeaves(20, 0)
+
+	// Add all the leaves and delete some of them.
+	p1 := NewAccumulator(true)
+	err := p1.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p1, []Hash{leaves[3].Hash, leaves[11].Hash, leaves[19].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add only the live leaves in reverse order over two blocks.
+	var live []Leaf
+	for i := len(leaves) - 1; i >= 0; i-- {
+		if i == 3 || i == 11 || i == 19 {
+			continue
+		}
+		live = append(live, leaves[i])
+	}
+	p2 := NewAccumulator(true)
+	err = p2.Modify(live[:5], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p2.Modify(live[5:], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p1.LeafSetCommitment() != p2.LeafSetCommitment() {
+		t.Fatalf("expected the same leaf set commitment but got %s and %s",
+			p1.LeafSetCommitment(), p2.LeafSetCommitment())
+	}
+	if p1.StateHash() == p2.StateHash() {
+		t.Fatalf("expected the state hashes to differ for different histories")
+	}
+
+	// A different leaf set has a different commitment.
+	err = deleteHashes(&p2, []Hash{leaves[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1.LeafSetCommitment() == p2.LeafSetCommitment() {
+		t.Fatalf("expected different leaf set commitments")
+	}
+}
+
+func TestCompactFor(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(false)
+	var remembered []Hash
+	for b := 0; b < 20; b++ {
+		leaves := makeTestLeaves(37, b*37)
+		for i := range leaves {
+			leaves[i].Remember = (i+b)%3 == 0
+			if leaves[i].Remember && i%2 == 0 {
+				remembered = append(remembered, leaves[i].Hash)
+			}
+		}
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := p.GetRoots()
+
+	// Forget half of the remembered leaves without pruning the nodes that were only
+	// needed for them.
+	keep := make(map[Hash]struct{}, len(remembered))
+	for _, hash := range remembered {
+		keep[hash] = struct{}{}
+	}
+	for k, node := range p.NodeMap {
+		if _, found := keep[node.data]; !found {
+			node.remember = false
+			delete(p.NodeMap, k)
+		}
+	}
+	before := p.GetTotalCount()
+
+	totalFreed, calls := 0, 0
+	for done := false; !done; calls++ {
+		var freed int
+		freed, done = p.CompactFor(0)
+		totalFreed += freed
+
+		// The pollard is consistent between the calls.
+		if !reflect.DeepEqual(roots, p.GetRoots()) {
+			t.Fatalf("expected roots:\n%s\ngot:\n%s",
+				printHashes(roots), printHashes(p.GetRoots()))
+		}
+	}
+	if calls < 2 {
+		t.Fatalf("expected the compaction to take more than one call but took %d", calls)
+	}
+	if totalFreed == 0 {
+		t.Fatalf("expected nodes to be freed")
+	}
+	if p.GetTotalCount() != before-int64(totalFreed) {
+		t.Fatalf("expected %d nodes after freeing %d but have %d",
+			before-int64(totalFreed), totalFreed, p.GetTotalCount())
+	}
+
+	// The remembered leaves can still be proven.
+	proof, err := p.Prove(remembered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: roots, NumLeaves: p.NumLeaves}, remembered, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There's nothing left to compact.
+	freed, done := p.CompactFor(time.Second)
+	if freed != 0 || !done {
+		t.Fatalf("expected nothing to be freed but freed %d, done %v", freed, done)
+	}
+}
+
+func TestRecomputeRoot(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(23, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave a leaf that moved up and an empty root.
+	err = deleteHashes(&p, []Hash{leaves[4].Hash, leaves[22].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, root := range p.GetRoots() {
+		got, err := p.RecomputeRoot(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != root {
+			t.Fatalf("root %d: expected %s but got %s", i, root, got)
+		}
+	}
+
+	// Corrupt a cached intermediate hash. It doesn't affect the recomputed root.
+	p.Roots[0].lNiece.data = Hash{1}
+	got, err := p.RecomputeRoot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != p.Roots[0].data {
+		t.Fatalf("expected %s but got %s", p.Roots[0].data, got)
+	}
+
+	// A pruned pollard doesn't have all the leaves.
+	pruned := NewAccumulator(false)
+	leaves[3].Remember = true
+	err = pruned.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pruned.RecomputeRoot(0)
+	if err == nil {
+		t.Fatalf("expected an error recomputing a pruned root")
+	}
+	_, err = pruned.RecomputeRoot(len(pruned.Roots))
+	if err == nil {
+		t.Fatalf("expected an error for a root index out of range")
+	}
+}
+
+func TestRebuildNodeMap(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(23, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave a leaf that moved up and an empty root.
+	stale := p.NodeMap[leaves[4].mini()]
+	err = deleteHashes(&p, []Hash{leaves[4].Hash, leaves[22].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[miniHash]*polNode, len(p.NodeMap))
+	for k, v := range p.NodeMap {
+		expected[k] = v
+	}
+
+	fixed, err := p.RebuildNodeMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 0 {
+		t.Fatalf("expected nothing to fix but fixed %d", fixed)
+	}
+
+	// A stale entry, a missing entry, and an entry pointing to the wrong node.
+	p.NodeMap[leaves[4].mini()] = stale
+	delete(p.NodeMap, leaves[7].mini())
+	p.NodeMap[leaves[9].mini()] = p.NodeMap[leaves[10].mini()]
+	err = p.CheckPositionConsistency()
+	if err == nil {
+		t.Fatalf("expected the corrupted node map to be caught")
+	}
+
+	fixed, err = p.RebuildNodeMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 4 {
+		t.Fatalf("expected 4 fixed entries but got %d", fixed)
+	}
+	err = p.CheckPositionConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = compareNodeMap(expected, p.NodeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the remembered leaves are in the node map of a pruned pollard.
+	pruned := NewAccumulator(false)
+	leaves[3].Remember = true
+	leaves[12].Remember = true
+	err = pruned.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(pruned.NodeMap, leaves[3].mini())
+	fixed, err = pruned.RebuildNodeMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 1 || len(pruned.NodeMap) != 2 {
+		t.Fatalf("expected 1 fixed entry and 2 entries but got %d and %d",
+			fixed, len(pruned.NodeMap))
+	}
+	err = pruned.CheckPositionConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLiveLeavesPerRoot(t *testing.T) {
+	t.Parallel()
+
+	// Trees of 8, 4, 2 and 1 leaves.
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dels     []Hash
+		expected []uint64
+	}{
+		{nil, []uint64{8, 4, 2, 1}},
+		{[]Hash{leaves[1].Hash, leaves[6].Hash}, []uint64{6, 4, 2, 1}},
+		{[]Hash{leaves[9].Hash, leaves[13].Hash}, []uint64{6, 3, 1, 1}},
+		// Deleting the only leaf of a tree leaves an empty root.
+		{[]Hash{leaves[14].Hash}, []uint64{6, 3, 1, 0}},
+		// The leaf that moved up to a root is still counted.
+		{[]Hash{leaves[0].Hash, leaves[2].Hash, leaves[3].Hash, leaves[4].Hash,
+			leaves[5].Hash}, []uint64{1, 3, 1, 0}},
+	}
+	for i, test := range tests {
+		if test.dels != nil {
+			err = deleteHashes(&p, test.dels)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		counts, err := p.LiveLeavesPerRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.expected, counts) {
+			t.Fatalf("test %d: expected %v but got %v", i, test.expected, counts)
+		}
+
+		sum := uint64(0)
+		for _, count := range counts {
+			sum += count
+		}
+		if sum != p.NumLeaves-p.NumDels {
+			t.Fatalf("test %d: expected a sum of %d but got %d",
+				i, p.NumLeaves-p.NumDels, sum)
+		}
+	}
+
+	pruned := NewAccumulator(false)
+	_, err = pruned.LiveLeavesPerRoot()
+	if err == nil {
+		t.Fatalf("expected an error for a pruned pollard")
+	}
+}
+
+func TestMatchesRoots(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(13, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := p.GetRoots()
+
+	err = p.MatchesRoots(trusted, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single differing root is named by its index.
+	wrong := append([]Hash(nil), trusted...)
+	wrong[1][0] ^= 0xff
+	err = p.MatchesRoots(wrong, 13)
+	if err == nil {
+		t.Fatalf("expected an error for a differing root")
+	}
+	expected := fmt.Sprintf("Root 1 is %s but the trusted root is %s", trusted[1], wrong[1])
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected the error to contain %q but got %v", expected, err)
+	}
+
+	err = p.MatchesRoots(trusted, 14)
+	if err == nil {
+		t.Fatalf("expected an error for a differing numLeaves")
+	}
+	err = p.MatchesRoots(trusted[:2], 13)
+	if err == nil {
+		t.Fatalf("expected an error for a differing number of roots")
+	}
+}
+
+func TestLeavesExpiringBy(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	noTTL := NewAccumulator(true)
+
+	// Block 1 adds leaves expiring at blocks 4, 2, never and 4.
+	leaves := makeTestLeaves(8, 0)
+	leaves[0].TTL = 3
+	leaves[1].TTL = 1
+	leaves[3].TTL = 3
+	err := p.Modify(leaves[:4], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 2 adds leaves expiring at blocks 3 and 12.
+	leaves[4].TTL = 1
+	leaves[5].TTL = 10
+	err = p.Modify(leaves[4:8], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		block    int32
+		expected []Hash
+	}{
+		{1, []Hash{}},
+		{2, []Hash{leaves[1].Hash}},
+		{3, []Hash{leaves[1].Hash, leaves[4].Hash}},
+		{4, []Hash{leaves[1].Hash, leaves[4].Hash, leaves[0].Hash, leaves[3].Hash}},
+		{100, []Hash{leaves[1].Hash, leaves[4].Hash, leaves[0].Hash, leaves[3].Hash,
+			leaves[5].Hash}},
+	}
+	for _, test := range tests {
+		got := p.LeavesExpiringBy(test.block)
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Fatalf("block %d: expected:\n%s\ngot:\n%s", test.block,
+				printHashes(test.expected), printHashes(got))
+		}
+	}
+
+	// Deleted leaves are no longer reported.
+	err = deleteHashes(&p, []Hash{leaves[1].Hash, leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.LeavesExpiringBy(4)
+	expected := []Hash{leaves[4].Hash, leaves[0].Hash}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(got))
+	}
+
+	// Undoing an add forgets its TTL and adding the hash again without a TTL doesn't
+	// bring it back.
+	extra := makeTestLeaves(1, 100)
+	extra[0].TTL = 1
+	beforeRoots := p.GetRoots()
+	err = p.Modify(extra, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Undo(1, Proof{}, nil, beforeRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = p.LeavesExpiringBy(1000)
+	expected = []Hash{leaves[4].Hash, leaves[0].Hash, leaves[5].Hash}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(got))
+	}
+	extra[0].TTL = 0
+	err = p.Modify(extra, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = p.LeavesExpiringBy(1000)
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(got))
+	}
+	err = deleteHashes(&p, []Hash{extra[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Undo doesn't know the TTLs of the deleted leaves but UndoFromData brings them back.
+	beforeRoots = p.GetRoots()
+	dels := []Hash{leaves[0].Hash}
+	proof, err := p.Prove(dels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud, err := p.ModifyWithUndo(nil, dels, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = p.LeavesExpiringBy(1000)
+	if !reflect.DeepEqual([]Hash{leaves[4].Hash, leaves[5].Hash}, got) {
+		t.Fatalf("expected:\n%s\ngot:\n%s",
+			printHashes([]Hash{leaves[4].Hash, leaves[5].Hash}), printHashes(got))
+	}
+	err = p.Undo(0, proof, dels, beforeRoots)
+	if !errors.Is(err, ErrUndoDataRequired) {
+		t.Fatalf("expected %v but got %v", ErrUndoDataRequired, err)
+	}
+	err = p.UndoFromData(ud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = p.LeavesExpiringBy(1000)
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected:\n%s\ngot:\n%s", printHashes(expected), printHashes(got))
+	}
+
+	// The TTLs don't affect the roots.
+	for i := range leaves {
+		leaves[i].TTL = 0
+	}
+	err = noTTL.Modify(leaves[:4], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = noTTL.Modify(leaves[4:8], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&noTTL, []Hash{leaves[1].Hash, leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = noTTL.Modify(extra, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&noTTL, []Hash{extra[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(noTTL.GetRoots(), p.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(noTTL.GetRoots()), printHashes(p.GetRoots()))
+	}
+}
+
+func TestValidateLeaves(t *testing.T) {
+	t.Parallel()
+
+	leaves := makeTestLeaves(10, 0)
+	err := ValidateLeaves(leaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateLeaves(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zero hash.
+	zeroHash := makeTestLeaves(10, 0)
+	zeroHash[6].Hash = empty
+	err = ValidateLeaves(zeroHash)
+	if !errors.Is(err, ErrZeroHashLeaf) || !strings.Contains(err.Error(), "idx 6") {
+		t.Fatalf("expected %v at idx 6 but got %v", ErrZeroHashLeaf, err)
+	}
+
+	// Duplicates.
+	duplicates := makeTestLeaves(10, 0)
+	duplicates[7].Hash = duplicates[2].Hash
+	err = ValidateLeaves(duplicates)
+	if !errors.Is(err, ErrHashCollision) || !strings.Contains(err.Error(), "idx 2 and 7") {
+		t.Fatalf("expected %v at idx 2 and 7 but got %v", ErrHashCollision, err)
+	}
+
+	// Hashes that only share the miniHash are duplicates as well.
+	duplicates = makeTestLeaves(10, 0)
+	duplicates[9].Hash = duplicates[4].Hash
+	duplicates[9].Hash[31] ^= 0xff
+	err = ValidateLeaves(duplicates)
+	if !errors.Is(err, ErrHashCollision) {
+		t.Fatalf("expected %v but got %v", ErrHashCollision, err)
+	}
+
+	// The first problem is returned.
+	zeroHash[8].Hash = zeroHash[1].Hash
+	zeroHash[3].Hash = empty
+	err = ValidateLeaves(zeroHash)
+	if !errors.Is(err, ErrZeroHashLeaf) || !strings.Contains(err.Error(), "idx 3") {
+		t.Fatalf("expected %v at idx 3 but got %v", ErrZeroHashLeaf, err)
+	}
+}
+
+func TestDedupeReplays(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.DedupeReplays = true
+	expected := NewAccumulator(true)
+
+	leaves := makeTestLeaves(20, 0)
+	for _, acc := range []*Pollard{&p, &expected} {
+		err := acc.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The block to replay.
+	adds := makeTestLeaves(5, 20)
+	delHashes := []Hash{leaves[3].Hash, leaves[11].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acc := range []*Pollard{&p, &expected} {
+		err = acc.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = p.Modify(adds, delHashes, proof)
+	if !errors.Is(err, ErrReplayIgnored) {
+		t.Fatalf("expected %v but got %v", ErrReplayIgnored, err)
+	}
+	if p.StateHash() != expected.StateHash() || p.Height() != expected.Height() ||
+		p.NumDels != expected.NumDels {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected.String(), p.String())
+	}
+	err = compareNodeMap(expected.NodeMap, p.NodeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the block that was just applied is ignored.
+	err = p.Modify(makeTestLeaves(2, 25), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+	err = p.Modify(makeTestLeaves(2, 27), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(makeTestLeaves(2, 27), nil, Proof{})
+	if !errors.Is(err, ErrReplayIgnored) {
+		t.Fatalf("expected %v but got %v", ErrReplayIgnored, err)
+	}
+
+	// After an undo, the block can be applied again.
+	prevRoots := p.GetRoots()
+	err = p.Undo(2, Proof{}, nil, beforeRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(makeTestLeaves(2, 27), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prevRoots, p.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(prevRoots), printHashes(p.GetRoots()))
+	}
+
+	// Empty blocks in a row are all applied.
+	height := p.Height()
+	for i := 0; i < 3; i++ {
+		err = p.Modify(nil, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Height() != height+3 {
+		t.Fatalf("expected height %d but got %d", height+3, p.Height())
+	}
+}
+
+func TestToPositionalArray(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	hashes, numLeaves, err := p.ToPositionalArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 0 || numLeaves != 0 {
+		t.Fatalf("expected no hashes and 0 leaves but got %d hashes and %d leaves",
+			len(hashes), numLeaves)
+	}
+
+	leaves := makeTestLeaves(23, 0)
+	err = p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[2].Hash, leaves[3].Hash, leaves[9].Hash,
+		leaves[22].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, numLeaves, err = p.ToPositionalArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forestRows := p.GetTreeRows()
+	if numLeaves != p.NumLeaves || uint64(len(hashes)) != maxPosition(forestRows) {
+		t.Fatalf("expected %d hashes and %d leaves but got %d hashes and %d leaves",
+			maxPosition(forestRows), p.NumLeaves, len(hashes), numLeaves)
+	}
+
+	for pos, hash := range hashes {
+		expected := empty
+		if inForest(uint64(pos), p.NumLeaves, forestRows) {
+			n, _, _, err := p.getNode(uint64(pos))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != nil {
+				expected = n.data
+			}
+		}
+		if hash != expected {
+			t.Fatalf("position %d: expected %s but got %s", pos, expected, hash)
+		}
+	}
+
+	// The roots are at the root positions and the leaves that weren't deleted or
+	// moved are at their original positions.
+	for i, rootPos := range RootPositions(p.NumLeaves, forestRows) {
+		if hashes[rootPos] != p.Roots[i].data {
+			t.Fatalf("root %d: expected %s but got %s", i, p.Roots[i].data, hashes[rootPos])
+		}
+	}
+	for _, i := range []int{4, 7, 12, 16, 21} {
+		if hashes[i] != leaves[i].Hash {
+			t.Fatalf("position %d: expected %s but got %s", i, leaves[i].Hash, hashes[i])
+		}
+	}
+
+	pruned := NewAccumulator(false)
+	err = pruned.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = pruned.ToPositionalArray()
+	if err == nil {
+		t.Fatalf("expected an error for a pruned pollard")
+	}
+}
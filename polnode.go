@@ -31,6 +31,17 @@ func (h Hash) mini() (m miniHash) {
 type Leaf struct {
 	Hash
 	Remember bool
+
+	// Value is an optional value that's tracked by the accumulator as a side statistic.
+	// It's not committed to by the accumulator. Only Pollard tracks the values and
+	// MapPollard rejects leaves that have one.
+	Value uint64
+
+	// TTL is the optional number of blocks after the block the leaf is added in that
+	// the leaf is expected to be deleted at. It's only tracked for the cached leaves
+	// and it's not committed to by the accumulator. A TTL of 0 isn't tracked. Only
+	// Pollard tracks the TTLs and MapPollard rejects leaves that have one.
+	TTL int32
 }
 
 // String returns the leaf as a human-readable string.
@@ -184,6 +195,122 @@ func (p *Pollard) getHash(pos uint64) Hash {
 	return n.data
 }
 
+// GetHashesSorted returns the hashes at the given positions. The positions must be sorted
+// in ascending order. Empty hashes are returned for the positions that aren't cached or
+// don't exist in the forest. The result is the same as calling GetHash for every
+// position but each tree is only descended once for all the positions under it which
+// is a lot faster when fetching many nearby positions like a whole row.
+func (p *Pollard) GetHashesSorted(positions []uint64) ([]Hash, error) {
+	nodes, err := p.getNodesSorted(positions)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]Hash, len(nodes))
+	for i, n := range nodes {
+		if n != nil {
+			hashes[i] = n.data
+		}
+	}
+
+	return hashes, nil
+}
+
+// getNodesSorted returns the nodes at the given sorted positions. nil is returned for the
+// positions that aren't cached or don't exist in the forest.
+func (p *Pollard) getNodesSorted(positions []uint64) ([]*polNode, error) {
+	forestRows := treeRows(p.NumLeaves)
+	for i, pos := range positions {
+		if i > 0 && positions[i-1] > pos {
+			return nil, fmt.Errorf("getNodesSorted error: positions are not sorted. "+
+				"%d comes after %d", pos, positions[i-1])
+		}
+		if pos >= maxPosition(forestRows) {
+			return nil, fmt.Errorf("Position %d does not exist in tree of %d leaves",
+				pos, p.NumLeaves)
+		}
+	}
+
+	nodes := make([]*polNode, len(positions))
+	rootPositions := RootPositions(p.NumLeaves, forestRows)
+
+	// Sorted positions are grouped by their rows. Fetch each row separately.
+	for start := 0; start < len(positions); {
+		row := detectRow(positions[start], forestRows)
+		end := start + 1
+		for end < len(positions) && detectRow(positions[end], forestRows) == row {
+			end++
+		}
+		rowPositions, rowNodes := positions[start:end], nodes[start:end]
+
+		for i, root := range p.Roots {
+			rootRow := detectRow(rootPositions[i], forestRows)
+			if rootRow < row {
+				continue
+			}
+
+			// The positions under this root on this row are a continuous range.
+			first, err := childMany(rootPositions[i], rootRow-row, forestRows)
+			if err != nil {
+				return nil, err
+			}
+			lo := sort.Search(len(rowPositions), func(i int) bool {
+				return rowPositions[i] >= first
+			})
+			hi := sort.Search(len(rowPositions), func(i int) bool {
+				return rowPositions[i] >= first+(1<<(rootRow-row))
+			})
+
+			collectNodes(root, nil, true, rootRow, row, first,
+				rowPositions[lo:hi], rowNodes[lo:hi])
+		}
+
+		start = end
+	}
+
+	return nodes, nil
+}
+
+// collectNodes sets the nodes at the given positions in the subtree of n. All the
+// positions must be sorted and on the targetRow. sibling is the sibling of n which points
+// to the children of n. first is the leftmost position on the targetRow in the subtree
+// of n.
+func collectNodes(n, sibling *polNode, isRoot bool, row, targetRow uint8, first uint64,
+	positions []uint64, nodes []*polNode) {
+
+	if len(positions) == 0 {
+		return
+	}
+	if row == targetRow {
+		for i := range nodes {
+			nodes[i] = n
+		}
+		return
+	}
+
+	// Roots point to their children. Other nodes have their children pointed to by
+	// their sibling so a node that isn't cached may still have its children cached.
+	var lChild, rChild *polNode
+	switch {
+	case isRoot && n != nil:
+		lChild, rChild = n.lNiece, n.rNiece
+	case !isRoot && sibling != nil:
+		lChild, rChild = sibling.lNiece, sibling.rNiece
+	default:
+		return
+	}
+
+	half := uint64(1) << (row - targetRow - 1)
+	split := sort.Search(len(positions), func(i int) bool {
+		return positions[i] >= first+half
+	})
+
+	collectNodes(lChild, rChild, false, row-1, targetRow, first,
+		positions[:split], nodes[:split])
+	collectNodes(rChild, lChild, false, row-1, targetRow, first+half,
+		positions[split:], nodes[split:])
+}
+
 func (p *Pollard) calculatePosition(node *polNode) uint64 {
 	// Tells whether to follow the left child or the right child when going
 	// down the tree. 0 means left, 1 means right.
@@ -427,14 +554,14 @@ func updateAunt(n *polNode) {
 
 // hashToRoot calculates the hash of the node passed in and all its ancestors
 // up to the root.
-func hashToRoot(node *polNode) error {
+func hashToRoot(node *polNode, hasher Hasher) error {
 	for node != nil {
 		// Grab children of this parent.
 		leftChild, rightChild, err := node.getChildren()
 		if err != nil {
 			return err
 		}
-		node.data = parentHash(leftChild.data, rightChild.data)
+		node.data = hasher(leftChild.data, rightChild.data)
 
 		// Grab the next parent that needs the hash updated.
 		node, err = node.getParent()
@@ -446,6 +573,85 @@ func hashToRoot(node *polNode) error {
 	return nil
 }
 
+// checkRootHashes checks that the hash of the root and all the nodes below it are
+// the hash of their children.
+func checkRootHashes(root *polNode, hasher Hasher) error {
+	if root.lNiece == nil || root.rNiece == nil {
+		return nil
+	}
+
+	calculated := hasher(root.lNiece.data, root.rNiece.data)
+	if calculated != root.data {
+		return fmt.Errorf("calculated %s for root but have %s",
+			hex.EncodeToString(calculated[:]), hex.EncodeToString(root.data[:]))
+	}
+
+	return checkNieceHashes(root.lNiece, root.rNiece, hasher)
+}
+
+// checkNieceHashes checks that the hash of each of the siblings are the hash of their
+// children. The children of a node are the nieces of its sibling.
+func checkNieceHashes(node, sibling *polNode, hasher Hasher) error {
+	if node.lNiece != nil && node.rNiece != nil {
+		calculated := hasher(node.lNiece.data, node.rNiece.data)
+		if calculated != sibling.data {
+			return fmt.Errorf("calculated %s from left %s, right %s but have %s",
+				hex.EncodeToString(calculated[:]),
+				hex.EncodeToString(node.lNiece.data[:]),
+				hex.EncodeToString(node.rNiece.data[:]),
+				hex.EncodeToString(sibling.data[:]))
+		}
+
+		err := checkNieceHashes(node.lNiece, node.rNiece, hasher)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sibling.lNiece != nil && sibling.rNiece != nil {
+		calculated := hasher(sibling.lNiece.data, sibling.rNiece.data)
+		if calculated != node.data {
+			return fmt.Errorf("calculated %s from left %s, right %s but have %s",
+				hex.EncodeToString(calculated[:]),
+				hex.EncodeToString(sibling.lNiece.data[:]),
+				hex.EncodeToString(sibling.rNiece.data[:]),
+				hex.EncodeToString(node.data[:]))
+		}
+
+		err := checkNieceHashes(sibling.lNiece, sibling.rNiece, hasher)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rehashRoot recalculates the hash of the root and all the nodes below it from their
+// children. Nodes that don't have both of their children cached keep their hash.
+func rehashRoot(root *polNode, hasher Hasher) {
+	if root.lNiece == nil || root.rNiece == nil {
+		return
+	}
+
+	rehashNieces(root.lNiece, root.rNiece, hasher)
+	root.data = hasher(root.lNiece.data, root.rNiece.data)
+}
+
+// rehashNieces recalculates the hashes of the siblings from their children. The children
+// of a node are the nieces of its sibling.
+func rehashNieces(node, sibling *polNode, hasher Hasher) {
+	if node.lNiece != nil && node.rNiece != nil {
+		rehashNieces(node.lNiece, node.rNiece, hasher)
+		sibling.data = hasher(node.lNiece.data, node.rNiece.data)
+	}
+
+	if sibling.lNiece != nil && sibling.rNiece != nil {
+		rehashNieces(sibling.lNiece, sibling.rNiece, hasher)
+		node.data = hasher(sibling.lNiece.data, sibling.rNiece.data)
+	}
+}
+
 // getCount returns the count of all the nieces below it and itself.
 func getCount(n *polNode) int64 {
 	if n == nil {
@@ -455,11 +661,11 @@ func getCount(n *polNode) int64 {
 }
 
 // calculateParentHash returns the parent hash of the passed in nodes.
-func calculateParentHash(nodePos uint64, node, sibling *polNode) Hash {
+func calculateParentHash(nodePos uint64, node, sibling *polNode, hasher Hasher) Hash {
 	if isLeftNiece(nodePos) {
-		return parentHash(node.data, sibling.data)
+		return hasher(node.data, sibling.data)
 	}
-	return parentHash(sibling.data, node.data)
+	return hasher(sibling.data, node.data)
 }
 
 type nodeAndPos struct {
@@ -467,7 +673,7 @@ type nodeAndPos struct {
 	pos  uint64
 }
 
-func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8) []nodeAndPos {
+func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8, hasher Hasher) []nodeAndPos {
 	for i := 0; i < len(polNodes); i++ {
 		// 1: Check that there's at least 2 elements in the slice left.
 		// 2: Check if the right sibling of the current element matches
@@ -486,7 +692,7 @@ func deTwinPolNode(polNodes []nodeAndPos, forestRows uint8) []nodeAndPos {
 			polNodes = append(polNodes[:i], polNodes[i+2:]...)
 
 			// Calculate and insert the parent in order.
-			parentNode := &polNode{data: parentHash(pn.node.data, sibNode.data)}
+			parentNode := &polNode{data: hasher(pn.node.data, sibNode.data)}
 			parentNode.lNiece = pn.node
 			parentNode.rNiece = sibNode
 			updateAunt(parentNode)
@@ -174,3 +174,93 @@ func TestReadPosition(t *testing.T) {
 		}
 	}
 }
+
+func TestGetHashesSorted(t *testing.T) {
+	t.Parallel()
+
+	full := NewAccumulator(true)
+	pruned := NewAccumulator(false)
+	leaves := makeTestLeaves(100, 0)
+	for i := range leaves {
+		leaves[i].Remember = i%7 == 0
+	}
+	for _, p := range []*Pollard{&full, &pruned} {
+		err := p.Modify(leaves, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := deleteHashes(&full, []Hash{leaves[14].Hash, leaves[50].Hash, leaves[98].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*Pollard{&full, &pruned} {
+		forestRows := treeRows(p.NumLeaves)
+		positions := make([]uint64, 0, maxPosition(forestRows))
+		for pos := uint64(0); pos < maxPosition(forestRows); pos++ {
+			if !inForest(pos, p.NumLeaves, forestRows) {
+				continue
+			}
+			positions = append(positions, pos)
+			// Duplicates are allowed.
+			if pos%9 == 0 {
+				positions = append(positions, pos)
+			}
+		}
+
+		hashes, err := p.GetHashesSorted(positions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, pos := range positions {
+			expected := p.GetHash(pos)
+			if hashes[i] != expected {
+				t.Fatalf("for pos %d, expected %s, got %s", pos, expected, hashes[i])
+			}
+		}
+	}
+
+	_, err = full.GetHashesSorted([]uint64{3, 2})
+	if err == nil {
+		t.Fatalf("expected an error for unsorted positions")
+	}
+	_, err = full.GetHashesSorted([]uint64{maxPosition(treeRows(full.NumLeaves))})
+	if err == nil {
+		t.Fatalf("expected an error for a position out of range")
+	}
+}
+
+func BenchmarkGetHashesSorted(b *testing.B) {
+	p := NewAccumulator(true)
+	err := p.Modify(makeTestLeaves(4096, 0), nil, Proof{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	positions := make([]uint64, 4096)
+	for i := range positions {
+		positions[i] = uint64(i)
+	}
+
+	b.Run("sorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := p.GetHashesSorted(positions)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per position", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, pos := range positions {
+				n, _, _, err := p.getNode(pos)
+				if err != nil || n == nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -1,8 +1,12 @@
 package utreexo
 
 import (
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"sort"
 
 	"golang.org/x/exp/slices"
@@ -47,6 +51,452 @@ func (p *Proof) String() string {
 	return s
 }
 
+// EmptyProof returns the canonical empty proof. It's the proof for a modification with
+// no deletions and it verifies against any roots when there are no hashes to verify.
+func EmptyProof() Proof {
+	return Proof{}
+}
+
+// maxProofPrealloc is the maximum amount of elements that are pre-allocated when reading
+// a proof. It prevents huge allocations from a malicious count.
+const maxProofPrealloc = 1 << 16
+
+// proofPrealloc returns the amount of elements to pre-allocate for the given count.
+func proofPrealloc(count uint64) uint64 {
+	if count > maxProofPrealloc {
+		return maxProofPrealloc
+	}
+	return count
+}
+
+// SerializeSize returns how many bytes it'd take to serialize the proof.
+func (p *Proof) SerializeSize() int {
+	// 8 byte target count + 8 byte targets + 8 byte proof count + 32 byte hashes.
+	return 8 + (len(p.Targets) * 8) + 8 + (len(p.Proof) * 32)
+}
+
+// MinNumLeaves returns the smallest numLeaves for which all the targets of the proof are
+// positions in the forest. A proof can't be for an accumulator with fewer leaves. The
+// targets may be on rows above the bottom as the leaves move up when their siblings
+// are deleted. 0 is returned for a proof without targets.
+func (p *Proof) MinNumLeaves() uint64 {
+	if len(p.Targets) == 0 {
+		return 0
+	}
+
+	maxTarget := p.Targets[0]
+	for _, target := range p.Targets[1:] {
+		if target > maxTarget {
+			maxTarget = target
+		}
+	}
+
+	// The rows of the forest decide which row each target is on. Try the smallest
+	// forests first as they have the smallest numLeaves.
+	for forestRows := uint8(0); forestRows < 64; forestRows++ {
+		if maxTarget >= maxPosition(forestRows) {
+			continue
+		}
+
+		// A forest with these rows has more than half of 1<<forestRows leaves.
+		minLeaves, maxLeaves := uint64(1), uint64(1)<<forestRows
+		if forestRows > 0 {
+			minLeaves = (maxLeaves >> 1) + 1
+		}
+
+		// A target at the offset on its row needs (offset+1)<<row leaves.
+		needed := minLeaves
+		for _, target := range p.Targets {
+			row := detectRow(target, forestRows)
+			offset := target - startPositionAtRow(row, forestRows)
+			if leaves := (offset + 1) << row; leaves > needed {
+				needed = leaves
+			}
+		}
+		if needed <= maxLeaves {
+			return needed
+		}
+	}
+
+	// None of the forests have all the targets.
+	return math.MaxUint64
+}
+
+// ProofDelta is the difference between two proofs. The targets and the proof hashes that
+// are in both proofs are not included so that a proof can be sent as a delta from a
+// proof the receiver already has.
+type ProofDelta struct {
+	// RemovedTargets are the indexes of the targets of the previous proof that are not
+	// in the new proof. They're in ascending order.
+	RemovedTargets []int
+
+	// AddedTargets are the targets that are not in the previous proof and
+	// AddedTargetIndexes are their indexes in the new proof. They're in ascending order
+	// of the indexes.
+	AddedTargets       []uint64
+	AddedTargetIndexes []int
+
+	// RemovedHashes are the indexes of the proof hashes of the previous proof that are
+	// not in the new proof. They're in ascending order.
+	RemovedHashes []int
+
+	// AddedHashes are the proof hashes that are not in the previous proof and
+	// AddedHashIndexes are their indexes in the new proof. They're in ascending order
+	// of the indexes.
+	AddedHashes      []Hash
+	AddedHashIndexes []int
+}
+
+// Delta returns the delta that turns prev into this proof with ApplyDelta.
+func (p *Proof) Delta(prev Proof) ProofDelta {
+	var d ProofDelta
+	d.RemovedTargets, d.AddedTargets, d.AddedTargetIndexes =
+		sliceDelta(prev.Targets, p.Targets)
+	d.RemovedHashes, d.AddedHashes, d.AddedHashIndexes =
+		sliceDelta(prev.Proof, p.Proof)
+
+	return d
+}
+
+// ApplyDelta returns the proof that the delta was made for when this proof is the
+// previous proof passed into Delta. The proof itself is not modified.
+func (p *Proof) ApplyDelta(d ProofDelta) (Proof, error) {
+	targets, err := applySliceDelta(p.Targets, d.RemovedTargets, d.AddedTargets,
+		d.AddedTargetIndexes)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ApplyDelta fail. Invalid target delta. %v", err)
+	}
+	hashes, err := applySliceDelta(p.Proof, d.RemovedHashes, d.AddedHashes,
+		d.AddedHashIndexes)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ApplyDelta fail. Invalid proof hash delta. %v", err)
+	}
+
+	return Proof{Targets: targets, Proof: hashes}, nil
+}
+
+// sliceDelta returns the indexes of the elements of prev that are removed and the
+// elements of next that are added along with their indexes in next. The elements that
+// are kept stay in the same order.
+func sliceDelta[E comparable](prev, next []E) ([]int, []E, []int) {
+	prevIdxs := make(map[E][]int, len(prev))
+	for i, e := range prev {
+		prevIdxs[e] = append(prevIdxs[e], i)
+	}
+
+	// Greedily keep the elements that come after the last kept element in prev.
+	kept := make([]bool, len(prev))
+	last := -1
+	var added []E
+	var addedIdxs []int
+	for i, e := range next {
+		idxs := prevIdxs[e]
+		for len(idxs) > 0 && idxs[0] <= last {
+			idxs = idxs[1:]
+		}
+		if len(idxs) == 0 {
+			added = append(added, e)
+			addedIdxs = append(addedIdxs, i)
+			continue
+		}
+
+		last = idxs[0]
+		prevIdxs[e] = idxs[1:]
+		kept[last] = true
+	}
+
+	var removed []int
+	for i := range prev {
+		if !kept[i] {
+			removed = append(removed, i)
+		}
+	}
+
+	return removed, added, addedIdxs
+}
+
+// applySliceDelta returns prev with the elements at the removed indexes taken out and the
+// added elements inserted at their indexes.
+func applySliceDelta[E any](prev []E, removed []int, added []E, addedIdxs []int) ([]E, error) {
+	if len(added) != len(addedIdxs) {
+		return nil, fmt.Errorf("have %d added elements but %d indexes",
+			len(added), len(addedIdxs))
+	}
+	for i, idx := range removed {
+		if idx < 0 || idx >= len(prev) || (i > 0 && idx <= removed[i-1]) {
+			return nil, fmt.Errorf("removed index %d is out of range or order", idx)
+		}
+	}
+
+	kept := make([]E, 0, len(prev)-len(removed))
+	for i, e := range prev {
+		if len(removed) > 0 && removed[0] == i {
+			removed = removed[1:]
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	size := len(kept) + len(added)
+	if size == 0 {
+		return nil, nil
+	}
+	result := make([]E, 0, size)
+	for i, idx := range addedIdxs {
+		// The added elements after this one need to fit after it.
+		if idx < len(result) || idx > size-len(added)+i {
+			return nil, fmt.Errorf("added index %d is out of range or order", idx)
+		}
+		for len(result) < idx {
+			result = append(result, kept[0])
+			kept = kept[1:]
+		}
+		result = append(result, added[i])
+	}
+	result = append(result, kept...)
+
+	return result, nil
+}
+
+// Endianness is the byte order used to serialize the integers in a proof.
+type Endianness uint8
+
+const (
+	// LittleEndian serializes the integers in little endian. It's the default.
+	LittleEndian Endianness = iota
+
+	// BigEndian serializes the integers in big endian.
+	BigEndian
+)
+
+// byteOrder returns the binary.ByteOrder for the endianness.
+func (e Endianness) byteOrder() binary.ByteOrder {
+	if e == BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Write writes the proof to the writer. The targets are written as an 8 byte count
+// followed by the 8 byte targets and the proof hashes are written as an 8 byte count
+// followed by the 32 byte hashes. Proofs with nil and empty slices are written the same.
+// The counts and the targets are written in little endian.
+func (p *Proof) Write(w io.Writer) (int, error) {
+	return p.WriteWithEndianness(w, LittleEndian)
+}
+
+// WriteWithEndianness is Write but the counts and the targets are written with the
+// given endianness.
+func (p *Proof) WriteWithEndianness(w io.Writer, endianness Endianness) (int, error) {
+	byteOrder := endianness.byteOrder()
+	totalBytes := 0
+
+	var buf [8]byte
+
+	// Write the targets.
+	byteOrder.PutUint64(buf[:], uint64(len(p.Targets)))
+	bytes, err := w.Write(buf[:])
+	if err != nil {
+		return totalBytes, err
+	}
+	totalBytes += bytes
+
+	for _, target := range p.Targets {
+		byteOrder.PutUint64(buf[:], target)
+		bytes, err = w.Write(buf[:])
+		if err != nil {
+			return totalBytes, err
+		}
+		totalBytes += bytes
+	}
+
+	// Write the proof hashes.
+	byteOrder.PutUint64(buf[:], uint64(len(p.Proof)))
+	bytes, err = w.Write(buf[:])
+	if err != nil {
+		return totalBytes, err
+	}
+	totalBytes += bytes
+
+	for _, hash := range p.Proof {
+		bytes, err = w.Write(hash[:])
+		if err != nil {
+			return totalBytes, err
+		}
+		totalBytes += bytes
+	}
+
+	return totalBytes, nil
+}
+
+// Read reads the proof from the reader into the proof variable. Empty targets and proof
+// hashes are read as nil so that an empty proof is always read as EmptyProof. The counts
+// and the targets are read in little endian.
+func (p *Proof) Read(r io.Reader) (int, error) {
+	return p.ReadWithEndianness(r, LittleEndian)
+}
+
+// ReadWithEndianness is Read but the counts and the targets are read with the given
+// endianness.
+func (p *Proof) ReadWithEndianness(r io.Reader, endianness Endianness) (int, error) {
+	byteOrder := endianness.byteOrder()
+	totalBytes := 0
+
+	var buf [8]byte
+
+	// Read the targets.
+	bytes, err := io.ReadFull(r, buf[:])
+	totalBytes += bytes
+	if err != nil {
+		return totalBytes, err
+	}
+	targetCount := byteOrder.Uint64(buf[:])
+
+	p.Targets = nil
+	if targetCount > 0 {
+		p.Targets = make([]uint64, 0, proofPrealloc(targetCount))
+	}
+	for i := uint64(0); i < targetCount; i++ {
+		bytes, err = io.ReadFull(r, buf[:])
+		totalBytes += bytes
+		if err != nil {
+			return totalBytes, err
+		}
+		p.Targets = append(p.Targets, byteOrder.Uint64(buf[:]))
+	}
+
+	// Read the proof hashes.
+	bytes, err = io.ReadFull(r, buf[:])
+	totalBytes += bytes
+	if err != nil {
+		return totalBytes, err
+	}
+	proofCount := byteOrder.Uint64(buf[:])
+
+	p.Proof = nil
+	if proofCount > 0 {
+		p.Proof = make([]Hash, 0, proofPrealloc(proofCount))
+	}
+	for i := uint64(0); i < proofCount; i++ {
+		var hash Hash
+		bytes, err = io.ReadFull(r, hash[:])
+		totalBytes += bytes
+		if err != nil {
+			return totalBytes, err
+		}
+		p.Proof = append(p.Proof, hash)
+	}
+
+	return totalBytes, nil
+}
+
+// ProofStreamWriter writes proofs to a stream one at a time. Each proof is written as an
+// 8 byte little endian length followed by the proof as serialized by Proof.Write so that
+// proofs can be appended to the stream without rewriting it.
+type ProofStreamWriter struct {
+	w io.Writer
+}
+
+// NewProofStreamWriter returns a ProofStreamWriter that writes to w.
+func NewProofStreamWriter(w io.Writer) *ProofStreamWriter {
+	return &ProofStreamWriter{w: w}
+}
+
+// Write writes the proof as the next record of the stream.
+func (s *ProofStreamWriter) Write(p Proof) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(p.SerializeSize()))
+	_, err := s.w.Write(buf[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Write(s.w)
+	return err
+}
+
+// ProofStreamReader reads the proofs written by a ProofStreamWriter one at a time.
+type ProofStreamReader struct {
+	r io.Reader
+}
+
+// NewProofStreamReader returns a ProofStreamReader that reads from r.
+func NewProofStreamReader(r io.Reader) *ProofStreamReader {
+	return &ProofStreamReader{r: r}
+}
+
+// Next returns the next proof of the stream. io.EOF is returned if there are no more
+// records and io.ErrUnexpectedEOF is returned if the last record is truncated.
+func (s *ProofStreamReader) Next() (Proof, error) {
+	var buf [8]byte
+	read, err := io.ReadFull(s.r, buf[:])
+	if err != nil {
+		if read == 0 && err == io.EOF {
+			return Proof{}, io.EOF
+		}
+		return Proof{}, fmt.Errorf("ProofStreamReader.Next fail. Truncated "+
+			"record length. %w", io.ErrUnexpectedEOF)
+	}
+	length := binary.LittleEndian.Uint64(buf[:])
+
+	// Don't read past the record even if the length is corrupted.
+	var p Proof
+	readBytes, err := p.Read(io.LimitReader(s.r, int64(length)))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Proof{}, fmt.Errorf("ProofStreamReader.Next fail. Truncated "+
+			"record of %d bytes. %w", length, err)
+	}
+	if uint64(readBytes) != length {
+		return Proof{}, fmt.Errorf("ProofStreamReader.Next fail. Record is %d "+
+			"bytes but the proof is %d bytes", length, readBytes)
+	}
+
+	return p, nil
+}
+
+// ProveErrorReason is the category of the failure passed to OnProveError.
+type ProveErrorReason uint8
+
+const (
+	// ProveErrorNotCached is when the hash being proven isn't cached in the pollard.
+	ProveErrorNotCached ProveErrorReason = iota
+
+	// ProveErrorDeleted is when the hash being proven was deleted within the
+	// tombstone window.
+	ProveErrorDeleted
+
+	// ProveErrorMissingProof is when a hash needed for the proof isn't cached.
+	ProveErrorMissingProof
+)
+
+// ProveErrorEvent describes why Prove failed. Hash is the hash that couldn't be proven
+// and is empty for ProveErrorMissingProof. Position is the position of the proof hash
+// that couldn't be read and is only set for ProveErrorMissingProof.
+type ProveErrorEvent struct {
+	Hash     Hash
+	Position uint64
+	Reason   ProveErrorReason
+}
+
+// proveError counts the cache miss and calls OnProveError with the event if it's set.
+// The error is returned as is.
+func (p *Pollard) proveError(event ProveErrorEvent, err error) error {
+	p.metrics.ProveCacheMisses++
+	if p.OnProveError != nil {
+		p.OnProveError(event)
+	}
+	return err
+}
+
+// Prove returns a proof for the given hashes against the current state of the
+// accumulator. The targets of the proof are in the same order as the hashes.
+//
+// NOTE Modify deletes before it adds so the targets are the positions before any of the
+// additions of the next Modify. A proof made before the Modify can be passed into the
+// Modify as is along with the additions of the same block.
 func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 	// No hashes to prove means that the proof is empty. An empty
 	// pollard also has an empty proof.
@@ -55,6 +505,7 @@ func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 	}
 	// A Pollard with 1 leaf has no proof and only 1 target.
 	if p.NumLeaves == 1 {
+		p.metrics.ProveCacheHits++
 		return Proof{Targets: []uint64{0}}, nil
 	}
 
@@ -65,8 +516,17 @@ func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 	for i, wanted := range hashes {
 		node, ok := p.NodeMap[wanted.mini()]
 		if !ok {
-			return proof, fmt.Errorf("Prove error: hash %s not found",
-				hex.EncodeToString(wanted[:]))
+			deletedAt, deleted := p.tombstones[wanted.mini()]
+			if deleted {
+				return proof, p.proveError(
+					ProveErrorEvent{Hash: wanted, Reason: ProveErrorDeleted},
+					fmt.Errorf("Prove error: hash %s: %w at block %d",
+						hex.EncodeToString(wanted[:]), ErrLeafDeleted, deletedAt))
+			}
+			return proof, p.proveError(
+				ProveErrorEvent{Hash: wanted, Reason: ProveErrorNotCached},
+				fmt.Errorf("Prove error: hash %s not found",
+					hex.EncodeToString(wanted[:])))
 		}
 		proof.Targets[i] = p.calculatePosition(node)
 	}
@@ -87,14 +547,298 @@ func (p *Pollard) Prove(hashes []Hash) (Proof, error) {
 	for i, proofPos := range proofPositions {
 		hash := p.getHash(proofPos)
 		if hash == empty {
-			return Proof{}, fmt.Errorf("Prove error: couldn't read position %d", proofPos)
+			return Proof{}, p.proveError(
+				ProveErrorEvent{Position: proofPos, Reason: ProveErrorMissingProof},
+				fmt.Errorf("Prove error: couldn't read position %d", proofPos))
 		}
 		proof.Proof[i] = hash
 	}
+	p.metrics.ProveCacheHits++
+
+	if p.RecordProofHashes {
+		if p.proofHashFreq == nil {
+			p.proofHashFreq = make(map[Hash]int)
+		}
+		for _, hash := range proof.Proof {
+			p.proofHashFreq[hash]++
+		}
+	}
 
 	return proof, nil
 }
 
+// ProveForModify returns a proof for the dels in the exact form that Modify expects for
+// deleting them. It's Prove but it also returns ErrDuplicateDeletion for the dels that
+// Modify would reject. The proof can be passed into Modify along with the additions of
+// the same block.
+func (p *Pollard) ProveForModify(dels []Hash) (Proof, error) {
+	err := checkDuplicateDels(dels, nil)
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveForModify fail. %w", err)
+	}
+
+	return p.Prove(dels)
+}
+
+// ProveRange returns a proof for all the cached leaves with positions in the range of
+// [startPos, endPos) along with the hashes of those leaves. The hashes are in the order
+// of their positions.
+func (p *Pollard) ProveRange(startPos, endPos uint64) (Proof, []Hash, error) {
+	if startPos > endPos {
+		return Proof{}, nil, fmt.Errorf("ProveRange fail. Start position %d is "+
+			"greater than end position %d", startPos, endPos)
+	}
+
+	maxPos := maxPosition(treeRows(p.NumLeaves))
+	if endPos > maxPos {
+		endPos = maxPos
+	}
+
+	var hashes []Hash
+	for pos := startPos; pos < endPos; pos++ {
+		// Positions that don't exist in the forest are skipped.
+		node, _, _, err := p.getNode(pos)
+		if err != nil || node == nil {
+			continue
+		}
+
+		// Only the nodes in the map are leaves.
+		mapNode, found := p.NodeMap[node.data.mini()]
+		if !found || mapNode != node {
+			continue
+		}
+
+		hashes = append(hashes, node.data)
+	}
+
+	proof, err := p.Prove(hashes)
+	if err != nil {
+		return Proof{}, nil, err
+	}
+
+	return proof, hashes, nil
+}
+
+// ProveByIndex returns the proof for the leaf that was added at the given add index.
+// The add index of a leaf is the number of leaves that were added before it and it
+// doesn't change when other leaves are deleted. The leaf must have been added while
+// TrackAddIndex was set.
+func (p *Pollard) ProveByIndex(addIndex uint64) (Proof, error) {
+	hash, found := p.addIndexes[addIndex]
+	if !found {
+		return Proof{}, fmt.Errorf("ProveByIndex error: no tracked leaf at add index %d",
+			addIndex)
+	}
+
+	return p.Prove([]Hash{hash})
+}
+
+// BlockRange is the range of add indexes of the leaves that were added in a block. Start
+// is the number of leaves before the block and End is the number of leaves after the
+// block so the range includes Start but not End.
+type BlockRange struct {
+	Start, End uint64
+}
+
+// AddBlockOf returns the index of the block range that contains the add index of the
+// cached leaf. The leaf must have been added while TrackAddIndex was set. An error is
+// returned if none of the block ranges contain the add index.
+func (p *Pollard) AddBlockOf(h Hash, blockRanges []BlockRange) (int, error) {
+	node, found := p.NodeMap[h.mini()]
+	if !found || node.data != h {
+		return -1, fmt.Errorf("AddBlockOf error: hash %s not found", h)
+	}
+
+	addIndex, tracked := p.getAddIndex(h)
+	if !tracked {
+		return -1, fmt.Errorf("AddBlockOf error: add index of hash %s isn't tracked", h)
+	}
+
+	for i, blockRange := range blockRanges {
+		if addIndex >= blockRange.Start && addIndex < blockRange.End {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("AddBlockOf error: no block range contains add index %d "+
+		"of hash %s", addIndex, h)
+}
+
+// SiblingHash returns the hash and the position of the sibling of the cached leaf. An
+// error is returned if the leaf isn't cached, if the leaf is a root, or if the sibling
+// isn't cached.
+func (p *Pollard) SiblingHash(h Hash) (Hash, uint64, error) {
+	node, found := p.NodeMap[h.mini()]
+	if !found {
+		return empty, 0, fmt.Errorf("SiblingHash error: hash %s not found", h)
+	}
+
+	if node.aunt == nil {
+		return empty, 0, fmt.Errorf("SiblingHash error: hash %s is a root and "+
+			"doesn't have a sibling", h)
+	}
+	sib, err := node.getSibling()
+	if err != nil {
+		return empty, 0, err
+	}
+	if sib == nil {
+		return empty, 0, fmt.Errorf("SiblingHash error: sibling of hash %s "+
+			"is not cached", h)
+	}
+
+	return sib.data, sibling(p.calculatePosition(node)), nil
+}
+
+// ProveAfter returns the proof for the futureTarget as it would be after the pending
+// modify is applied. The modify is applied to a copy of the pollard so this pollard is
+// not modified. The futureTarget may be one of the pending additions.
+func (p *Pollard) ProveAfter(futureTarget Hash, pendingAdds []Leaf, pendingDels []Hash,
+	pendingTargets []uint64) (Proof, error) {
+
+	// Make sure the future target is cached if it's being added.
+	adds := make([]Leaf, len(pendingAdds))
+	copy(adds, pendingAdds)
+	for i := range adds {
+		if adds[i].Hash == futureTarget {
+			adds[i].Remember = true
+		}
+	}
+
+	c := p.detachedClone()
+
+	err := c.Modify(adds, pendingDels, Proof{Targets: pendingTargets})
+	if err != nil {
+		return Proof{}, fmt.Errorf("ProveAfter fail. %w", err)
+	}
+
+	return c.Prove([]Hash{futureTarget})
+}
+
+// MerklePath returns the sibling hashes needed to hash the leaf up to its root along
+// with the position of the leaf. The hashes are ordered from the leaf to the root.
+func (p *Pollard) MerklePath(h Hash) ([]Hash, uint64, error) {
+	node, found := p.NodeMap[h.mini()]
+	if !found {
+		return nil, 0, fmt.Errorf("MerklePath error: hash %s not found",
+			hex.EncodeToString(h[:]))
+	}
+	pos := p.calculatePosition(node)
+
+	positions := proofPosition(pos, p.NumLeaves, treeRows(p.NumLeaves))
+	path := make([]Hash, len(positions))
+	for i, proofPos := range positions {
+		path[i] = p.getHash(proofPos)
+		if path[i] == empty {
+			return nil, 0, fmt.Errorf("MerklePath error: couldn't read position %d",
+				proofPos)
+		}
+	}
+
+	return path, pos, nil
+}
+
+// LeafDepth returns the number of rows between the leaf and its root. It's the number
+// of hashes in a proof for just that leaf and it's the same as the length of the path
+// returned by MerklePath.
+func (p *Pollard) LeafDepth(h Hash) (uint8, error) {
+	node, found := p.NodeMap[h.mini()]
+	if !found {
+		return 0, fmt.Errorf("LeafDepth error: hash %s not found",
+			hex.EncodeToString(h[:]))
+	}
+	pos := p.calculatePosition(node)
+
+	forestRows := treeRows(p.NumLeaves)
+	rootPos, err := getRootPosition(pos, p.NumLeaves, forestRows)
+	if err != nil {
+		return 0, fmt.Errorf("LeafDepth error: %v", err)
+	}
+
+	return detectRow(rootPos, forestRows) - detectRow(pos, forestRows), nil
+}
+
+// VerifyInclusion verifies that the leaf at the given position is included in the roots
+// by hashing the leaf with each of the sibling hashes in the path. The path is ordered
+// from the leaf to the root.
+func VerifyInclusion(leaf Hash, pos uint64, path []Hash, roots []Hash, numLeaves uint64) error {
+	forestRows := treeRows(numLeaves)
+	if pos >= maxPosition(forestRows) {
+		return fmt.Errorf("VerifyInclusion fail. Position %d doesn't exist "+
+			"in a forest of %d leaves", pos, numLeaves)
+	}
+
+	hash := leaf
+	for _, sibHash := range path {
+		if isRootPosition(pos, numLeaves) {
+			return fmt.Errorf("VerifyInclusion fail. Reached the root at %d "+
+				"with %d hashes left in the path", pos, len(path))
+		}
+
+		if isLeftNiece(pos) {
+			hash = parentHash(hash, sibHash)
+		} else {
+			hash = parentHash(sibHash, hash)
+		}
+		pos = parent(pos, forestRows)
+	}
+
+	for i, rootPos := range RootPositions(numLeaves, forestRows) {
+		if rootPos != pos {
+			continue
+		}
+		if i >= len(roots) || roots[i] != hash {
+			return fmt.Errorf("VerifyInclusion fail. Calculated root %s at "+
+				"position %d doesn't match", hex.EncodeToString(hash[:]), pos)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("VerifyInclusion fail. Position %d after hashing the path "+
+		"is not a root", pos)
+}
+
+// ProofSharingStats returns the number of hashes in the batched proof for the given
+// hashes and the sum of the number of hashes in the individual proofs for each of the
+// hashes. The difference between the two is the savings from batching the proofs.
+func (p *Pollard) ProofSharingStats(dels []Hash) (batchedHashes, sumIndividualHashes int, err error) {
+	batched, err := p.Prove(dels)
+	if err != nil {
+		return 0, 0, err
+	}
+	batchedHashes = len(batched.Proof)
+
+	for _, del := range dels {
+		individual, err := p.Prove([]Hash{del})
+		if err != nil {
+			return 0, 0, err
+		}
+		sumIndividualHashes += len(individual.Proof)
+	}
+
+	return batchedHashes, sumIndividualHashes, nil
+}
+
+// ProofsDisjoint returns true if none of the targets of proof a are targets of proof b.
+// Empty proofs are disjoint with all proofs.
+func ProofsDisjoint(a, b Proof) bool {
+	if len(a.Targets) > len(b.Targets) {
+		a, b = b, a
+	}
+
+	targets := make(map[uint64]struct{}, len(a.Targets))
+	for _, target := range a.Targets {
+		targets[target] = struct{}{}
+	}
+	for _, target := range b.Targets {
+		if _, found := targets[target]; found {
+			return false
+		}
+	}
+
+	return true
+}
+
 // hashAndPos provides a type to manipulate both the corresponding positions
 // and the hashes at the same time.
 type hashAndPos struct {
@@ -122,6 +866,27 @@ func toHashAndPos(origTargets []uint64, origHashes []Hash) hashAndPos {
 	return hnp
 }
 
+// SortTargetsWithHashes returns copies of the targets and the hashes sorted by the
+// targets in ascending order, which is the order Verify and Modify expect. The hashes
+// stay paired with their targets. Returns an error if the lengths of the targets and
+// the hashes differ or if there are duplicate targets.
+func SortTargetsWithHashes(targets []uint64, hashes []Hash) ([]uint64, []Hash, error) {
+	if len(targets) != len(hashes) {
+		return nil, nil, fmt.Errorf("SortTargetsWithHashes fail. Was given %d targets "+
+			"but got %d hashes for those targets", len(targets), len(hashes))
+	}
+
+	hnp := toHashAndPos(targets, hashes)
+	for i := 1; i < len(hnp.positions); i++ {
+		if hnp.positions[i] == hnp.positions[i-1] {
+			return nil, nil, fmt.Errorf("SortTargetsWithHashes fail. "+
+				"Target %d is duplicated", hnp.positions[i])
+		}
+	}
+
+	return hnp.positions, hnp.hashes, nil
+}
+
 // Len returns the length of the slices.
 // Implements the sort.Sort interface.
 func (hnp hashAndPos) Len() int {
@@ -291,147 +1056,624 @@ func subtractSortedHashAndPos[E any](a hashAndPos, b []E, cmp func(uint64, E) in
 		}
 	}
 
-	return a
+	return a
+}
+
+// removeHashesFromHashAndPos removes the hashes and the corresponding positions from slice1.
+func removeHashesFromHashAndPos[E any](slice1 hashAndPos, slice2 []E, getHash func(elem E) Hash) hashAndPos {
+	allKeys := make(map[Hash]bool, len(slice2))
+	for _, elem := range slice2 {
+		allKeys[getHash(elem)] = true
+	}
+
+	retSlice := hashAndPos{}
+	retSlice.positions = make([]uint64, 0, slice1.Len())
+	retSlice.hashes = make([]Hash, 0, slice1.Len())
+	for i := 0; i < slice1.Len(); i++ {
+		elemHash := slice1.hashes[i]
+		elemPos := slice1.positions[i]
+
+		if _, val := allKeys[elemHash]; !val {
+			retSlice.Append(elemPos, elemHash)
+		}
+	}
+
+	return retSlice
+}
+
+// getHashAndPosSubset allocates and returns a new hashAndPos that is the
+// intersection of a and b.
+//
+// NOTE: Both inputs MUST be sorted.
+func getHashAndPosSubset(a hashAndPos, b []uint64) hashAndPos {
+	c := hashAndPos{make([]uint64, 0, len(b)), make([]Hash, 0, len(b))}
+
+	bIdx := 0
+	for i := 0; i < a.Len(); i++ {
+		if bIdx >= len(b) {
+			break
+		}
+		// If they're equal, append element to c.
+		if a.positions[i] == b[bIdx] {
+			c.Append(a.positions[i], a.hashes[i])
+			// Move both indexes forward if they're the same.
+			bIdx++
+		} else if a.positions[i] > b[bIdx] {
+			bIdx++
+			i--
+		}
+	}
+
+	return c
+}
+
+// getHashAndPosHashSubset allocates and returns a new hashAndPos that is the
+// intersection of a and b based on the hashes in b.
+func getHashAndPosHashSubset(a hashAndPos, b []Hash) hashAndPos {
+	allKeys := make(map[Hash]bool, len(b))
+	for _, elem := range b {
+		allKeys[elem] = true
+	}
+
+	c := hashAndPos{make([]uint64, 0, len(b)), make([]Hash, 0, len(b))}
+	for i := 0; i < a.Len(); i++ {
+		elem := a.hashes[i]
+
+		if _, val := allKeys[elem]; val {
+			c.Append(a.positions[i], a.hashes[i])
+		}
+	}
+
+	return c
+}
+
+func deTwinHashAndPos(hnp hashAndPos, forestRows uint8) hashAndPos {
+	for i := 0; i < hnp.Len(); i++ {
+		// 1: Check that there's at least 2 elements in the slice left.
+		// 2: Check if the right sibling of the current element matches
+		//    up with the next element in the slice.
+		if i+1 < hnp.Len() && rightSib(hnp.positions[i]) == hnp.positions[i+1] {
+			// Grab the position of the del.
+			nodePos, nodeHash := hnp.positions[i], hnp.hashes[i]
+			sibHash := hnp.hashes[i+1]
+
+			// Remove both of the detwined nodes from the slice.
+			hnp.Delete(i)
+			hnp.Delete(i)
+
+			// Calculate and insert the parent in order.
+			position := parent(nodePos, forestRows)
+			hnp = mergeSortedHashAndPos(
+				hnp,
+				hashAndPos{[]uint64{position}, []Hash{parentHash(nodeHash, sibHash)}},
+			)
+
+			// Decrement one since the next element we should
+			// look at is at the same index because the slice decreased
+			// in length by one.
+			i--
+		}
+	}
+
+	return hnp
+}
+
+// ErrWorkLimitExceeded is returned when verifying a proof would take more hash operations
+// than allowed.
+var ErrWorkLimitExceeded = errors.New("verification work limit exceeded")
+
+// verifyHashOps returns the number of hashes that need to be calculated to verify the
+// given targets.
+func verifyHashOps(targets []uint64, numLeaves uint64) int {
+	sortedTargets := copySortedFunc(targets, uint64Less)
+	_, nextTargets := proofPositions(sortedTargets, numLeaves, treeRows(numLeaves))
+	return len(nextTargets)
+}
+
+// VerifyLimited is Verify but returns ErrWorkLimitExceeded without verifying if verifying
+// the proof would take more than maxHashOps hash operations. The number of hash
+// operations is calculated from the targets of the proof before any hashing is done.
+func (p *Pollard) VerifyLimited(delHashes []Hash, proof Proof, maxHashOps int) error {
+	ops := verifyHashOps(proof.Targets, p.NumLeaves)
+	if ops > maxHashOps {
+		return fmt.Errorf("Pollard.VerifyLimited fail. %w: proof needs %d hash "+
+			"operations but the limit is %d", ErrWorkLimitExceeded, ops, maxHashOps)
+	}
+
+	return p.Verify(delHashes, proof, false)
+}
+
+// ErrZeroProofHash is returned when a proof has an empty hash as one of its proof
+// hashes. The siblings of the nodes being proven are never empty so such a proof is
+// always malformed.
+var ErrZeroProofHash = errors.New("proof has a zero hash")
+
+// checkProofHashes returns ErrZeroProofHash if any of the proof hashes are empty.
+func checkProofHashes(proof Proof) error {
+	for i, hash := range proof.Proof {
+		if hash == empty {
+			return fmt.Errorf("%w at index %d", ErrZeroProofHash, i)
+		}
+	}
+
+	return nil
+}
+
+// ErrTargetOutOfRange is returned when a target of a proof is not a position in the
+// forest of the accumulator.
+var ErrTargetOutOfRange = errors.New("target out of range")
+
+// checkTargetsInForest returns ErrTargetOutOfRange if any of the targets are not in the
+// forest of numLeaves. All the ancestors of a target in the forest are also in the
+// forest up to its root so only the targets need to be checked.
+func checkTargetsInForest(targets []uint64, numLeaves uint64) error {
+	forestRows := treeRows(numLeaves)
+	for _, target := range targets {
+		if !inForest(target, numLeaves, forestRows) {
+			return fmt.Errorf("%w: target %d isn't in the forest of %d leaves",
+				ErrTargetOutOfRange, target, numLeaves)
+		}
+	}
+
+	return nil
+}
+
+// Verify calculates the root hashes from the passed in proof and delHashes and
+// compares it against the current roots in the pollard. Proofs with empty proof
+// hashes are rejected with ErrZeroProofHash and proofs with targets outside of the
+// forest are rejected with ErrTargetOutOfRange before any hashing is done.
+func (p *Pollard) Verify(delHashes []Hash, proof Proof, remember bool) error {
+	if len(delHashes) == 0 {
+		return nil
+	}
+
+	err := checkProofHashes(proof)
+	if err != nil {
+		return fmt.Errorf("Pollard.Verify fail. %w", err)
+	}
+	err = checkTargetsInForest(proof.Targets, p.NumLeaves)
+	if err != nil {
+		return fmt.Errorf("Pollard.Verify fail. %w", err)
+	}
+
+	if len(delHashes) != len(proof.Targets) {
+		return fmt.Errorf("Pollard.Verify fail. Was given %d targets but got %d hashes",
+			len(proof.Targets), len(delHashes))
+	}
+
+	_, rootCandidates := calculateHashesWithHasher(p.NumLeaves, delHashes, proof, p.hasher())
+	if len(rootCandidates) == 0 {
+		return fmt.Errorf("Pollard.Verify fail. No roots calculated "+
+			"but have %d deletions", len(delHashes))
+	}
+
+	rootMatches := 0
+	for i := range p.Roots {
+		if len(rootCandidates) > rootMatches &&
+			p.Roots[len(p.Roots)-(i+1)].data == rootCandidates[rootMatches] {
+			rootMatches++
+		}
+	}
+	// Error out if all the rootCandidates do not have a corresponding
+	// polnode with the same hash.
+	if len(rootCandidates) != rootMatches {
+		rootHashes := make([]Hash, len(p.Roots))
+		for i := range rootHashes {
+			rootHashes[i] = p.Roots[i].data
+		}
+		// The proof is invalid because some root candidates were not
+		// included in `roots`.
+		err := fmt.Errorf("Pollard.Verify fail. Have %d roots but only "+
+			"matched %d roots.\nRootcandidates:\n%v\nRoots:\n%v",
+			len(rootCandidates), rootMatches,
+			printHashes(rootCandidates), printHashes(rootHashes))
+		return err
+	}
+
+	return nil
+}
+
+// VerifyOne verifies a proof for exactly one target. It hashes the target up to its
+// root directly which is faster than Verify for a single target. The result is the
+// same as Verify with remember set to false.
+func (p *Pollard) VerifyOne(delHash Hash, proof Proof) error {
+	if len(proof.Targets) != 1 {
+		return fmt.Errorf("Pollard.VerifyOne fail. Expected 1 target but got %d",
+			len(proof.Targets))
+	}
+	err := checkProofHashes(proof)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyOne fail. %w", err)
+	}
+
+	err = checkTargetsInForest(proof.Targets, p.NumLeaves)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyOne fail. %w", err)
+	}
+
+	pos := proof.Targets[0]
+	totalRows := treeRows(p.NumLeaves)
+
+	hasher := p.hasher()
+	hash := delHash
+	proofIdx := 0
+	row := detectRow(pos, totalRows)
+	for ; !isRootPositionOnRow(pos, p.NumLeaves, row); row++ {
+		if row >= totalRows || proofIdx >= len(proof.Proof) {
+			return fmt.Errorf("Pollard.VerifyOne fail. Ran out of proof hashes "+
+				"at position %d", pos)
+		}
+
+		hash = getNextHash(pos, hash, proof.Proof[proofIdx], hasher)
+		pos = parent(pos, totalRows)
+		proofIdx++
+	}
+
+	for _, root := range p.Roots {
+		if root.data == hash {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Pollard.VerifyOne fail. Calculated root %s doesn't match "+
+		"any of the roots", hash)
+}
+
+// VerifyScratch holds the buffers used by VerifyInto. The buffers are reused between
+// calls so that verifying doesn't allocate once they've grown big enough. The zero
+// value is ready to use. A VerifyScratch must not be used concurrently.
+type VerifyScratch struct {
+	toProve    hashAndPos
+	nextProves hashAndPos
+	roots      []Hash
+}
+
+// VerifyInto is Verify with remember set to false but it uses the buffers in the
+// scratch instead of allocating new ones. The result is the same as Verify. The scratch
+// may be nil in which case new buffers are allocated for the call.
+func (p *Pollard) VerifyInto(delHashes []Hash, proof Proof, scratch *VerifyScratch) error {
+	if len(delHashes) == 0 {
+		return nil
+	}
+	if scratch == nil {
+		scratch = &VerifyScratch{}
+	}
+
+	if len(delHashes) != len(proof.Targets) {
+		return fmt.Errorf("Pollard.VerifyInto fail. Was given %d targets but got %d hashes",
+			len(proof.Targets), len(delHashes))
+	}
+	err := checkProofHashes(proof)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyInto fail. %w", err)
+	}
+	err = checkTargetsInForest(proof.Targets, p.NumLeaves)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyInto fail. %w", err)
+	}
+
+	scratch.toProve.positions = append(scratch.toProve.positions[:0], proof.Targets...)
+	scratch.toProve.hashes = append(scratch.toProve.hashes[:0], delHashes...)
+	if !sort.IsSorted(&scratch.toProve) {
+		sort.Sort(&scratch.toProve)
+	}
+	scratch.nextProves.Reset()
+
+	rootCandidates := calculateRoots(p.NumLeaves, proof, p.hasher(),
+		&scratch.toProve, &scratch.nextProves, scratch.roots[:0])
+	scratch.roots = rootCandidates
+	if len(rootCandidates) == 0 {
+		return fmt.Errorf("Pollard.VerifyInto fail. No roots calculated "+
+			"but have %d deletions", len(delHashes))
+	}
+
+	rootMatches := 0
+	for i := range p.Roots {
+		if len(rootCandidates) > rootMatches &&
+			p.Roots[len(p.Roots)-(i+1)].data == rootCandidates[rootMatches] {
+			rootMatches++
+		}
+	}
+	if len(rootCandidates) != rootMatches {
+		return fmt.Errorf("Pollard.VerifyInto fail. Have %d roots but only "+
+			"matched %d roots", len(rootCandidates), rootMatches)
+	}
+
+	return nil
+}
+
+// VerifyUnsorted verifies the proof after stably sorting the targets of the proof along
+// with their corresponding delHashes. The result is the same as verifying the sorted
+// targets and hashes with Verify. It's useful for proofs from implementations that
+// don't sort the targets.
+//
+// NOTE Only the targets and the delHashes are sorted. The proof hashes must still be
+// in the order of their positions.
+func (p *Pollard) VerifyUnsorted(delHashes []Hash, proof Proof) error {
+	if len(delHashes) != len(proof.Targets) {
+		return fmt.Errorf("Pollard.VerifyUnsorted fail. Was given %d targets "+
+			"but got %d hashes", len(proof.Targets), len(delHashes))
+	}
+
+	hnp := hashAndPos{
+		positions: make([]uint64, len(proof.Targets)),
+		hashes:    make([]Hash, len(delHashes)),
+	}
+	copy(hnp.positions, proof.Targets)
+	copy(hnp.hashes, delHashes)
+	sort.Stable(hnp)
+
+	sorted := Proof{Targets: hnp.positions, Proof: proof.Proof}
+	return p.Verify(hnp.hashes, sorted, false)
+}
+
+// VerifyItem is a proof along with the hashes it proves.
+type VerifyItem struct {
+	Dels  []Hash
+	Proof Proof
+}
+
+// VerifyAllStopOnFirst verifies each of the items in order with Verify and stops at the
+// first one that fails. The index of the failed item is returned along with its error.
+// The index is -1 if all of the items are valid.
+func (p *Pollard) VerifyAllStopOnFirst(items []VerifyItem) (int, error) {
+	for i, item := range items {
+		err := p.Verify(item.Dels, item.Proof, false)
+		if err != nil {
+			return i, fmt.Errorf("VerifyAllStopOnFirst fail at item %d. %w", i, err)
+		}
+	}
+
+	return -1, nil
+}
+
+// VerifyAndMap is Verify with remember set to false but it also returns the target
+// positions of the proof mapped to their delHashes. The map is nil if the proof is
+// invalid.
+func (p *Pollard) VerifyAndMap(delHashes []Hash, proof Proof) (map[uint64]Hash, error) {
+	err := p.Verify(delHashes, proof, false)
+	if err != nil {
+		return nil, err
+	}
+
+	targetHashes := make(map[uint64]Hash, len(proof.Targets))
+	for i, target := range proof.Targets {
+		targetHashes[target] = delHashes[i]
+	}
+
+	return targetHashes, nil
 }
 
-// removeHashesFromHashAndPos removes the hashes and the corresponding positions from slice1.
-func removeHashesFromHashAndPos[E any](slice1 hashAndPos, slice2 []E, getHash func(elem E) Hash) hashAndPos {
-	allKeys := make(map[Hash]bool, len(slice2))
-	for _, elem := range slice2 {
-		allKeys[getHash(elem)] = true
+// VerifyRangeProof verifies a proof for the deletions of a range of blocks against the
+// roots and the numLeaves of the accumulator at the start of the range. The targets of
+// the proof are the positions as they were at the start of the range. The proof is
+// verified with the hasher of the pollard and the pollard isn't modified.
+func (p *Pollard) VerifyRangeProof(dels []Hash, proof Proof, startRoots []Hash, startNumLeaves uint64) error {
+	if startNumLeaves > p.NumLeaves {
+		return fmt.Errorf("VerifyRangeProof fail. The range starts at %d leaves "+
+			"but the pollard only has %d leaves", startNumLeaves, p.NumLeaves)
 	}
 
-	retSlice := hashAndPos{}
-	retSlice.positions = make([]uint64, 0, slice1.Len())
-	retSlice.hashes = make([]Hash, 0, slice1.Len())
-	for i := 0; i < slice1.Len(); i++ {
-		elemHash := slice1.hashes[i]
-		elemPos := slice1.positions[i]
-
-		if _, val := allKeys[elemHash]; !val {
-			retSlice.Append(elemPos, elemHash)
-		}
+	stump := Stump{Roots: startRoots, NumLeaves: startNumLeaves}
+	_, err := VerifyWithHasher(stump, dels, proof, p.hasher())
+	if err != nil {
+		return fmt.Errorf("VerifyRangeProof fail. %w", err)
 	}
 
-	return retSlice
+	return nil
 }
 
-// getHashAndPosSubset allocates and returns a new hashAndPos that is the
-// intersection of a and b.
-//
-// NOTE: Both inputs MUST be sorted.
-func getHashAndPosSubset(a hashAndPos, b []uint64) hashAndPos {
-	c := hashAndPos{make([]uint64, 0, len(b)), make([]Hash, 0, len(b))}
+// VerifyBlock verifies that the block is internally consistent. Each of the dels must
+// either be one of the adds of the same block or be proven by the proof. The proof is
+// only for the dels that are not spent within the block and its targets are in the same
+// order as those dels. The pollard isn't modified.
+func (p *Pollard) VerifyBlock(adds []Leaf, dels []Hash, proof Proof) error {
+	err := checkDuplicateDels(dels, nil)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyBlock fail. %w", err)
+	}
 
-	bIdx := 0
-	for i := 0; i < a.Len(); i++ {
-		if bIdx >= len(b) {
-			break
-		}
-		// If they're equal, append element to c.
-		if a.positions[i] == b[bIdx] {
-			c.Append(a.positions[i], a.hashes[i])
-			// Move both indexes forward if they're the same.
-			bIdx++
-		} else if a.positions[i] > b[bIdx] {
-			bIdx++
-			i--
+	added := make(map[Hash]int, len(adds))
+	for idx, add := range adds {
+		if foundIdx, found := added[add.Hash]; found {
+			return fmt.Errorf("Pollard.VerifyBlock fail. %w: hash %s added "+
+				"at idx %d and %d", ErrHashCollision, add.Hash, foundIdx, idx)
 		}
+		added[add.Hash] = idx
 	}
 
-	return c
-}
-
-// getHashAndPosHashSubset allocates and returns a new hashAndPos that is the
-// intersection of a and b based on the hashes in b.
-func getHashAndPosHashSubset(a hashAndPos, b []Hash) hashAndPos {
-	allKeys := make(map[Hash]bool, len(b))
-	for _, elem := range b {
-		allKeys[elem] = true
+	// The dels that aren't spends of this block's adds must be older leaves.
+	older := make([]Hash, 0, len(dels))
+	for _, del := range dels {
+		if _, found := added[del]; !found {
+			older = append(older, del)
+		}
 	}
 
-	c := hashAndPos{make([]uint64, 0, len(b)), make([]Hash, 0, len(b))}
-	for i := 0; i < a.Len(); i++ {
-		elem := a.hashes[i]
+	if len(older) != len(proof.Targets) {
+		return fmt.Errorf("Pollard.VerifyBlock fail. Have %d deletions of older "+
+			"leaves but the proof has %d targets", len(older), len(proof.Targets))
+	}
 
-		if _, val := allKeys[elem]; val {
-			c.Append(a.positions[i], a.hashes[i])
-		}
+	err = p.Verify(older, proof, false)
+	if err != nil {
+		return fmt.Errorf("Pollard.VerifyBlock fail. %w", err)
 	}
 
-	return c
+	return nil
 }
 
-func deTwinHashAndPos(hnp hashAndPos, forestRows uint8) hashAndPos {
-	for i := 0; i < hnp.Len(); i++ {
-		// 1: Check that there's at least 2 elements in the slice left.
-		// 2: Check if the right sibling of the current element matches
-		//    up with the next element in the slice.
-		if i+1 < hnp.Len() && rightSib(hnp.positions[i]) == hnp.positions[i+1] {
-			// Grab the position of the del.
-			nodePos, nodeHash := hnp.positions[i], hnp.hashes[i]
-			sibHash := hnp.hashes[i+1]
+// VerifyPartialTargets verifies the proof against the given roots for only the targets
+// that have their hashes in knownHashes. knownHashes maps the target positions to their
+// hashes. The targets with unknown hashes are skipped as long as the known targets can
+// be verified without them. An error is returned if the hash of a skipped target, or a
+// hash calculated from it, is needed to verify the known targets.
+func VerifyPartialTargets(knownHashes map[uint64]Hash, proof Proof, roots []Hash,
+	numLeaves uint64) error {
 
-			// Remove both of the detwined nodes from the slice.
-			hnp.Delete(i)
-			hnp.Delete(i)
+	if len(knownHashes) == 0 {
+		return fmt.Errorf("VerifyPartialTargets fail. No known target hashes")
+	}
 
-			// Calculate and insert the parent in order.
-			position := parent(nodePos, forestRows)
-			hnp = mergeSortedHashAndPos(
-				hnp,
-				hashAndPos{[]uint64{position}, []Hash{parentHash(nodeHash, sibHash)}},
-			)
+	totalRows := treeRows(numLeaves)
+	targets := copySortedFunc(proof.Targets, uint64Less)
+	allProofPos, _ := proofPositions(targets, numLeaves, totalRows)
+	if len(allProofPos) != len(proof.Proof) {
+		return fmt.Errorf("VerifyPartialTargets fail. Expected %d proof hashes "+
+			"for %d targets but got %d", len(allProofPos), len(targets), len(proof.Proof))
+	}
 
-			// Decrement one since the next element we should
-			// look at is at the same index because the slice decreased
-			// in length by one.
-			i--
+	// All the hashes we have are the proof hashes and the known target hashes.
+	available := make(map[uint64]Hash, len(allProofPos)+len(knownHashes))
+	for i, pos := range allProofPos {
+		available[pos] = proof.Proof[i]
+	}
+
+	knownTargets := make([]uint64, 0, len(knownHashes))
+	for _, target := range targets {
+		hash, found := knownHashes[target]
+		if !found {
+			continue
 		}
+		available[target] = hash
+		knownTargets = append(knownTargets, target)
+	}
+	if len(knownTargets) != len(knownHashes) {
+		return fmt.Errorf("VerifyPartialTargets fail. Have %d known hashes but "+
+			"only %d of them are targets of the proof", len(knownHashes), len(knownTargets))
 	}
 
-	return hnp
-}
+	// Build the proof for only the known targets out of the available hashes.
+	subProofPos, _ := proofPositions(knownTargets, numLeaves, totalRows)
+	subProof := Proof{Targets: knownTargets, Proof: make([]Hash, len(subProofPos))}
+	for i, pos := range subProofPos {
+		hash, found := available[pos]
+		if !found {
+			return fmt.Errorf("VerifyPartialTargets fail. Position %d is needed "+
+				"to verify the known targets but it depends on an unknown target", pos)
+		}
+		subProof.Proof[i] = hash
+	}
 
-// Verify calculates the root hashes from the passed in proof and delHashes and
-// compares it against the current roots in the pollard.
-func (p *Pollard) Verify(delHashes []Hash, proof Proof, remember bool) error {
-	if len(delHashes) == 0 {
-		return nil
+	subHashes := make([]Hash, len(knownTargets))
+	for i, target := range knownTargets {
+		subHashes[i] = knownHashes[target]
+	}
+
+	_, err := Verify(Stump{Roots: roots, NumLeaves: numLeaves}, subHashes, subProof)
+	if err != nil {
+		return fmt.Errorf("VerifyPartialTargets fail. %v", err)
 	}
 
+	return nil
+}
+
+// VerifyLenient is Verify against the given roots but it accepts proofs with extra hashes
+// on top of the ones that are needed. The extra hashes must be the correct hashes of the
+// positions that can be calculated from the targets. They're removed before the proof
+// is verified. Proofs with a missing or a wrong hash are still rejected.
+func VerifyLenient(delHashes []Hash, proof Proof, roots []Hash, numLeaves uint64) error {
 	if len(delHashes) != len(proof.Targets) {
-		return fmt.Errorf("Pollard.Verify fail. Was given %d targets but got %d hashes",
+		return fmt.Errorf("VerifyLenient fail. Was given %d targets but got %d hashes",
 			len(proof.Targets), len(delHashes))
 	}
+	err := checkTargetsInForest(proof.Targets, numLeaves)
+	if err != nil {
+		return fmt.Errorf("VerifyLenient fail. %w", err)
+	}
 
-	_, rootCandidates := calculateHashes(p.NumLeaves, delHashes, proof)
-	if len(rootCandidates) == 0 {
-		return fmt.Errorf("Pollard.Verify fail. No roots calculated "+
-			"but have %d deletions", len(delHashes))
+	totalRows := treeRows(numLeaves)
+	hnp := toHashAndPos(proof.Targets, delHashes)
+	neededPos, _ := proofPositions(hnp.positions, numLeaves, totalRows)
+
+	// The hashes of the targets and the positions calculated from them.
+	calculated := make(map[uint64]Hash, len(hnp.positions)*2)
+	for i, pos := range hnp.positions {
+		calculated[pos] = hnp.hashes[i]
 	}
+	needed := make(map[uint64]Hash, len(neededPos))
+	minimal := make([]Hash, 0, len(neededPos))
+
+	// The positions are in row order so the proof hashes are matched up one row at a
+	// time. The calculated positions of a row are only known after the row below it.
+	proofIdx, targetIdx := 0, 0
+	var rowCalculated []uint64
+	for row := uint8(0); row <= totalRows; row++ {
+		for targetIdx < len(hnp.positions) &&
+			detectRow(hnp.positions[targetIdx], totalRows) == row {
+
+			rowCalculated = append(rowCalculated, hnp.positions[targetIdx])
+			targetIdx++
+		}
+		slices.Sort(rowCalculated)
+		rowCalculated = slices.Compact(rowCalculated)
 
-	rootMatches := 0
-	for i := range p.Roots {
-		if len(rootCandidates) > rootMatches &&
-			p.Roots[len(p.Roots)-(i+1)].data == rootCandidates[rootMatches] {
-			rootMatches++
+		rowEnd := 0
+		for rowEnd < len(neededPos) && detectRow(neededPos[rowEnd], totalRows) == row {
+			rowEnd++
 		}
-	}
-	// Error out if all the rootCandidates do not have a corresponding
-	// polnode with the same hash.
-	if len(rootCandidates) != rootMatches {
-		rootHashes := make([]Hash, len(p.Roots))
-		for i := range rootHashes {
-			rootHashes[i] = p.Roots[i].data
+		rowNeeded := neededPos[:rowEnd]
+		neededPos = neededPos[rowEnd:]
+
+		// Needed positions take the next proof hash. Calculated positions skip the
+		// next proof hash if it's the same hash.
+		for i, j := 0, 0; i < len(rowNeeded) || j < len(rowCalculated); {
+			if j == len(rowCalculated) ||
+				(i < len(rowNeeded) && rowNeeded[i] < rowCalculated[j]) {
+
+				if proofIdx >= len(proof.Proof) {
+					return fmt.Errorf("VerifyLenient fail. Missing the proof "+
+						"hash for position %d", rowNeeded[i])
+				}
+				needed[rowNeeded[i]] = proof.Proof[proofIdx]
+				minimal = append(minimal, proof.Proof[proofIdx])
+				proofIdx++
+				i++
+				continue
+			}
+
+			if proofIdx < len(proof.Proof) &&
+				proof.Proof[proofIdx] == calculated[rowCalculated[j]] {
+				proofIdx++
+			}
+			j++
 		}
-		// The proof is invalid because some root candidates were not
-		// included in `roots`.
-		err := fmt.Errorf("Pollard.Verify fail. Have %d roots but only "+
-			"matched %d roots.\nRootcandidates:\n%v\nRoots:\n%v",
-			len(rootCandidates), rootMatches,
-			printHashes(rootCandidates), printHashes(rootHashes))
-		return err
+
+		// Calculate the parents for the next row.
+		nextCalculated := make([]uint64, 0, len(rowCalculated))
+		for _, pos := range rowCalculated {
+			if isRootPositionTotalRows(pos, numLeaves, totalRows) {
+				continue
+			}
+			sibHash, found := calculated[sibling(pos)]
+			if !found {
+				sibHash, found = needed[sibling(pos)]
+			}
+			if !found {
+				return fmt.Errorf("VerifyLenient fail. Missing the hash for "+
+					"position %d", sibling(pos))
+			}
+
+			parentPos := parent(pos, totalRows)
+			if pos&1 == 0 {
+				calculated[parentPos] = parentHash(calculated[pos], sibHash)
+			} else {
+				calculated[parentPos] = parentHash(sibHash, calculated[pos])
+			}
+			nextCalculated = append(nextCalculated, parentPos)
+		}
+		rowCalculated = nextCalculated
+	}
+
+	if proofIdx != len(proof.Proof) {
+		return fmt.Errorf("VerifyLenient fail. %d of the %d proof hashes are neither "+
+			"needed nor correct", len(proof.Proof)-proofIdx, len(proof.Proof))
+	}
+
+	minimalProof := Proof{Targets: hnp.positions, Proof: minimal}
+	_, err = Verify(Stump{Roots: roots, NumLeaves: numLeaves}, hnp.hashes, minimalProof)
+	if err != nil {
+		return fmt.Errorf("VerifyLenient fail. %v", err)
 	}
 
 	return nil
@@ -456,7 +1698,7 @@ func getNextLeast[E any](slice1, slice2 []E, less func(a, b E) bool) int {
 }
 
 // getNextHash returns the hash of the parent of these two hashes.
-func getNextHash(pos uint64, hash, sibHash Hash) Hash {
+func getNextHash(pos uint64, hash, sibHash Hash, hasher Hasher) Hash {
 	// There's 3 different outcomes:
 	// 1: Current hash is empty -> move the sibling up.
 	// 2: Sibling hash is empty -> move the current hash up.
@@ -468,9 +1710,9 @@ func getNextHash(pos uint64, hash, sibHash Hash) Hash {
 		nextHash = hash
 	} else {
 		if isLeftNiece(pos) {
-			nextHash = parentHash(hash, sibHash)
+			nextHash = hasher(hash, sibHash)
 		} else {
-			nextHash = parentHash(sibHash, hash)
+			nextHash = hasher(sibHash, hash)
 		}
 	}
 
@@ -533,11 +1775,16 @@ func getNextPos(slice1, slice2 []uint64, slice1Idx, slice2Idx int) (uint64, int,
 // hashes of the roots and the nodes used to calculate the roots after the
 // deletion of the targets.
 func calculateHashes(numLeaves uint64, delHashes []Hash, proof Proof) (hashAndPos, []Hash) {
-	totalRows := treeRows(numLeaves)
+	return calculateHashesWithHasher(numLeaves, delHashes, proof, parentHash)
+}
+
+// calculateHashesWithHasher is calculateHashes but all the parent hashes are calculated
+// with the given hasher.
+func calculateHashesWithHasher(numLeaves uint64, delHashes []Hash, proof Proof,
+	hasher Hasher) (hashAndPos, []Hash) {
 
 	// Where all the parent hashes we've calculated in a given row will go to.
 	nextProves := hashAndPos{make([]uint64, 0, len(proof.Targets)), make([]Hash, 0, len(proof.Targets))}
-	nextProvesIdx := 0
 
 	// These are the leaves to be proven. Each represent a position and the
 	// hash of a leaf.
@@ -545,10 +1792,28 @@ func calculateHashes(numLeaves uint64, delHashes []Hash, proof Proof) (hashAndPo
 		delHashes = make([]Hash, len(proof.Targets))
 	}
 	toProve := toHashAndPos(proof.Targets, delHashes)
-	toProveIdx := 0
 
 	// Where all the root hashes that we've calculated will go to.
 	calculatedRootHashes := make([]Hash, 0, numRoots(numLeaves))
+	calculatedRootHashes = calculateRoots(numLeaves, proof, hasher,
+		&toProve, &nextProves, calculatedRootHashes)
+
+	// Add in the targets as well since we need them as well to calculate up
+	// to the roots.
+	nextProves = mergeSortedHashAndPos(nextProves, toProve)
+	return nextProves, calculatedRootHashes
+}
+
+// calculateRoots hashes the sorted targets in toProve with the proof up to the roots
+// and appends the calculated roots to calculatedRootHashes. All the parent hashes that
+// were calculated are appended to nextProves. It doesn't allocate if the slices have
+// enough capacity.
+func calculateRoots(numLeaves uint64, proof Proof, hasher Hasher,
+	toProve, nextProves *hashAndPos, calculatedRootHashes []Hash) []Hash {
+
+	totalRows := treeRows(numLeaves)
+	nextProvesIdx := 0
+	toProveIdx := 0
 
 	// Separate index for the hashes in the passed in proof.
 	proofHashIdx := 0
@@ -602,14 +1867,11 @@ func calculateHashes(numLeaves uint64, delHashes []Hash, proof Proof) (hashAndPo
 		}
 
 		// Calculate the next hash.
-		nextHash := getNextHash(provePos, proveHash, sibHash)
+		nextHash := getNextHash(provePos, proveHash, sibHash, hasher)
 		nextProves.Append(parent(provePos, totalRows), nextHash)
 	}
 
-	// Add in the targets as well since we need them as well to calculate up
-	// to the roots.
-	nextProves = mergeSortedHashAndPos(nextProves, toProve)
-	return nextProves, calculatedRootHashes
+	return calculatedRootHashes
 }
 
 func mergeSortedSlicesFunc[E any](a, b []E, cmp func(E, E) int) (c []E) {
@@ -1398,3 +2660,224 @@ func (p *Proof) updateProofAdd(adds, cachedDelHashes []Hash, remembers []uint32,
 	p.Targets = origTargetsWithHash.positions
 	return origTargetsWithHash.hashes
 }
+
+// ConsistencyProof proves that an accumulator state is an append-only extension of an
+// older state. It has the hashes needed to hash the roots of the older state up to the
+// roots of the newer state.
+type ConsistencyProof struct {
+	Proof []Hash
+}
+
+// consistencyTargets returns the positions of the roots of the forest with oldNumLeaves
+// in the forest with newNumLeaves.
+func consistencyTargets(oldNumLeaves, newNumLeaves uint64) []uint64 {
+	oldRows, newRows := treeRows(oldNumLeaves), treeRows(newNumLeaves)
+
+	targets := RootPositions(oldNumLeaves, oldRows)
+	for i := range targets {
+		targets[i] = translatePos(targets[i], oldRows, newRows)
+	}
+
+	return targets
+}
+
+// ConsistencyProof returns a proof that the current state of the pollard is an extension
+// of the state when the pollard had fromNumLeaves. The roots of the old state are
+// proven as nodes in the current forest.
+//
+// NOTE Only additions are supported. If any of the leaves under the old roots were
+// deleted since, the old roots no longer exist in the forest and the returned proof
+// will not verify.
+func (p *Pollard) ConsistencyProof(fromNumLeaves uint64) (ConsistencyProof, error) {
+	if fromNumLeaves > p.NumLeaves {
+		return ConsistencyProof{}, fmt.Errorf("ConsistencyProof fail. Asked for a proof "+
+			"from %d leaves but only have %d leaves", fromNumLeaves, p.NumLeaves)
+	}
+
+	targets := consistencyTargets(fromNumLeaves, p.NumLeaves)
+	sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+
+	proofPositions, _ := proofPositions(targets, p.NumLeaves, treeRows(p.NumLeaves))
+	proof := ConsistencyProof{Proof: make([]Hash, len(proofPositions))}
+	for i, proofPos := range proofPositions {
+		hash := p.getHash(proofPos)
+		if hash == empty {
+			return ConsistencyProof{}, fmt.Errorf("ConsistencyProof fail. "+
+				"Couldn't read position %d", proofPos)
+		}
+		proof.Proof[i] = hash
+	}
+
+	return proof, nil
+}
+
+// VerifyConsistency verifies that the new state is an append-only extension of the
+// old state with the given consistency proof.
+//
+// NOTE Old states with empty roots are not supported as an empty root doesn't exist
+// in the forest once it's merged with the additions.
+func VerifyConsistency(oldRoots []Hash, oldNumLeaves uint64, newRoots []Hash,
+	newNumLeaves uint64, cp ConsistencyProof) error {
+
+	if oldNumLeaves > newNumLeaves {
+		return fmt.Errorf("VerifyConsistency fail. Old state has %d leaves "+
+			"but new state only has %d leaves", oldNumLeaves, newNumLeaves)
+	}
+	if len(oldRoots) != int(numRoots(oldNumLeaves)) {
+		return fmt.Errorf("VerifyConsistency fail. Expected %d old roots for %d "+
+			"leaves but got %d", numRoots(oldNumLeaves), oldNumLeaves, len(oldRoots))
+	}
+	for i, root := range oldRoots {
+		if root == empty {
+			return fmt.Errorf("VerifyConsistency fail. Old root at index %d is "+
+				"empty and empty roots are not supported", i)
+		}
+	}
+
+	if oldNumLeaves == 0 {
+		if len(cp.Proof) != 0 {
+			return fmt.Errorf("VerifyConsistency fail. Expected an empty proof "+
+				"but got %d hashes", len(cp.Proof))
+		}
+		return nil
+	}
+
+	proof := Proof{Targets: consistencyTargets(oldNumLeaves, newNumLeaves), Proof: cp.Proof}
+	_, err := Verify(Stump{Roots: newRoots, NumLeaves: newNumLeaves}, oldRoots, proof)
+	if err != nil {
+		return fmt.Errorf("VerifyConsistency fail. %v", err)
+	}
+
+	return nil
+}
+
+// SelfProof proves that a set of roots is a valid forest for a number of leaves. It
+// has a node under each of the non-empty roots along with the proof for those nodes.
+type SelfProof struct {
+	// Hashes are the hashes of the nodes under each of the non-empty roots. They're
+	// ordered the same way as the targets in Proof.
+	Hashes []Hash
+
+	// Proof is the proof for the nodes in Hashes.
+	Proof Proof
+}
+
+// SelfProof returns a proof that the current roots of the pollard are a valid forest
+// for the current number of leaves. For each of the non-empty roots, the lowest
+// node reachable by following the left children is proven.
+//
+// NOTE If the pollard is pruned, the proven nodes may be higher up as the walk down
+// stops at the first node that's not cached.
+func (p *Pollard) SelfProof() SelfProof {
+	forestRows := treeRows(p.NumLeaves)
+	rootPositions := RootPositions(p.NumLeaves, forestRows)
+
+	targets := make([]uint64, 0, len(rootPositions))
+	for i, rootPos := range rootPositions {
+		if p.Roots[i].data == empty {
+			continue
+		}
+
+		pos := rootPos
+		for detectRow(pos, forestRows) > 0 {
+			lChild := leftChild(pos, forestRows)
+			if p.getHash(lChild) == empty {
+				break
+			}
+			pos = lChild
+		}
+		targets = append(targets, pos)
+	}
+	sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+
+	sp := SelfProof{
+		Hashes: make([]Hash, len(targets)),
+		Proof:  Proof{Targets: targets},
+	}
+	for i, target := range targets {
+		sp.Hashes[i] = p.getHash(target)
+	}
+
+	proofPositions, _ := proofPositions(targets, p.NumLeaves, forestRows)
+	sp.Proof.Proof = make([]Hash, len(proofPositions))
+	for i, proofPos := range proofPositions {
+		sp.Proof.Proof[i] = p.getHash(proofPos)
+	}
+
+	return sp
+}
+
+// VerifySelfProof verifies that the given roots are a valid forest for numLeaves with
+// the self proof. Unlike ValidateRootShape, this checks that each of the non-empty roots
+// commits to a tree with its root at the position expected for numLeaves. The targets
+// must be below their roots except for the roots on row 0 which are lone leaves.
+func VerifySelfProof(roots []Hash, numLeaves uint64, sp SelfProof) error {
+	if len(roots) != int(numRoots(numLeaves)) {
+		return fmt.Errorf("VerifySelfProof fail. Expected %d roots for %d leaves "+
+			"but got %d", numRoots(numLeaves), numLeaves, len(roots))
+	}
+	if len(sp.Hashes) != len(sp.Proof.Targets) {
+		return fmt.Errorf("VerifySelfProof fail. Have %d targets but %d hashes",
+			len(sp.Proof.Targets), len(sp.Hashes))
+	}
+
+	// There must be exactly one target under each of the non-empty roots.
+	forestRows := treeRows(numLeaves)
+	rootPositions := RootPositions(numLeaves, forestRows)
+	proven := make(map[uint64]struct{}, len(rootPositions))
+	for i, target := range sp.Proof.Targets {
+		if i > 0 && target <= sp.Proof.Targets[i-1] {
+			return fmt.Errorf("VerifySelfProof fail. Targets are not sorted")
+		}
+		if target >= maxPosition(forestRows) {
+			return fmt.Errorf("VerifySelfProof fail. Target %d is out of "+
+				"range for %d leaves", target, numLeaves)
+		}
+		if sp.Hashes[i] == empty {
+			return fmt.Errorf("VerifySelfProof fail. Hash for target %d is empty",
+				target)
+		}
+
+		rootPos, err := getRootPosition(target, numLeaves, forestRows)
+		if err != nil {
+			return fmt.Errorf("VerifySelfProof fail. %v", err)
+		}
+		if _, found := proven[rootPos]; found {
+			return fmt.Errorf("VerifySelfProof fail. More than one target "+
+				"under the root at position %d", rootPos)
+		}
+		// A root can't be its own proof unless it's a lone leaf. Otherwise any
+		// hash could be passed off as a root.
+		if rootPos == target && detectRow(target, forestRows) != 0 {
+			return fmt.Errorf("VerifySelfProof fail. Target %d is the root "+
+				"at row %d", target, detectRow(target, forestRows))
+		}
+		proven[rootPos] = struct{}{}
+	}
+	for i, rootPos := range rootPositions {
+		_, found := proven[rootPos]
+		if roots[i] != empty && !found {
+			return fmt.Errorf("VerifySelfProof fail. Missing a target for "+
+				"the root at position %d", rootPos)
+		}
+		if roots[i] == empty && found {
+			return fmt.Errorf("VerifySelfProof fail. Have a target for "+
+				"the empty root at position %d", rootPos)
+		}
+		delete(proven, rootPos)
+	}
+	if len(proven) != 0 {
+		return fmt.Errorf("VerifySelfProof fail. Have targets " +
+			"that aren't under any root")
+	}
+	if len(sp.Proof.Targets) == 0 {
+		return nil
+	}
+
+	_, err := Verify(Stump{Roots: roots, NumLeaves: numLeaves}, sp.Hashes, sp.Proof)
+	if err != nil {
+		return fmt.Errorf("VerifySelfProof fail. %v", err)
+	}
+
+	return nil
+}
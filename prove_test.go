@@ -1,10 +1,15 @@
 package utreexo
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"golang.org/x/exp/slices"
@@ -1235,3 +1240,2171 @@ func FuzzUndoProof(f *testing.F) {
 		}
 	})
 }
+
+func TestProveDeletedLeaf(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.TombstoneWindow = 2
+	leaves := makeTestLeaves(8, 0)
+
+	// Block 1 adds the leaves.
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 2 deletes a leaf.
+	prevRoots := p.GetRoots()
+	delHashes := []Hash{leaves[3].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = p.Prove(delHashes)
+	if !errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected %v but got %v", ErrLeafDeleted, err)
+	}
+	if !strings.Contains(err.Error(), "block 2") {
+		t.Fatalf("expected the error to contain the deletion block but got %v", err)
+	}
+
+	// A hash that was never in the accumulator returns a generic error.
+	neverSeen := makeTestLeaves(1, len(leaves))[0].Hash
+	_, err = p.Prove([]Hash{neverSeen})
+	if err == nil || errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected a not found error but got %v", err)
+	}
+
+	// Undoing the deletion makes the leaf provable again.
+	err = p.Undo(0, proof, delHashes, prevRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prevRoots, p.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(prevRoots), printHashes(p.GetRoots()))
+	}
+	_, err = p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(nil, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tombstone is kept within the window and forgotten once it's outside it.
+	err = p.Modify(nil, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Prove(delHashes)
+	if !errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected %v but got %v", ErrLeafDeleted, err)
+	}
+	err = p.Modify(nil, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Prove(delHashes)
+	if errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected the tombstone to be forgotten but got %v", err)
+	}
+
+	// A deleted hash that's added again without being remembered is not cached
+	// instead of deleted.
+	pruned := NewAccumulator(false)
+	pruned.TombstoneWindow = 10
+	remembered := append([]Leaf(nil), leaves...)
+	for i := range remembered {
+		remembered[i].Remember = true
+	}
+	err = pruned.Modify(remembered, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err = pruned.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.Modify(nil, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pruned.Modify([]Leaf{leaves[3]}, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pruned.Prove(delHashes)
+	if err == nil || errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected a not found error but got %v", err)
+	}
+}
+
+func TestEmptyProof(t *testing.T) {
+	t.Parallel()
+
+	empty := EmptyProof()
+	var expected bytes.Buffer
+	_, err := empty.Write(&expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.Len() != empty.SerializeSize() {
+		t.Fatalf("expected %d bytes but wrote %d", empty.SerializeSize(), expected.Len())
+	}
+
+	// Nil and empty slices are serialized to the same canonical bytes.
+	for _, proof := range []Proof{{}, {Targets: []uint64{}, Proof: []Hash{}}} {
+		var buf bytes.Buffer
+		_, err := proof.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected.Bytes(), buf.Bytes()) {
+			t.Fatalf("expected %x but got %x", expected.Bytes(), buf.Bytes())
+		}
+
+		var read Proof
+		_, err = read.Read(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, EmptyProof()) {
+			t.Fatalf("expected %v but got %v", EmptyProof(), read)
+		}
+
+		var reserialized bytes.Buffer
+		_, err = read.Write(&reserialized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected.Bytes(), reserialized.Bytes()) {
+			t.Fatalf("expected %x but got %x", expected.Bytes(), reserialized.Bytes())
+		}
+	}
+
+	// The empty proof verifies against any roots when there are no deletions.
+	for _, numLeaves := range []int{0, 1, 7, 8, 31} {
+		p := NewAccumulator(true)
+		err := p.Modify(makeTestLeaves(numLeaves, 0), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stump := Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}
+		_, err = Verify(stump, nil, EmptyProof())
+		if err != nil {
+			t.Fatalf("expected the empty proof to verify with %d leaves but got %v",
+				numLeaves, err)
+		}
+	}
+}
+
+func TestProofSerialize(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(31, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := p.Prove([]Hash{leaves[3].Hash, leaves[17].Hash, leaves[30].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := proof.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != proof.SerializeSize() {
+		t.Fatalf("expected %d bytes but wrote %d", proof.SerializeSize(), n)
+	}
+
+	var read Proof
+	n, err = read.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != proof.SerializeSize() {
+		t.Fatalf("expected %d bytes but read %d", proof.SerializeSize(), n)
+	}
+	if !reflect.DeepEqual(proof, read) {
+		t.Fatalf("expected %s but got %s", proof.String(), read.String())
+	}
+
+	// Truncated proofs error out.
+	buf.Reset()
+	_, err = proof.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = read.Read(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		t.Fatalf("expected an error reading a truncated proof")
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	err := p.Modify(makeTestLeaves(5, 0), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type state struct {
+		roots     []Hash
+		numLeaves uint64
+	}
+	states := []state{{p.GetRoots(), p.NumLeaves}}
+
+	// Apply 10 blocks of additions.
+	for b := 0; b < 10; b++ {
+		err := p.Modify(makeTestLeaves(b+1, int(p.NumLeaves)), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, state{p.GetRoots(), p.NumLeaves})
+	}
+
+	// Every old state must be consistent with the latest state.
+	for _, old := range states {
+		cp, err := p.ConsistencyProof(old.numLeaves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = VerifyConsistency(old.roots, old.numLeaves, p.GetRoots(), p.NumLeaves, cp)
+		if err != nil {
+			t.Fatalf("from %d leaves: %v", old.numLeaves, err)
+		}
+
+		// A tampered old root must fail.
+		tampered := append([]Hash(nil), old.roots...)
+		tampered[0][0] ^= 0xff
+		err = VerifyConsistency(tampered, old.numLeaves, p.GetRoots(), p.NumLeaves, cp)
+		if err == nil {
+			t.Fatalf("from %d leaves: expected tampered roots to fail", old.numLeaves)
+		}
+	}
+
+	// Deleting a leaf from an old tree breaks the consistency.
+	old := states[0]
+	err = deleteHashes(&p, []Hash{makeTestLeaves(1, 0)[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := p.ConsistencyProof(old.numLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyConsistency(old.roots, old.numLeaves, p.GetRoots(), p.NumLeaves, cp)
+	if err == nil {
+		t.Fatalf("expected the consistency proof to fail after a deletion")
+	}
+
+	_, err = p.ConsistencyProof(p.NumLeaves + 1)
+	if err == nil {
+		t.Fatalf("expected an error for a proof from more leaves than the pollard has")
+	}
+}
+
+func TestProveRange(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(64, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[20].Hash, leaves[21].Hash, leaves[40].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		startPos, endPos uint64
+	}{
+		{16, 32},
+		{0, 1},
+		{5, 5},
+		{60, 64},
+		{0, 64},
+		{30, 200},
+	}
+
+	for _, test := range tests {
+		proof, hashes, err := p.ProveRange(test.startPos, test.endPos)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Check that exactly the live leaves in range are proven.
+		var expected []Hash
+		for _, hash := range p.CachedLeaves() {
+			pos := p.calculatePosition(p.NodeMap[hash.mini()])
+			if pos >= test.startPos && pos < test.endPos {
+				expected = append(expected, hash)
+			}
+		}
+		if !reflect.DeepEqual(expected, hashes) {
+			t.Fatalf("range [%d, %d): expected hashes:\n%s\ngot:\n%s",
+				test.startPos, test.endPos, printHashes(expected), printHashes(hashes))
+		}
+		for i, hash := range hashes {
+			pos := p.calculatePosition(p.NodeMap[hash.mini()])
+			if proof.Targets[i] != pos {
+				t.Fatalf("range [%d, %d): expected target %d but got %d",
+					test.startPos, test.endPos, pos, proof.Targets[i])
+			}
+		}
+
+		err = p.Verify(hashes, proof, false)
+		if err != nil {
+			t.Fatalf("range [%d, %d): %v", test.startPos, test.endPos, err)
+		}
+	}
+
+	_, _, err = p.ProveRange(10, 5)
+	if err == nil {
+		t.Fatalf("expected an error for an inverted range")
+	}
+}
+
+func TestProofEndianness(t *testing.T) {
+	t.Parallel()
+
+	proof := Proof{
+		Targets: []uint64{1, 0x0102030405060708},
+		Proof:   []Hash{{1, 2, 3}, {4, 5, 6}},
+	}
+
+	var little, big, def bytes.Buffer
+	_, err := proof.WriteWithEndianness(&little, LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proof.WriteWithEndianness(&big, BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proof.Write(&def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default is little endian.
+	if !bytes.Equal(little.Bytes(), def.Bytes()) {
+		t.Fatalf("expected the default to be little endian")
+	}
+	if bytes.Equal(little.Bytes(), big.Bytes()) {
+		t.Fatalf("expected little and big endian serializations to differ")
+	}
+
+	// The first target is after the 8 byte count.
+	if got := binary.BigEndian.Uint64(big.Bytes()[8:16]); got != 1 {
+		t.Fatalf("expected big endian target 1 but got %d", got)
+	}
+	if got := binary.LittleEndian.Uint64(little.Bytes()[8:16]); got != 1 {
+		t.Fatalf("expected little endian target 1 but got %d", got)
+	}
+
+	tests := []struct {
+		endianness Endianness
+		buf        []byte
+	}{
+		{LittleEndian, little.Bytes()},
+		{BigEndian, big.Bytes()},
+	}
+	for _, test := range tests {
+		var read Proof
+		_, err := read.ReadWithEndianness(bytes.NewReader(test.buf), test.endianness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(proof, read) {
+			t.Fatalf("expected %s but got %s", proof.String(), read.String())
+		}
+	}
+
+	// Reading with the wrong endianness doesn't give back the same proof.
+	var read Proof
+	_, err = read.ReadWithEndianness(bytes.NewReader(big.Bytes()), LittleEndian)
+	if err == nil && reflect.DeepEqual(proof, read) {
+		t.Fatalf("expected a mismatched endianness to not read the same proof")
+	}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(23, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[5].Hash, leaves[17].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := p.GetRoots()
+
+	for _, hash := range p.CachedLeaves() {
+		path, pos, err := p.MerklePath(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = VerifyInclusion(hash, pos, path, roots, p.NumLeaves)
+		if err != nil {
+			t.Fatalf("leaf %s at %d: %v", hash, pos, err)
+		}
+
+		// A wrong leaf must fail.
+		wrong := hash
+		wrong[31] ^= 0xff
+		err = VerifyInclusion(wrong, pos, path, roots, p.NumLeaves)
+		if err == nil {
+			t.Fatalf("leaf %s at %d: expected a wrong leaf to fail", hash, pos)
+		}
+
+		// A truncated path must fail.
+		if len(path) > 0 {
+			err = VerifyInclusion(hash, pos, path[:len(path)-1], roots, p.NumLeaves)
+			if err == nil {
+				t.Fatalf("leaf %s at %d: expected a truncated path to fail", hash, pos)
+			}
+		}
+
+		// A path that's too long must fail.
+		err = VerifyInclusion(hash, pos, append(path, Hash{1}), roots, p.NumLeaves)
+		if err == nil {
+			t.Fatalf("leaf %s at %d: expected a long path to fail", hash, pos)
+		}
+	}
+
+	_, _, err = p.MerklePath(leaves[5].Hash)
+	if err == nil {
+		t.Fatalf("expected an error for a deleted leaf")
+	}
+}
+
+func TestLeafDepth(t *testing.T) {
+	t.Parallel()
+
+	// 23 leaves make trees with 16, 4, 2 and 1 leaves.
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(23, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[5].Hash, leaves[17].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	depths := make(map[uint8]struct{})
+	for _, hash := range p.CachedLeaves() {
+		depth, err := p.LeafDepth(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, pos, err := p.MerklePath(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(depth) != len(path) {
+			t.Fatalf("leaf %s at %d: expected depth %d but got %d",
+				hash, pos, len(path), depth)
+		}
+		depths[depth] = struct{}{}
+	}
+
+	// The deletions moved some leaves up so there are leaves at depths other
+	// than the rows of the trees.
+	for _, depth := range []uint8{0, 1, 2, 3, 4} {
+		if _, found := depths[depth]; !found {
+			t.Fatalf("expected a leaf with depth %d", depth)
+		}
+	}
+
+	_, err = p.LeafDepth(leaves[5].Hash)
+	if err == nil {
+		t.Fatalf("expected an error for a deleted leaf")
+	}
+}
+
+func TestVerifyLimited(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(1024, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A large proof over many spread out leaves.
+	var delHashes []Hash
+	for i := 0; i < len(leaves); i += 3 {
+		delHashes = append(delHashes, leaves[i].Hash)
+	}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyLimited(delHashes, proof, 100)
+	if !errors.Is(err, ErrWorkLimitExceeded) {
+		t.Fatalf("expected %v but got %v", ErrWorkLimitExceeded, err)
+	}
+
+	// The exact amount of hash operations is allowed.
+	ops := verifyHashOps(proof.Targets, p.NumLeaves)
+	err = p.VerifyLimited(delHashes, proof, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyLimited(delHashes, proof, ops-1)
+	if !errors.Is(err, ErrWorkLimitExceeded) {
+		t.Fatalf("expected %v but got %v", ErrWorkLimitExceeded, err)
+	}
+
+	// A single leaf needs a hash for each row.
+	delHashes = []Hash{leaves[7].Hash}
+	proof, err = p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyLimited(delHashes, proof, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyLimited(delHashes, proof, 9)
+	if !errors.Is(err, ErrWorkLimitExceeded) {
+		t.Fatalf("expected %v but got %v", ErrWorkLimitExceeded, err)
+	}
+
+	// An invalid proof under the budget still fails verification.
+	proof.Proof[0][0] ^= 0xff
+	err = p.VerifyLimited(delHashes, proof, 10)
+	if err == nil || errors.Is(err, ErrWorkLimitExceeded) {
+		t.Fatalf("expected a verification error but got %v", err)
+	}
+}
+
+func TestSelfProof(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(13, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete a leaf under a tree and the lone leaf at the last root so that the
+	// forest has an empty root.
+	err = deleteHashes(&p, []Hash{leaves[0].Hash, leaves[12].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp := p.SelfProof()
+	err = VerifySelfProof(p.GetRoots(), p.NumLeaves, sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The roots are not a valid forest for a different number of leaves.
+	err = VerifySelfProof(p.GetRoots(), p.NumLeaves-2, sp)
+	if err == nil {
+		t.Fatalf("expected the self proof to fail for %d leaves", p.NumLeaves-2)
+	}
+
+	// Swapped roots must fail.
+	swapped := p.GetRoots()
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	err = VerifySelfProof(swapped, p.NumLeaves, sp)
+	if err == nil {
+		t.Fatalf("expected the self proof to fail with swapped roots")
+	}
+
+	// Roots from a different accumulator with the same number of leaves must fail.
+	other := NewAccumulator(true)
+	err = other.Modify(makeTestLeaves(13, 100), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySelfProof(other.GetRoots(), p.NumLeaves, sp)
+	if err == nil {
+		t.Fatalf("expected the self proof to fail with mismatched roots")
+	}
+
+	// Arbitrary roots can't be proven by passing in the roots as the targets.
+	forged := []Hash{{1}, {2}, {3}}
+	forestRows := treeRows(13)
+	rootPositions := RootPositions(13, forestRows)
+	forgedSP := SelfProof{Proof: Proof{Targets: make([]uint64, len(rootPositions))}}
+	for i := range rootPositions {
+		idx := len(rootPositions) - 1 - i
+		forgedSP.Proof.Targets[i] = rootPositions[idx]
+		forgedSP.Hashes = append(forgedSP.Hashes, forged[idx])
+	}
+	err = VerifySelfProof(forged, 13, forgedSP)
+	if err == nil {
+		t.Fatalf("expected the self proof to fail with the roots as the targets")
+	}
+
+	// A lone leaf is its own proof.
+	lone := NewAccumulator(true)
+	err = lone.Modify(makeTestLeaves(3, 200), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySelfProof(lone.GetRoots(), lone.NumLeaves, lone.SelfProof())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProofSharingStats(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(32, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaves that are next to each other share most of their proofs.
+	dels := []Hash{leaves[4].Hash, leaves[5].Hash, leaves[6].Hash, leaves[7].Hash}
+	batchedHashes, sumIndividualHashes, err := p.ProofSharingStats(dels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchedHashes >= sumIndividualHashes {
+		t.Fatalf("expected the batched proof to be smaller. batched %d, individual %d",
+			batchedHashes, sumIndividualHashes)
+	}
+
+	batched, err := p.Prove(dels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchedHashes != len(batched.Proof) {
+		t.Fatalf("expected %d batched hashes but got %d", len(batched.Proof), batchedHashes)
+	}
+
+	sum := 0
+	for _, del := range dels {
+		proof, err := p.Prove([]Hash{del})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += len(proof.Proof)
+	}
+	if sumIndividualHashes != sum {
+		t.Fatalf("expected %d individual hashes but got %d", sum, sumIndividualHashes)
+	}
+
+	_, _, err = p.ProofSharingStats([]Hash{{0xff}})
+	if err == nil {
+		t.Fatalf("expected an error for a hash that's not in the accumulator")
+	}
+}
+
+func TestVerifyUnsorted(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[1].Hash, leaves[4].Hash, leaves[9].Hash,
+		leaves[15].Hash, leaves[18].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyUnsorted(delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shuffle the targets along with their hashes.
+	rnd := rand.New(rand.NewSource(1))
+	shuffledHashes := append([]Hash(nil), delHashes...)
+	shuffled := Proof{
+		Targets: append([]uint64(nil), proof.Targets...),
+		Proof:   proof.Proof,
+	}
+	rnd.Shuffle(len(shuffledHashes), func(a, b int) {
+		shuffledHashes[a], shuffledHashes[b] = shuffledHashes[b], shuffledHashes[a]
+		shuffled.Targets[a], shuffled.Targets[b] = shuffled.Targets[b], shuffled.Targets[a]
+	})
+	err = p.VerifyUnsorted(shuffledHashes, shuffled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Breaking the pairing of the targets and the hashes must fail.
+	shuffledHashes[0], shuffledHashes[1] = shuffledHashes[1], shuffledHashes[0]
+	err = p.VerifyUnsorted(shuffledHashes, shuffled)
+	if err == nil {
+		t.Fatalf("expected mismatched targets and hashes to fail")
+	}
+
+	err = p.VerifyUnsorted(delHashes[1:], proof)
+	if err == nil {
+		t.Fatalf("expected mismatched lengths to fail")
+	}
+}
+
+func TestProofsDisjoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b     []uint64
+		disjoint bool
+	}{
+		{nil, nil, true},
+		{nil, []uint64{1, 2}, true},
+		{[]uint64{1, 2}, nil, true},
+		{[]uint64{1, 2}, []uint64{3, 4}, true},
+		{[]uint64{1, 2}, []uint64{2, 3}, false},
+		{[]uint64{9, 1, 5}, []uint64{7, 5}, false},
+		{[]uint64{0}, []uint64{0}, false},
+		{[]uint64{3, 8, 14}, []uint64{2, 9, 13, 20}, true},
+	}
+
+	for i, test := range tests {
+		a, b := Proof{Targets: test.a}, Proof{Targets: test.b}
+		if got := ProofsDisjoint(a, b); got != test.disjoint {
+			t.Fatalf("test %d: expected %v but got %v", i, test.disjoint, got)
+		}
+		if got := ProofsDisjoint(b, a); got != test.disjoint {
+			t.Fatalf("test %d reversed: expected %v but got %v", i, test.disjoint, got)
+		}
+	}
+}
+
+func TestProveAfter(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeRoots := p.GetRoots()
+
+	pendingAdds := makeTestLeaves(5, 20)
+	pendingDels := []Hash{leaves[3].Hash, leaves[11].Hash, leaves[17].Hash}
+	pendingProof, err := p.Prove(pendingDels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apply the pending modify to a copy to compare against.
+	modified := p.clone()
+	err = modified.Modify(pendingAdds, pendingDels, pendingProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := Stump{Roots: modified.GetRoots(), NumLeaves: modified.NumLeaves}
+
+	for _, futureTarget := range []Hash{leaves[2].Hash, leaves[10].Hash, pendingAdds[3].Hash} {
+		proof, err := p.ProveAfter(futureTarget, pendingAdds, pendingDels, pendingProof.Targets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect, err := modified.Prove([]Hash{futureTarget})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expect, proof) {
+			t.Fatalf("expected proof:\n%s\ngot:\n%s", expect.String(), proof.String())
+		}
+		_, err = Verify(stump, []Hash{futureTarget}, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(beforeRoots, p.GetRoots()) || p.NumLeaves != 20 {
+		t.Fatalf("pollard changed after ProveAfter")
+	}
+
+	// Leaves being deleted can't be proven after the modify.
+	_, err = p.ProveAfter(leaves[3].Hash, pendingAdds, pendingDels, pendingProof.Targets)
+	if err == nil {
+		t.Fatalf("expected an error proving a leaf that's pending deletion")
+	}
+
+	// The copy doesn't dedupe replays so adding the leaves that were just added is a
+	// collision and not an ignored replay.
+	p.DedupeReplays = true
+	err = p.Modify(pendingAdds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ProveAfter(pendingAdds[0].Hash, pendingAdds, nil, nil)
+	if !errors.Is(err, ErrHashCollision) {
+		t.Fatalf("expected %v but got %v", ErrHashCollision, err)
+	}
+}
+
+func TestVerifyPartialTargets(t *testing.T) {
+	t.Parallel()
+
+	// 15 leaves makes trees with 8, 4, 2, and 1 leaves.
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Prove leaves under 3 different trees.
+	proveHashes := []Hash{leaves[1].Hash, leaves[9].Hash, leaves[12].Hash}
+	proof, err := p.Prove(proveHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := make(map[uint64]Hash, len(proveHashes))
+	for i, target := range proof.Targets {
+		known[target] = proveHashes[i]
+	}
+	err = VerifyPartialTargets(known, proof, p.GetRoots(), p.NumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Omitting a target under a different tree doesn't affect the rest.
+	delete(known, proof.Targets[1])
+	err = VerifyPartialTargets(known, proof, p.GetRoots(), p.NumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong hash must fail.
+	known[proof.Targets[0]] = Hash{0xff}
+	err = VerifyPartialTargets(known, proof, p.GetRoots(), p.NumLeaves)
+	if err == nil {
+		t.Fatalf("expected a wrong known hash to fail")
+	}
+
+	// Hashes that aren't for the targets of the proof must fail.
+	err = VerifyPartialTargets(map[uint64]Hash{3: leaves[3].Hash}, proof,
+		p.GetRoots(), p.NumLeaves)
+	if err == nil {
+		t.Fatalf("expected a hash for a position that's not a target to fail")
+	}
+
+	// Leaves under the same tree depend on each other so omitting one of them
+	// makes the rest unverifiable.
+	proveHashes = []Hash{leaves[1].Hash, leaves[6].Hash}
+	proof, err = p.Prove(proveHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known = map[uint64]Hash{proof.Targets[0]: proveHashes[0]}
+	err = VerifyPartialTargets(known, proof, p.GetRoots(), p.NumLeaves)
+	if err == nil {
+		t.Fatalf("expected an error when an omitted target is needed")
+	}
+}
+
+func TestVerifyInto(t *testing.T) {
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(100, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scratch VerifyScratch
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		delHashes := make([]Hash, 0, 10)
+		for _, idx := range rnd.Perm(len(leaves))[:rnd.Intn(10)+1] {
+			delHashes = append(delHashes, leaves[idx].Hash)
+		}
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Randomly tamper with half of the proofs.
+		if i%2 == 1 && len(proof.Proof) > 0 {
+			proof.Proof[rnd.Intn(len(proof.Proof))][0] ^= 0xff
+		}
+
+		expectErr := p.Verify(delHashes, proof, false)
+		gotErr := p.VerifyInto(delHashes, proof, &scratch)
+		if (expectErr == nil) != (gotErr == nil) {
+			t.Fatalf("%d: Verify returned %v but VerifyInto returned %v",
+				i, expectErr, gotErr)
+		}
+		gotErr = p.VerifyInto(delHashes, proof, nil)
+		if (expectErr == nil) != (gotErr == nil) {
+			t.Fatalf("%d: Verify returned %v but VerifyInto with a nil scratch "+
+				"returned %v", i, expectErr, gotErr)
+		}
+	}
+
+	// Once the scratch has grown, verifying doesn't allocate.
+	delHashes := []Hash{leaves[3].Hash, leaves[50].Hash, leaves[97].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		err = p.VerifyInto(delHashes, proof, &scratch)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocs != 0 {
+		t.Fatalf("expected no allocations but got %v", allocs)
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(1<<12, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	delHashes := make([]Hash, 0, 32)
+	for i := 0; i < len(leaves); i += len(leaves) / 32 {
+		delHashes = append(delHashes, leaves[i].Hash)
+	}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Verify", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := p.Verify(delHashes, proof, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("VerifyInto", func(b *testing.B) {
+		var scratch VerifyScratch
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := p.VerifyInto(delHashes, proof, &scratch)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProveByIndex(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.TrackAddIndex = true
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves[:12], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[2].Hash, leaves[5].Hash, leaves[6].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(leaves[12:], nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[13].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stump := Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}
+	for _, idx := range []uint64{0, 4, 7, 12, 19} {
+		proof, err := p.ProveByIndex(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Verify(stump, []Hash{leaves[idx].Hash}, proof)
+		if err != nil {
+			t.Fatalf("add index %d: %v", idx, err)
+		}
+	}
+
+	// Deleted leaves and leaves that were never added can't be proven.
+	for _, idx := range []uint64{2, 13, 20} {
+		_, err = p.ProveByIndex(idx)
+		if err == nil {
+			t.Fatalf("expected an error proving add index %d", idx)
+		}
+	}
+
+	// Only the leaves that are still in the accumulator are tracked.
+	if len(p.addIndexes) != 16 || len(p.addIndexOf) != 16 {
+		t.Fatalf("expected 16 tracked leaves but got %d and %d",
+			len(p.addIndexes), len(p.addIndexOf))
+	}
+
+	// Undoing a deletion with the undo data tracks the add index again.
+	proof, err := p.Prove([]Hash{leaves[4].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud, err := p.ModifyWithUndo(nil, []Hash{leaves[4].Hash}, proof.Targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ProveByIndex(4)
+	if err == nil {
+		t.Fatalf("expected an error proving a deleted leaf")
+	}
+	err = p.UndoFromData(ud)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err = p.ProveByIndex(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Verify([]Hash{leaves[4].Hash}, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Undoing the additions forgets their add indexes.
+	prevRoots := p.GetRoots()
+	err = p.Modify(makeTestLeaves(3, 20), nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ProveByIndex(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Undo(3, Proof{}, nil, prevRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.ProveByIndex(21)
+	if err == nil {
+		t.Fatalf("expected an error proving an undone addition")
+	}
+	if len(p.addIndexes) != 16 || len(p.addIndexOf) != 16 {
+		t.Fatalf("expected 16 tracked leaves but got %d and %d",
+			len(p.addIndexes), len(p.addIndexOf))
+	}
+
+	untracked := NewAccumulator(true)
+	err = untracked.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = untracked.ProveByIndex(0)
+	if err == nil {
+		t.Fatalf("expected an error proving without TrackAddIndex")
+	}
+}
+
+func TestVerifyOne(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(37, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[4].Hash, leaves[20].Hash, leaves[36].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, leaf := range leaves {
+		if i == 4 || i == 20 || i == 36 {
+			continue
+		}
+		proof, err := p.Prove([]Hash{leaf.Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.VerifyOne(leaf.Hash, proof)
+		if err != nil {
+			t.Fatalf("leaf %d: %v", i, err)
+		}
+		if p.Verify([]Hash{leaf.Hash}, proof, false) != nil {
+			t.Fatalf("leaf %d: expected Verify to pass as well", i)
+		}
+
+		// A wrong hash must fail for both.
+		if len(proof.Proof) > 0 {
+			proof.Proof[0][0] ^= 0xff
+			if p.VerifyOne(leaf.Hash, proof) == nil {
+				t.Fatalf("leaf %d: expected VerifyOne to fail", i)
+			}
+			if p.Verify([]Hash{leaf.Hash}, proof, false) == nil {
+				t.Fatalf("leaf %d: expected Verify to fail", i)
+			}
+		}
+	}
+
+	proof, err := p.Prove([]Hash{leaves[0].Hash, leaves[1].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyOne(leaves[0].Hash, proof)
+	if err == nil {
+		t.Fatalf("expected an error for a proof with 2 targets")
+	}
+	err = p.VerifyOne(leaves[0].Hash, Proof{Targets: []uint64{0}})
+	if err == nil {
+		t.Fatalf("expected an error for a proof without enough hashes")
+	}
+	err = p.VerifyOne(leaves[0].Hash, Proof{Targets: []uint64{p.NumLeaves}})
+	if err == nil {
+		t.Fatalf("expected an error for a target out of range")
+	}
+}
+
+func BenchmarkVerifyOne(b *testing.B) {
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(1<<12, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	delHash := leaves[1234].Hash
+	proof, err := p.Prove([]Hash{delHash})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Verify", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := p.Verify([]Hash{delHash}, proof, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("VerifyOne", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := p.VerifyOne(delHash, proof)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSiblingHash(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(13, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[2].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totalRows := treeRows(p.NumLeaves)
+	for i, leaf := range leaves {
+		// Leaf 2 is deleted and leaf 12 is a root.
+		if i == 2 || i == 12 {
+			_, _, err = p.SiblingHash(leaf.Hash)
+			if err == nil {
+				t.Fatalf("leaf %d: expected an error", i)
+			}
+			continue
+		}
+
+		sibHash, sibPos, err := p.SiblingHash(leaf.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := p.calculatePosition(p.NodeMap[leaf.mini()])
+		if sibPos != sibling(pos) || p.getHash(sibPos) != sibHash {
+			t.Fatalf("leaf %d: got sibling %s at %d", i, sibHash, sibPos)
+		}
+
+		var parentHashed Hash
+		if isLeftNiece(pos) {
+			parentHashed = parentHash(leaf.Hash, sibHash)
+		} else {
+			parentHashed = parentHash(sibHash, leaf.Hash)
+		}
+		if expect := p.getHash(parent(pos, totalRows)); parentHashed != expect {
+			t.Fatalf("leaf %d: expected parent %s but got %s", i, expect, parentHashed)
+		}
+	}
+
+	// The sibling of a remembered leaf in a pruned pollard is cached as well.
+	pruned := NewAccumulator(false)
+	prunedLeaves := append([]Leaf(nil), leaves...)
+	prunedLeaves[6].Remember = true
+	err = pruned.Modify(prunedLeaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sibHash, sibPos, err := pruned.SiblingHash(leaves[6].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sibHash != leaves[7].Hash || sibPos != 7 {
+		t.Fatalf("expected leaf 7 at position 7 but got %s at %d", sibHash, sibPos)
+	}
+	_, _, err = pruned.SiblingHash(leaves[7].Hash)
+	if err == nil {
+		t.Fatalf("expected an error for a leaf that's not cached")
+	}
+}
+
+func TestVerifyZeroProofHash(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delHashes := []Hash{leaves[2].Hash, leaves[9].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Legitimate proofs still pass.
+	err = p.Verify(delHashes, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range proof.Proof {
+		zeroed := Proof{Targets: proof.Targets, Proof: make([]Hash, len(proof.Proof))}
+		copy(zeroed.Proof, proof.Proof)
+		zeroed.Proof[i] = empty
+
+		err = p.Verify(delHashes, zeroed, false)
+		if !errors.Is(err, ErrZeroProofHash) {
+			t.Fatalf("expected %v but got %v", ErrZeroProofHash, err)
+		}
+		_, err = Verify(Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}, delHashes, zeroed)
+		if !errors.Is(err, ErrZeroProofHash) {
+			t.Fatalf("expected %v but got %v", ErrZeroProofHash, err)
+		}
+	}
+}
+
+func TestAddBlockOf(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	p.TrackAddIndex = true
+
+	var blockRanges []BlockRange
+	blockOf := make(map[Hash]int)
+	sc := newSimChain(0x07)
+	for b := 0; b < 20; b++ {
+		adds, _, delHashes := sc.NextBlock(uint32(b%5 + 1))
+
+		start := p.NumLeaves
+		err := deleteHashes(&p, delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(adds, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockRanges = append(blockRanges, BlockRange{Start: start, End: p.NumLeaves})
+
+		for _, delHash := range delHashes {
+			delete(blockOf, delHash)
+		}
+		for _, add := range adds {
+			blockOf[add.Hash] = b
+		}
+	}
+
+	for hash, expected := range blockOf {
+		got, err := p.AddBlockOf(hash, blockRanges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Fatalf("expected hash %s to be added in block %d but got %d",
+				hash, expected, got)
+		}
+	}
+
+	// Leaves outside of the block ranges aren't found.
+	for hash := range blockOf {
+		_, err := p.AddBlockOf(hash, blockRanges[:0])
+		if err == nil {
+			t.Fatalf("expected an error for a leaf outside of the block ranges")
+		}
+		break
+	}
+	_, err := p.AddBlockOf(Hash{0xff}, blockRanges)
+	if err == nil {
+		t.Fatalf("expected an error for a leaf that isn't cached")
+	}
+
+	// A leaf that's deleted and added again is in the block of the latest add.
+	for hash := range blockOf {
+		err = deleteHashes(&p, []Hash{hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := p.NumLeaves
+		err = p.Modify([]Leaf{{Hash: hash}}, nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockRanges = append(blockRanges, BlockRange{Start: start, End: p.NumLeaves})
+
+		got, err := p.AddBlockOf(hash, blockRanges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != len(blockRanges)-1 {
+			t.Fatalf("expected the re-added hash in block %d but got %d",
+				len(blockRanges)-1, got)
+		}
+		break
+	}
+}
+
+func TestProveForModify(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	stump := Stump{}
+	sc := newSimChain(0x07)
+	for b := 0; b < 30; b++ {
+		adds, _, delHashes := sc.NextBlock(5)
+
+		proof, err := p.ProveForModify(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The proof is used for the deletions and the additions of the same block.
+		addHashes := make([]Hash, len(adds))
+		for i, add := range adds {
+			addHashes[i] = add.Hash
+		}
+		_, err = stump.Update(delHashes, addHashes, proof)
+		if err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+
+		if !reflect.DeepEqual(stump.Roots, p.GetRoots()) {
+			t.Fatalf("block %d: expected roots:\n%s\ngot:\n%s", b,
+				printHashes(stump.Roots), printHashes(p.GetRoots()))
+		}
+		err = p.checkHashes()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leaves := p.CachedLeaves()
+	_, err := p.ProveForModify([]Hash{leaves[0], leaves[1], leaves[0]})
+	if !errors.Is(err, ErrDuplicateDeletion) {
+		t.Fatalf("expected %v but got %v", ErrDuplicateDeletion, err)
+	}
+}
+
+func TestVerifyTargetOutOfRange(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(6, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump := Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}
+
+	delHashes := []Hash{leaves[1].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 6 and 7 are leaf positions past the numLeaves and 11 is the parent of them.
+	for _, target := range []uint64{6, 7, 11, 100} {
+		crafted := Proof{Targets: []uint64{target}, Proof: proof.Proof}
+
+		err = p.Verify(delHashes, crafted, false)
+		if !errors.Is(err, ErrTargetOutOfRange) {
+			t.Fatalf("target %d: expected %v but got %v", target, ErrTargetOutOfRange, err)
+		}
+		err = p.VerifyOne(delHashes[0], crafted)
+		if !errors.Is(err, ErrTargetOutOfRange) {
+			t.Fatalf("target %d: expected %v but got %v", target, ErrTargetOutOfRange, err)
+		}
+		_, err = Verify(stump, delHashes, crafted)
+		if !errors.Is(err, ErrTargetOutOfRange) {
+			t.Fatalf("target %d: expected %v but got %v", target, ErrTargetOutOfRange, err)
+		}
+	}
+
+	// Targets in the forest still verify.
+	err = p.Verify(delHashes, proof, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(stump, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnProveError(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(false)
+	p.TombstoneWindow = 2
+	leaves := makeTestLeaves(8, 0)
+	leaves[3].Remember = true
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []ProveErrorEvent
+	p.OnProveError = func(event ProveErrorEvent) {
+		events = append(events, event)
+	}
+
+	// A successful prove doesn't call the hook.
+	_, err = p.Prove([]Hash{leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events but got %v", events)
+	}
+
+	// The leaf isn't cached in the pruned pollard.
+	_, err = p.Prove([]Hash{leaves[3].Hash, leaves[5].Hash})
+	if err == nil {
+		t.Fatalf("expected an error proving an uncached leaf")
+	}
+	expected := ProveErrorEvent{Hash: leaves[5].Hash, Reason: ProveErrorNotCached}
+	if len(events) != 1 || events[0] != expected {
+		t.Fatalf("expected event %v but got %v", expected, events)
+	}
+
+	// The hook doesn't change the error.
+	p.OnProveError = nil
+	_, expectedErr := p.Prove([]Hash{leaves[3].Hash, leaves[5].Hash})
+	if err.Error() != expectedErr.Error() {
+		t.Fatalf("expected error %v but got %v", expectedErr, err)
+	}
+
+	// Deleted leaves are reported with their own reason.
+	full := NewAccumulator(true)
+	full.TombstoneWindow = 2
+	err = full.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&full, []Hash{leaves[2].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	full.OnProveError = func(event ProveErrorEvent) {
+		events = append(events, event)
+	}
+	_, err = full.Prove([]Hash{leaves[2].Hash})
+	if !errors.Is(err, ErrLeafDeleted) {
+		t.Fatalf("expected %v but got %v", ErrLeafDeleted, err)
+	}
+	expected = ProveErrorEvent{Hash: leaves[2].Hash, Reason: ProveErrorDeleted}
+	if len(events) != 1 || events[0] != expected {
+		t.Fatalf("expected event %v but got %v", expected, events)
+	}
+
+	// Failed proves on the internal copies aren't reported.
+	full.HistoryLength = 2
+	numLeaves := full.NumLeaves
+	adds := makeTestLeaves(3, 100)
+	err = full.Modify(adds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = nil
+	_, err = full.ProveHistorical(adds[0].Hash, numLeaves)
+	if err == nil {
+		t.Fatalf("expected an error proving a leaf that was added after the state")
+	}
+	_, err = full.ProveAfter(leaves[2].Hash, nil, nil, nil)
+	if err == nil {
+		t.Fatalf("expected an error proving a deleted leaf")
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events but got %v", events)
+	}
+}
+
+func TestVerifyBlock(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(8, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The block spends one of its own adds and two older leaves.
+	adds := makeTestLeaves(4, len(leaves))
+	older := []Hash{leaves[1].Hash, leaves[6].Hash}
+	dels := []Hash{older[0], adds[2].Hash, older[1]}
+	proof, err := p.Prove(older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.VerifyBlock(adds, dels, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A leaf that's neither added in the block nor in the accumulator can't be proven.
+	badProof, err := p.Prove([]Hash{older[0], leaves[3].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := makeTestLeaves(1, 100)[0].Hash
+	err = p.VerifyBlock(adds, []Hash{older[0], unknown}, badProof)
+	if err == nil {
+		t.Fatalf("expected an error for an unprovable delete")
+	}
+
+	// The within-block spend must not be in the proof.
+	err = p.VerifyBlock(nil, dels, proof)
+	if err == nil {
+		t.Fatalf("expected an error when the spent add isn't in the block")
+	}
+	err = p.VerifyBlock(adds, []Hash{older[0], older[0]}, proof)
+	if !errors.Is(err, ErrDuplicateDeletion) {
+		t.Fatalf("expected %v but got %v", ErrDuplicateDeletion, err)
+	}
+}
+
+func TestProveCacheMetrics(t *testing.T) {
+	t.Parallel()
+
+	// None of the leaves are remembered so every prove is a miss.
+	p := NewAccumulator(false)
+	leaves := makeTestLeaves(16, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		_, err = p.Prove([]Hash{leaf.Hash})
+		if err == nil {
+			t.Fatalf("expected an error proving %s", leaf.Hash)
+		}
+	}
+	expected := Metrics{ProveCacheMisses: uint64(len(leaves))}
+	if p.Metrics() != expected {
+		t.Fatalf("expected %+v but got %+v", expected, p.Metrics())
+	}
+
+	// Every fourth leaf is remembered. Proofs with only some of the hashes cached are
+	// misses.
+	p = NewAccumulator(false)
+	for i := range leaves {
+		leaves[i].Remember = i%4 == 0
+	}
+	err = p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaf := range leaves {
+		_, err = p.Prove([]Hash{leaf.Hash})
+		if (err == nil) != leaf.Remember {
+			t.Fatalf("unexpected prove result for %s: %v", leaf.Hash, err)
+		}
+	}
+	_, err = p.Prove([]Hash{leaves[0].Hash, leaves[1].Hash})
+	if err == nil {
+		t.Fatalf("expected an error for a partial proof")
+	}
+	_, err = p.Prove([]Hash{leaves[0].Hash, leaves[4].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = Metrics{ProveCacheHits: 5, ProveCacheMisses: 13}
+	if p.Metrics() != expected {
+		t.Fatalf("expected %+v but got %+v", expected, p.Metrics())
+	}
+}
+
+func TestProofStream(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(31, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofs := []Proof{{}}
+	for i := 0; i < len(leaves); i += 3 {
+		proof, err := p.Prove([]Hash{leaves[i].Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, proof)
+	}
+	proof, err := p.Prove([]Hash{leaves[1].Hash, leaves[9].Hash, leaves[30].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs = append(proofs, proof)
+
+	var buf bytes.Buffer
+	writer := NewProofStreamWriter(&buf)
+	for _, proof := range proofs {
+		err = writer.Write(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := buf.Bytes()
+
+	reader := NewProofStreamReader(bytes.NewReader(stream))
+	for i, expected := range proofs {
+		got, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(expected.Targets, got.Targets) ||
+			!slices.Equal(expected.Proof, got.Proof) {
+			t.Fatalf("proof %d: expected %s but got %s", i, expected.String(), got.String())
+		}
+	}
+	_, err = reader.Next()
+	if err != io.EOF {
+		t.Fatalf("expected %v but got %v", io.EOF, err)
+	}
+
+	// Truncating the last record anywhere errors out cleanly after the other records.
+	lastSize := 8 + proofs[len(proofs)-1].SerializeSize()
+	for cut := 1; cut < lastSize; cut++ {
+		reader = NewProofStreamReader(bytes.NewReader(stream[:len(stream)-cut]))
+		for i := 0; i < len(proofs)-1; i++ {
+			_, err = reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = reader.Next()
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("cut %d: expected %v but got %v", cut, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestVerifyAndMap(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[2].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leaf 3 moved up after leaf 2 was deleted.
+	delHashes := []Hash{leaves[17].Hash, leaves[3].Hash, leaves[8].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetHashes, err := p.VerifyAndMap(delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targetHashes) != len(delHashes) {
+		t.Fatalf("expected %d entries but got %d", len(delHashes), len(targetHashes))
+	}
+	for _, delHash := range delHashes {
+		pos := p.calculatePosition(p.NodeMap[delHash.mini()])
+		if targetHashes[pos] != delHash {
+			t.Fatalf("expected %s at pos %d but got %s", delHash, pos, targetHashes[pos])
+		}
+	}
+
+	// The map is nil for an invalid proof.
+	delHashes[0], delHashes[1] = delHashes[1], delHashes[0]
+	targetHashes, err = p.VerifyAndMap(delHashes, proof)
+	if err == nil || targetHashes != nil {
+		t.Fatalf("expected an error and a nil map but got %v and %v", err, targetHashes)
+	}
+}
+
+func TestVerifyLenient(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(21, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := p.GetRoots()
+
+	delHashes := []Hash{leaves[0].Hash, leaves[5].Hash, leaves[18].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyLenient(delHashes, proof, roots, p.NumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pad the proof with the hashes of a target and of positions that can be
+	// calculated from the targets, including a root.
+	totalRows := treeRows(p.NumLeaves)
+	needed, _ := proofPositions(copySortedFunc(proof.Targets, uint64Less),
+		p.NumLeaves, totalRows)
+	extras := []uint64{5, parent(0, totalRows), parent(18, totalRows),
+		RootPositions(p.NumLeaves, totalRows)[0]}
+	padded := Proof{Targets: proof.Targets}
+	for _, pos := range copySortedFunc(append(needed, extras...), uint64Less) {
+		padded.Proof = append(padded.Proof, p.GetHash(pos))
+	}
+	if len(padded.Proof) != len(proof.Proof)+len(extras) {
+		t.Fatalf("expected %d padded hashes but got %d",
+			len(proof.Proof)+len(extras), len(padded.Proof))
+	}
+	err = VerifyLenient(delHashes, padded, roots, p.NumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong hash is rejected whether it's an extra one or a needed one.
+	for i := range padded.Proof {
+		wrong := Proof{Targets: padded.Targets, Proof: append([]Hash(nil), padded.Proof...)}
+		wrong.Proof[i][0] ^= 0xff
+		err = VerifyLenient(delHashes, wrong, roots, p.NumLeaves)
+		if err == nil {
+			t.Fatalf("expected an error with the proof hash at idx %d changed", i)
+		}
+	}
+
+	// A missing hash is rejected.
+	missing := Proof{Targets: proof.Targets, Proof: proof.Proof[1:]}
+	err = VerifyLenient(delHashes, missing, roots, p.NumLeaves)
+	if err == nil {
+		t.Fatalf("expected an error for a proof missing a hash")
+	}
+}
+
+func TestProofHashFrequency(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(16, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	totalRows := p.GetTreeRows()
+
+	// Nothing is recorded unless it's turned on.
+	_, err = p.Prove([]Hash{leaves[0].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freq := p.ProofHashFrequency(); len(freq) != 0 {
+		t.Fatalf("expected no frequencies but got %v", freq)
+	}
+
+	// Prove each of the first 4 leaves. They share the ancestors above the subtree of
+	// leaves 0 to 3 so the siblings of those ancestors are in all 4 proofs.
+	p.RecordProofHashes = true
+	for i := 0; i < 4; i++ {
+		proof, err := p.Prove([]Hash{leaves[i].Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The recording doesn't change the proof.
+		p.RecordProofHashes = false
+		expected, err := p.Prove([]Hash{leaves[i].Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.RecordProofHashes = true
+		if !reflect.DeepEqual(expected, proof) {
+			t.Fatalf("expected proof %s but got %s", expected.String(), proof.String())
+		}
+	}
+
+	pos47, _ := parentMany(4, 2, totalRows)
+	pos815, _ := parentMany(8, 3, totalRows)
+	pos23 := parent(2, totalRows)
+	expected := map[Hash]int{
+		p.GetHash(pos815):               4,
+		p.GetHash(pos47):                4,
+		p.GetHash(pos23):                2,
+		p.GetHash(parent(0, totalRows)): 2,
+		leaves[0].Hash:                  1,
+		leaves[1].Hash:                  1,
+		leaves[2].Hash:                  1,
+		leaves[3].Hash:                  1,
+	}
+	freq := p.ProofHashFrequency()
+	if !reflect.DeepEqual(expected, freq) {
+		t.Fatalf("expected %v but got %v", expected, freq)
+	}
+
+	// The proves done on internal copies aren't counted and the copies don't share
+	// the counts.
+	_, err = p.ProveAfter(leaves[5].Hash, makeTestLeaves(2, 16), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := p.clone()
+	_, err = c.Prove([]Hash{leaves[9].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	freq = p.ProofHashFrequency()
+	if !reflect.DeepEqual(expected, freq) {
+		t.Fatalf("expected %v but got %v", expected, freq)
+	}
+
+	p.ResetProofHashFrequency()
+	if freq := p.ProofHashFrequency(); len(freq) != 0 {
+		t.Fatalf("expected no frequencies after the reset but got %v", freq)
+	}
+}
+
+func TestMinNumLeaves(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		targets  []uint64
+		expected uint64
+	}{
+		{nil, 0},
+		{[]uint64{0}, 1},
+		{[]uint64{1}, 2},
+		// 4 is the parent of 0 and 1 in a forest of 3 or 4 leaves.
+		{[]uint64{4}, 3},
+		{[]uint64{0, 2, 7}, 8},
+		// 5 is the parent of 2 and 3 in a forest of 4 leaves.
+		{[]uint64{5}, 4},
+		// 12 is the parent of 0 to 3 in a forest of 5 to 8 leaves.
+		{[]uint64{3, 12}, 5},
+		{[]uint64{13}, 8},
+		// 9 is a leaf in a forest of 10 or more leaves but it's the parent of 2 and 3
+		// in a forest of 5 to 8 leaves.
+		{[]uint64{9}, 5},
+		{[]uint64{9, 14}, 8},
+		// 16 is the parent of 0 and 1 in a forest of 9 to 16 leaves.
+		{[]uint64{9, 16}, 10},
+	}
+	for _, test := range tests {
+		proof := Proof{Targets: test.targets}
+		got := proof.MinNumLeaves()
+		if got != test.expected {
+			t.Fatalf("targets %v: expected %d but got %d", test.targets, test.expected, got)
+		}
+	}
+
+	// Check against all the smaller forests by brute force.
+	for _, test := range tests[1:] {
+		proof := Proof{Targets: test.targets}
+		min := proof.MinNumLeaves()
+		for numLeaves := uint64(1); numLeaves <= min; numLeaves++ {
+			allInForest := true
+			for _, target := range test.targets {
+				if !inForest(target, numLeaves, treeRows(numLeaves)) {
+					allInForest = false
+				}
+			}
+			if allInForest != (numLeaves == min) {
+				t.Fatalf("targets %v: expected all targets in the forest of %d "+
+					"leaves to be %v", test.targets, numLeaves, numLeaves == min)
+			}
+		}
+	}
+}
+
+func TestProofDelta(t *testing.T) {
+	t.Parallel()
+
+	blocks, _, err := makeModifyOps(60, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wallet proves the oldest 6 of the leaves every block.
+	p := NewAccumulator(true)
+	var prev Proof
+	deltaSize, fullSize := 0, 0
+	for i, block := range blocks {
+		err = p.Modify(block.Adds, block.DelHashes, block.Proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wallet := p.CachedLeaves()
+		if len(wallet) > 6 {
+			wallet = wallet[:6]
+		}
+		proof, err := p.Prove(wallet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		delta := proof.Delta(prev)
+		got, err := prev.ApplyDelta(delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(proof.Targets, got.Targets) || !slices.Equal(proof.Proof, got.Proof) {
+			t.Fatalf("block %d: expected %s but got %s", i, proof.String(), got.String())
+		}
+
+		deltaSize += len(delta.RemovedTargets) + len(delta.AddedTargets) +
+			len(delta.RemovedHashes) + len(delta.AddedHashes)
+		fullSize += len(proof.Targets) + len(proof.Proof)
+		prev = proof
+	}
+	if deltaSize >= fullSize {
+		t.Fatalf("expected the deltas to be smaller than the proofs but got %d and %d",
+			deltaSize, fullSize)
+	}
+
+	// Reordered targets are still reconstructed.
+	a := Proof{Targets: []uint64{1, 2, 3, 4}, Proof: []Hash{{1}, {2}}}
+	b := Proof{Targets: []uint64{4, 2, 9, 1}, Proof: []Hash{{3}, {2}, {1}}}
+	got, err := a.ApplyDelta(b.Delta(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(b.Targets, got.Targets) || !slices.Equal(b.Proof, got.Proof) {
+		t.Fatalf("expected %s but got %s", b.String(), got.String())
+	}
+
+	// Invalid deltas are rejected.
+	invalid := []ProofDelta{
+		{RemovedTargets: []int{4}},
+		{RemovedTargets: []int{1, 1}},
+		{AddedTargets: []uint64{7}},
+		{AddedTargets: []uint64{7}, AddedTargetIndexes: []int{5}},
+		{AddedHashes: []Hash{{5}, {6}}, AddedHashIndexes: []int{3, 2}},
+	}
+	for i, d := range invalid {
+		_, err = a.ApplyDelta(d)
+		if err == nil {
+			t.Fatalf("expected an error for invalid delta %d", i)
+		}
+	}
+}
+
+func TestVerifyAllStopOnFirst(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(20, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([]VerifyItem, 5)
+	for i := range items {
+		items[i].Dels = []Hash{leaves[i].Hash, leaves[19-i].Hash}
+		items[i].Proof, err = p.Prove(items[i].Dels)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failedIdx, err := p.VerifyAllStopOnFirst(items)
+	if err != nil || failedIdx != -1 {
+		t.Fatalf("expected -1 and no error but got %d and %v", failedIdx, err)
+	}
+
+	// Tamper with the third and the fifth proofs. Only the third is reported.
+	items[2].Proof.Proof[0][0] ^= 0xff
+	items[4].Dels[0] = leaves[10].Hash
+	failedIdx, err = p.VerifyAllStopOnFirst(items)
+	if err == nil || failedIdx != 2 {
+		t.Fatalf("expected 2 and an error but got %d and %v", failedIdx, err)
+	}
+}
+
+func TestSortTargetsWithHashes(t *testing.T) {
+	t.Parallel()
+
+	leaves := makeTestLeaves(8, 0)
+	targets := []uint64{5, 0, 7, 2, 6, 1}
+	hashes := make([]Hash, len(targets))
+	for i, target := range targets {
+		hashes[i] = leaves[target].Hash
+	}
+
+	sortedTargets, sortedHashes, err := SortTargetsWithHashes(targets, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{0, 1, 2, 5, 6, 7}
+	if !reflect.DeepEqual(expected, sortedTargets) {
+		t.Fatalf("expected targets %v but got %v", expected, sortedTargets)
+	}
+	for i, target := range sortedTargets {
+		if sortedHashes[i] != leaves[target].Hash {
+			t.Fatalf("hash at index %d isn't paired with target %d", i, target)
+		}
+	}
+
+	// The passed in slices aren't modified.
+	if targets[0] != 5 || hashes[0] != leaves[5].Hash {
+		t.Fatalf("passed in slices were modified")
+	}
+
+	_, _, err = SortTargetsWithHashes(targets, hashes[1:])
+	if err == nil {
+		t.Fatalf("expected an error for mismatched lengths")
+	}
+	_, _, err = SortTargetsWithHashes([]uint64{3, 1, 3}, hashes[:3])
+	if err == nil {
+		t.Fatalf("expected an error for duplicate targets")
+	}
+}
+
+func TestVerifyRangeProof(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(40, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startRoots, startNumLeaves := p.GetRoots(), p.NumLeaves
+
+	// The deletions of each of the 5 blocks in the range.
+	blockDels := [][]Hash{
+		{leaves[0].Hash, leaves[17].Hash},
+		{leaves[5].Hash},
+		{leaves[33].Hash, leaves[34].Hash, leaves[2].Hash},
+		{leaves[21].Hash},
+		{leaves[39].Hash, leaves[11].Hash},
+	}
+
+	// The range proof is made against the state at the start of the range.
+	rangeDels := make([]Hash, 0, 9)
+	for _, dels := range blockDels {
+		rangeDels = append(rangeDels, dels...)
+	}
+	rangeProof, err := p.Prove(rangeDels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, dels := range blockDels {
+		err = deleteHashes(&p, dels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Modify(makeTestLeaves(3, 40+i*3), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = p.VerifyRangeProof(rangeDels, rangeProof, startRoots, startNumLeaves)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The range proof doesn't verify against the current roots.
+	err = p.VerifyRangeProof(rangeDels, rangeProof, p.GetRoots(), startNumLeaves)
+	if err == nil {
+		t.Fatalf("expected an error verifying the range proof against the current roots")
+	}
+
+	// Tampered proofs don't verify.
+	tampered := Proof{Targets: rangeProof.Targets, Proof: make([]Hash, len(rangeProof.Proof))}
+	copy(tampered.Proof, rangeProof.Proof)
+	tampered.Proof[0][0] ^= 0xff
+	err = p.VerifyRangeProof(rangeDels, tampered, startRoots, startNumLeaves)
+	if err == nil {
+		t.Fatalf("expected an error for a tampered range proof")
+	}
+
+	// The range can't start after the current state.
+	err = p.VerifyRangeProof(rangeDels, rangeProof, startRoots, p.NumLeaves+1)
+	if err == nil {
+		t.Fatalf("expected an error for a range starting after the current state")
+	}
+}
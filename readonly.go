@@ -0,0 +1,72 @@
+package utreexo
+
+// ReadOnlyPollard is a view of a Pollard that only exposes the methods that don't modify
+// the accumulator. It's meant for code that should only be able to query the pollard.
+//
+// NOTE The view doesn't copy the pollard so modifications done through the Pollard are
+// seen by the view. Prove still updates the Metrics of the pollard.
+type ReadOnlyPollard struct {
+	p *Pollard
+}
+
+// ReadOnly returns a read-only view of the pollard.
+func (p *Pollard) ReadOnly() *ReadOnlyPollard {
+	return &ReadOnlyPollard{p: p}
+}
+
+// Prove returns a proof for the given hashes. It's the same as Pollard.Prove.
+func (r *ReadOnlyPollard) Prove(hashes []Hash) (Proof, error) {
+	return r.p.Prove(hashes)
+}
+
+// Verify returns an error if the hashes and the proof don't hash up to the roots of the
+// pollard. It's the same as Pollard.Verify with remember set to false.
+func (r *ReadOnlyPollard) Verify(delHashes []Hash, proof Proof) error {
+	return r.p.Verify(delHashes, proof, false)
+}
+
+// VerifyOne verifies a proof for exactly one target. It's the same as Pollard.VerifyOne.
+func (r *ReadOnlyPollard) VerifyOne(delHash Hash, proof Proof) error {
+	return r.p.VerifyOne(delHash, proof)
+}
+
+// GetRoots returns the current roots of the accumulator.
+func (r *ReadOnlyPollard) GetRoots() []Hash {
+	return r.p.GetRoots()
+}
+
+// GetHash returns the hash at the given position. Returns an empty hash if the position
+// either doesn't exist or isn't cached.
+func (r *ReadOnlyPollard) GetHash(pos uint64) Hash {
+	return r.p.GetHash(pos)
+}
+
+// GetNumLeaves returns the total number of leaves added to the accumulator.
+func (r *ReadOnlyPollard) GetNumLeaves() uint64 {
+	return r.p.GetNumLeaves()
+}
+
+// GetTreeRows returns the tree rows that are allocated for this accumulator.
+func (r *ReadOnlyPollard) GetTreeRows() uint8 {
+	return r.p.GetTreeRows()
+}
+
+// Height returns the number of modifies applied to the accumulator minus the undos.
+func (r *ReadOnlyPollard) Height() uint64 {
+	return r.p.Height()
+}
+
+// StateHash returns a commitment to the numLeaves and the roots of the accumulator.
+func (r *ReadOnlyPollard) StateHash() Hash {
+	return r.p.StateHash()
+}
+
+// CachedLeaves returns the hashes of all the cached leaves sorted by their positions.
+func (r *ReadOnlyPollard) CachedLeaves() []Hash {
+	return r.p.CachedLeaves()
+}
+
+// String returns a string representation of the accumulator.
+func (r *ReadOnlyPollard) String() string {
+	return r.p.String()
+}
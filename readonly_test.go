@@ -0,0 +1,89 @@
+package utreexo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadOnlyPollard(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(15, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteHashes(&p, []Hash{leaves[4].Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beforeStr := p.String()
+	beforeRoots := p.GetRoots()
+	beforeEpoch := p.Epoch()
+	beforeMap := make(map[miniHash]*polNode, len(p.NodeMap))
+	for k, v := range p.NodeMap {
+		beforeMap[k] = v
+	}
+
+	ro := p.ReadOnly()
+
+	// None of the methods that modify the accumulator are exposed.
+	for _, name := range []string{"Modify", "Undo", "ModifyMany", "PruneToSize"} {
+		if _, found := reflect.TypeOf(ro).MethodByName(name); found {
+			t.Fatalf("read-only pollard exposes %s", name)
+		}
+	}
+
+	if !reflect.DeepEqual(beforeRoots, ro.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(beforeRoots), printHashes(ro.GetRoots()))
+	}
+	if ro.GetNumLeaves() != p.NumLeaves || ro.GetTreeRows() != p.GetTreeRows() ||
+		ro.Height() != p.Height() || ro.StateHash() != p.StateHash() {
+		t.Fatalf("read-only pollard doesn't match the pollard")
+	}
+	if !reflect.DeepEqual(p.CachedLeaves(), ro.CachedLeaves()) {
+		t.Fatalf("expected cached leaves %v but got %v", p.CachedLeaves(), ro.CachedLeaves())
+	}
+	if ro.GetHash(3) != leaves[3].Hash || ro.String() != beforeStr {
+		t.Fatalf("read-only pollard doesn't match the pollard")
+	}
+
+	delHashes := []Hash{leaves[1].Hash, leaves[12].Hash}
+	proof, err := ro.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ro.Verify(delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := ro.Prove(delHashes[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ro.VerifyOne(delHashes[0], single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ro.Verify([]Hash{leaves[2].Hash, leaves[12].Hash}, proof)
+	if err == nil {
+		t.Fatalf("expected an error verifying the wrong hashes")
+	}
+
+	// The pollard is unaffected.
+	if beforeStr != p.String() || !reflect.DeepEqual(beforeRoots, p.GetRoots()) ||
+		beforeEpoch != p.Epoch() {
+		t.Fatalf("pollard changed after using the read-only pollard")
+	}
+	err = compareNodeMap(beforeMap, p.NodeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.checkHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
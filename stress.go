@@ -0,0 +1,42 @@
+package utreexo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// deterministicBatch is the number of leaves BuildDeterministicForest adds per modify.
+const deterministicBatch = 1 << 16
+
+// BuildDeterministicForest returns a full pollard with the given number of leaves. The
+// leaf at index i is the little endian i followed by zeros with the last byte set to 0xFF,
+// the same leaves the tests use. The same number of leaves always results in the same
+// roots.
+func BuildDeterministicForest(leaves uint64) *Pollard {
+	p := NewAccumulator(true)
+
+	batch := uint64(deterministicBatch)
+	if leaves < batch {
+		batch = leaves
+	}
+	adds := make([]Leaf, 0, batch)
+	for i := uint64(0); i < leaves; i++ {
+		var hash Hash
+		binary.LittleEndian.PutUint64(hash[:], i)
+
+		// Add FF at the end as you can't add an empty leaf to the accumulator.
+		hash[31] = 0xFF
+		adds = append(adds, Leaf{Hash: hash})
+
+		if len(adds) == deterministicBatch || i == leaves-1 {
+			// The leaves are all unique so the modify can't fail.
+			err := p.Modify(adds, nil, Proof{})
+			if err != nil {
+				panic(fmt.Sprintf("BuildDeterministicForest fail. %v", err))
+			}
+			adds = adds[:0]
+		}
+	}
+
+	return &p
+}
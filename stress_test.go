@@ -0,0 +1,181 @@
+package utreexo
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// StressResult is the result of a StressTest run.
+type StressResult struct {
+	// Blocks is the number of blocks that were processed.
+	Blocks int
+	// TotalAdds is the number of leaves that were added.
+	TotalAdds int
+	// TotalDels is the number of leaves that were deleted.
+	TotalDels int
+
+	// Duration is how long the entire run took.
+	Duration time.Duration
+	// ProveDuration is how long was spent proving the deletions.
+	ProveDuration time.Duration
+	// ModifyDuration is how long was spent modifying the accumulator.
+	ModifyDuration time.Duration
+
+	// TotalAllocBytes is the number of bytes allocated during the run.
+	TotalAllocBytes uint64
+	// HeapAllocBytes is the number of bytes of heap in use at the end of the run.
+	HeapAllocBytes uint64
+
+	// NumLeaves is the numLeaves of the accumulator at the end of the run.
+	NumLeaves uint64
+	// FinalRoots are the roots of the accumulator at the end of the run.
+	FinalRoots []Hash
+}
+
+// StressTest runs a deterministic workload of the given number of blocks on a full
+// pollard and returns the timing and memory stats of the run. Each block deletes
+// delsPerBlock random leaves, or all the leaves if there are fewer, and then adds
+// addsPerBlock leaves. The same arguments always result in the same final roots.
+func StressTest(blocks, addsPerBlock, delsPerBlock int, seed int64) (StressResult, error) {
+	if blocks < 0 || addsPerBlock < 0 || delsPerBlock < 0 {
+		return StressResult{}, fmt.Errorf("StressTest fail. Got negative arguments "+
+			"blocks %d, addsPerBlock %d, delsPerBlock %d", blocks, addsPerBlock, delsPerBlock)
+	}
+
+	// A duration of 0 makes the leaves live until they're picked for deletion.
+	sc := newSimChainWithSeed(0, seed)
+	p := NewAccumulator(true)
+
+	var result StressResult
+	var startStats runtime.MemStats
+	runtime.ReadMemStats(&startStats)
+	start := time.Now()
+
+	for b := 0; b < blocks; b++ {
+		adds, delHashes := sc.NextBlockWithDels(uint32(addsPerBlock), uint32(delsPerBlock))
+
+		proveStart := time.Now()
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			return StressResult{}, fmt.Errorf("StressTest fail at block %d. %v", b, err)
+		}
+		result.ProveDuration += time.Since(proveStart)
+
+		modifyStart := time.Now()
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			return StressResult{}, fmt.Errorf("StressTest fail at block %d. %v", b, err)
+		}
+		result.ModifyDuration += time.Since(modifyStart)
+
+		result.Blocks++
+		result.TotalAdds += len(adds)
+		result.TotalDels += len(delHashes)
+	}
+
+	result.Duration = time.Since(start)
+	var endStats runtime.MemStats
+	runtime.ReadMemStats(&endStats)
+	result.TotalAllocBytes = endStats.TotalAlloc - startStats.TotalAlloc
+	result.HeapAllocBytes = endStats.HeapAlloc
+
+	result.NumLeaves = p.NumLeaves
+	result.FinalRoots = p.GetRoots()
+
+	return result, nil
+}
+
+func TestStressTest(t *testing.T) {
+	t.Parallel()
+
+	result, err := StressTest(20, 10, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Blocks != 20 {
+		t.Fatalf("expected 20 blocks but got %d", result.Blocks)
+	}
+	if result.TotalAdds != 200 {
+		t.Fatalf("expected 200 adds but got %d", result.TotalAdds)
+	}
+	// The first block has nothing to delete.
+	if result.TotalDels != 76 {
+		t.Fatalf("expected 76 dels but got %d", result.TotalDels)
+	}
+	if result.NumLeaves != uint64(result.TotalAdds) {
+		t.Fatalf("expected %d leaves but got %d", result.TotalAdds, result.NumLeaves)
+	}
+	if result.Duration <= 0 || result.ModifyDuration <= 0 || result.ProveDuration <= 0 {
+		t.Fatalf("expected non-zero durations but got %v, %v, %v",
+			result.Duration, result.ModifyDuration, result.ProveDuration)
+	}
+	if result.ModifyDuration+result.ProveDuration > result.Duration {
+		t.Fatalf("modify %v and prove %v durations are greater than the total %v",
+			result.ModifyDuration, result.ProveDuration, result.Duration)
+	}
+	if result.TotalAllocBytes == 0 || result.HeapAllocBytes == 0 {
+		t.Fatalf("expected non-zero memory stats")
+	}
+	if len(result.FinalRoots) != int(numRoots(result.NumLeaves)) {
+		t.Fatalf("expected %d roots but got %d",
+			numRoots(result.NumLeaves), len(result.FinalRoots))
+	}
+
+	// The same arguments must result in the same roots.
+	again, err := StressTest(20, 10, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.FinalRoots, again.FinalRoots) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(result.FinalRoots), printHashes(again.FinalRoots))
+	}
+
+	// A different seed deletes different leaves.
+	other, err := StressTest(20, 10, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(result.FinalRoots, other.FinalRoots) {
+		t.Fatalf("expected different roots for a different seed")
+	}
+
+	_, err = StressTest(-1, 1, 1, 0)
+	if err == nil {
+		t.Fatalf("expected an error for negative blocks")
+	}
+}
+
+func TestBuildDeterministicForest(t *testing.T) {
+	t.Parallel()
+
+	p := BuildDeterministicForest(1000)
+	if p.NumLeaves != 1000 {
+		t.Fatalf("expected 1000 leaves but got %d", p.NumLeaves)
+	}
+	again := BuildDeterministicForest(1000)
+	if !reflect.DeepEqual(p.GetRoots(), again.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(p.GetRoots()), printHashes(again.GetRoots()))
+	}
+
+	// The leaves are the same as the ones from getAddsAndDels.
+	expected := NewAccumulator(true)
+	adds, _, _ := getAddsAndDels(0, 1000, 0)
+	err := expected.Modify(adds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected.GetRoots(), p.GetRoots()) {
+		t.Fatalf("expected roots:\n%s\ngot:\n%s",
+			printHashes(expected.GetRoots()), printHashes(p.GetRoots()))
+	}
+
+	if none := BuildDeterministicForest(0); none.NumLeaves != 0 {
+		t.Fatalf("expected 0 leaves but got %d", none.NumLeaves)
+	}
+}
@@ -1,6 +1,8 @@
 package utreexo
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 )
@@ -15,6 +17,140 @@ type Stump struct {
 	NumLeaves uint64
 }
 
+// VerifyCache caches successful verifications so that proofs for the same leaves
+// don't have to be re-hashed when they're verified multiple times against the same
+// stump. The cache only holds the results for one state of the stump. All the results
+// are dropped once a stump with different roots or numLeaves is verified against, like
+// after the stump is updated. The oldest result is evicted once the cache is full.
+type VerifyCache struct {
+	// Hits is the number of verifications that were served from the cache.
+	Hits uint64
+	// Misses is the number of verifications that weren't in the cache.
+	Misses uint64
+
+	maxEntries int
+	results    map[Hash][]int
+
+	// state is the hash of the roots and the numLeaves of the stump the results are for.
+	state Hash
+
+	// keys are the keys of the results in the order they were added. next is the
+	// index of the oldest key once the cache is full.
+	keys []Hash
+	next int
+}
+
+// NewVerifyCache returns an empty, initialized VerifyCache that holds at most
+// maxEntries results. A maxEntries less than 1 is treated as 1.
+func NewVerifyCache(maxEntries int) *VerifyCache {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &VerifyCache{
+		maxEntries: maxEntries,
+		results:    make(map[Hash][]int, maxEntries),
+		keys:       make([]Hash, 0, maxEntries),
+	}
+}
+
+// Len returns the number of results in the cache.
+func (c *VerifyCache) Len() int {
+	return len(c.results)
+}
+
+// reset drops all the results and sets the stump state the cache holds results for.
+func (c *VerifyCache) reset(state Hash) {
+	c.state = state
+	c.results = make(map[Hash][]int, c.maxEntries)
+	c.keys = c.keys[:0]
+	c.next = 0
+}
+
+// put adds the result to the cache and evicts the oldest result if the cache is full.
+func (c *VerifyCache) put(key Hash, rootIndexes []int) {
+	if len(c.keys) < c.maxEntries {
+		c.keys = append(c.keys, key)
+	} else {
+		delete(c.results, c.keys[c.next])
+		c.keys[c.next] = key
+		c.next = (c.next + 1) % c.maxEntries
+	}
+	c.results[key] = append([]int(nil), rootIndexes...)
+}
+
+// Verify verifies the proof passed in against the stump like Verify does. Successful
+// verifications are cached and repeated verifications of the same hashes and proof
+// against the same stump are served from the cache. The cached results are dropped if
+// the stump is different from the one they were verified against.
+func (c *VerifyCache) Verify(stump Stump, delHashes []Hash, proof Proof) ([]int, error) {
+	state := stumpStateKey(&stump)
+	if state != c.state {
+		c.reset(state)
+	}
+
+	key := verifyCacheKey(delHashes, proof)
+	rootIndexes, found := c.results[key]
+	if found {
+		c.Hits++
+		return append([]int(nil), rootIndexes...), nil
+	}
+	c.Misses++
+
+	rootIndexes, err := Verify(stump, delHashes, proof)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, rootIndexes)
+
+	return rootIndexes, nil
+}
+
+// stumpStateKey returns the hash of the roots and the numLeaves of the stump.
+func stumpStateKey(stump *Stump) Hash {
+	var buf [8]byte
+	h := sha256.New()
+
+	binary.LittleEndian.PutUint64(buf[:], stump.NumLeaves)
+	h.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(stump.Roots)))
+	h.Write(buf[:])
+	for _, root := range stump.Roots {
+		h.Write(root[:])
+	}
+
+	var key Hash
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// verifyCacheKey returns the key for a verification of the given hashes and proof.
+// The state of the stump isn't part of the key as the cache only holds the results for
+// one state.
+func verifyCacheKey(delHashes []Hash, proof Proof) Hash {
+	var buf [8]byte
+	h := sha256.New()
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(delHashes)))
+	h.Write(buf[:])
+	for _, delHash := range delHashes {
+		h.Write(delHash[:])
+	}
+
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(proof.Targets)))
+	h.Write(buf[:])
+	for _, target := range proof.Targets {
+		binary.LittleEndian.PutUint64(buf[:], target)
+		h.Write(buf[:])
+	}
+	for _, proofHash := range proof.Proof {
+		h.Write(proofHash[:])
+	}
+
+	var key Hash
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
 // UpdateData is all the data needed from the stump to update a cached proof.
 type UpdateData struct {
 	// ToDestroy is the positions of the empty roots removed after the add.
@@ -70,14 +206,35 @@ func (s *Stump) Update(delHashes, addHashes []Hash, proof Proof) (UpdateData, er
 
 // Verify verifies the proof passed in against the passed in stump. The returned ints
 // are the indexes of the roots that were matched with the roots calculated from
-// the proof.
+// the proof. Proofs with empty proof hashes are rejected with ErrZeroProofHash and
+// proofs with targets outside of the forest are rejected with ErrTargetOutOfRange.
 func Verify(stump Stump, delHashes []Hash, proof Proof) ([]int, error) {
+	return VerifyWithHasher(stump, delHashes, proof, parentHash)
+}
+
+// VerifyWithHasher is Verify but all the parent hashes are calculated with the given
+// hasher instead of the default hash function. It's useful for verifying proofs
+// against an accumulator that uses a different hash function. The default hash
+// function is used if the hasher is nil.
+func VerifyWithHasher(stump Stump, delHashes []Hash, proof Proof, hasher Hasher) ([]int, error) {
+	if hasher == nil {
+		hasher = parentHash
+	}
+
 	if len(delHashes) != len(proof.Targets) {
 		return nil, fmt.Errorf("Verify fail. Was given %d targets but got %d "+
 			"hashes for those targets", len(proof.Targets), len(delHashes))
 	}
+	err := checkProofHashes(proof)
+	if err != nil {
+		return nil, fmt.Errorf("Verify fail. %w", err)
+	}
+	err = checkTargetsInForest(proof.Targets, stump.NumLeaves)
+	if err != nil {
+		return nil, fmt.Errorf("Verify fail. %w", err)
+	}
 
-	_, rootCandidates := calculateHashes(stump.NumLeaves, delHashes, proof)
+	_, rootCandidates := calculateHashesWithHasher(stump.NumLeaves, delHashes, proof, hasher)
 	rootIndexes := make([]int, 0, len(rootCandidates))
 	for i := range stump.Roots {
 		if len(rootCandidates) > len(rootIndexes) &&
@@ -98,6 +255,78 @@ func Verify(stump Stump, delHashes []Hash, proof Proof) ([]int, error) {
 	return rootIndexes, nil
 }
 
+// VerifyEmpty checks that the roots and the numLeaves are of an accumulator that never
+// had any leaves added to it. An accumulator that had all of its leaves deleted still
+// has its numLeaves and empty roots so it's not considered empty here.
+func VerifyEmpty(roots []Hash, numLeaves uint64) error {
+	if numLeaves != 0 {
+		return fmt.Errorf("VerifyEmpty fail. Expected 0 leaves but got %d", numLeaves)
+	}
+	if len(roots) != 0 {
+		return fmt.Errorf("VerifyEmpty fail. Expected no roots but got %d", len(roots))
+	}
+
+	return nil
+}
+
+// ValidateRootShape checks that the given roots are consistent with the shape of a
+// forest with numLeaves. The number of roots must equal the number of trees in the
+// forest and none of the roots may be the empty hash.
+//
+// NOTE This is only a cheap precondition check and it does not check that the roots
+// actually commit to any leaves.
+func ValidateRootShape(roots []Hash, numLeaves uint64) error {
+	if len(roots) != int(numRoots(numLeaves)) {
+		return fmt.Errorf("ValidateRootShape fail. Expected %d roots for "+
+			"%d leaves but got %d", numRoots(numLeaves), numLeaves, len(roots))
+	}
+
+	for i, root := range roots {
+		if root == empty {
+			return fmt.Errorf("ValidateRootShape fail. Root at index %d "+
+				"is an empty hash", i)
+		}
+	}
+
+	return nil
+}
+
+// VerifyNoDeletions checks that the new roots and numLeaves are what's left after adding
+// the adds to the old roots and numLeaves without deleting anything. It's cheaper than
+// verifying a full transition since no proof is needed for an adds-only block.
+func VerifyNoDeletions(oldRoots, newRoots []Hash, oldNumLeaves, newNumLeaves uint64, adds []Leaf) error {
+	if oldNumLeaves+uint64(len(adds)) != newNumLeaves {
+		return fmt.Errorf("VerifyNoDeletions fail. Expected %d leaves after adding "+
+			"%d leaves to %d but got %d", oldNumLeaves+uint64(len(adds)),
+			len(adds), oldNumLeaves, newNumLeaves)
+	}
+
+	stump := Stump{Roots: make([]Hash, len(oldRoots)), NumLeaves: oldNumLeaves}
+	copy(stump.Roots, oldRoots)
+
+	addHashes := make([]Hash, len(adds))
+	for i, add := range adds {
+		addHashes[i] = add.Hash
+	}
+	_, err := stump.Update(nil, addHashes, Proof{})
+	if err != nil {
+		return fmt.Errorf("VerifyNoDeletions fail. %w", err)
+	}
+
+	if len(stump.Roots) != len(newRoots) {
+		return fmt.Errorf("VerifyNoDeletions fail. Expected %d roots but got %d",
+			len(stump.Roots), len(newRoots))
+	}
+	for i := range stump.Roots {
+		if stump.Roots[i] != newRoots[i] {
+			return fmt.Errorf("VerifyNoDeletions fail. Root %d is %s but "+
+				"expected %s", i, newRoots[i], stump.Roots[i])
+		}
+	}
+
+	return nil
+}
+
 // del verifies that the passed in proof is correct. Then it calculates the
 // modified roots effected by the deletion and updates the roots of the stump
 // accordingly. The returned hashes represents the new hashes at their old positions.
@@ -1,6 +1,7 @@
 package utreexo
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"math/rand"
@@ -327,3 +328,306 @@ func checkUpdateData(updateData UpdateData, adds, delHashes, prevRoots []Hash, p
 
 	return nil
 }
+
+func TestValidateRootShape(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := make([]Leaf, 13)
+	for i := range leaves {
+		leaves[i] = Leaf{Hash: Hash{uint8(i + 1)}}
+	}
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := p.GetRoots()
+
+	tests := []struct {
+		name      string
+		roots     []Hash
+		numLeaves uint64
+		expectErr bool
+	}{
+		{"valid", roots, p.NumLeaves, false},
+		{"no leaves", nil, 0, false},
+		{"too few roots", roots[1:], p.NumLeaves, true},
+		{"too many roots", append([]Hash{{1}}, roots...), p.NumLeaves, true},
+		{"wrong numleaves", roots, p.NumLeaves + 3, true},
+		{"empty root", []Hash{roots[0], roots[1], {}}, p.NumLeaves, true},
+	}
+
+	for _, test := range tests {
+		err := ValidateRootShape(test.roots, test.numLeaves)
+		if test.expectErr && err == nil {
+			t.Fatalf("%s: expected an error but got nil", test.name)
+		}
+		if !test.expectErr && err != nil {
+			t.Fatalf("%s: expected no error but got %v", test.name, err)
+		}
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(7, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewVerifyCache(2)
+	stump := Stump{p.GetRoots(), p.NumLeaves}
+	checkCounts := func(hits, misses uint64) {
+		t.Helper()
+		if cache.Hits != hits || cache.Misses != misses {
+			t.Fatalf("expected %d hits and %d misses but got %d hits and %d misses",
+				hits, misses, cache.Hits, cache.Misses)
+		}
+	}
+
+	delHashes := []Hash{leaves[3].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First verification is a miss and the second one is a hit.
+	expected, err := cache.Verify(stump, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(0, 1)
+	got, err := cache.Verify(stump, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(1, 1)
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected root indexes %v but got %v", expected, got)
+	}
+
+	// Invalid proofs are never cached.
+	badProof := Proof{Targets: proof.Targets, Proof: append([]Hash(nil), proof.Proof...)}
+	badProof.Proof[0][0] ^= 0xff
+	for i := 0; i < 2; i++ {
+		_, err = cache.Verify(stump, delHashes, badProof)
+		if err == nil {
+			t.Fatalf("expected an error for an invalid proof")
+		}
+	}
+	checkCounts(1, 3)
+
+	// Verifying against the updated stump drops the results for the old one.
+	oldStump := Stump{append([]Hash(nil), stump.Roots...), stump.NumLeaves}
+	adds := makeTestLeaves(1, len(leaves))
+	_, err = stump.Update(nil, []Hash{adds[0].Hash}, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(adds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newProof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.Verify(stump, delHashes, newProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(1, 4)
+	if cache.Len() != 1 {
+		t.Fatalf("expected 1 cached result but got %d", cache.Len())
+	}
+	_, err = cache.Verify(stump, delHashes, newProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(2, 4)
+
+	// A third result evicts the oldest one.
+	otherHashes := []Hash{leaves[5].Hash}
+	otherProof, err := p.Prove(otherHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.Verify(stump, otherHashes, otherProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(2, 5)
+	thirdHashes := []Hash{leaves[1].Hash}
+	thirdProof, err := p.Prove(thirdHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cache.Verify(stump, thirdHashes, thirdProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(2, 6)
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 cached results but got %d", cache.Len())
+	}
+	_, err = cache.Verify(stump, thirdHashes, thirdProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(3, 6)
+	_, err = cache.Verify(stump, delHashes, newProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(3, 7)
+
+	// Going back to the old stump drops the results for the updated one.
+	_, err = cache.Verify(oldStump, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(3, 8)
+	if cache.Len() != 1 {
+		t.Fatalf("expected 1 cached result but got %d", cache.Len())
+	}
+}
+
+func TestVerifyWithHasher(t *testing.T) {
+	t.Parallel()
+
+	sha256Hasher := func(l, r Hash) Hash {
+		return sha256.Sum256(append(l[:], r[:]...))
+	}
+
+	// Build the tree of 8 leaves by hand with the sha256 hasher.
+	//
+	// 14
+	// |---------------\
+	// 12              13
+	// |-------\       |-------\
+	// 08      09      10      11
+	// |---\   |---\   |---\   |---\
+	// 00  01  02  03  04  05  06  07
+	leaves := makeTestLeaves(8, 0)
+	row1 := make([]Hash, 4)
+	for i := range row1 {
+		row1[i] = sha256Hasher(leaves[i*2].Hash, leaves[i*2+1].Hash)
+	}
+	row2 := []Hash{sha256Hasher(row1[0], row1[1]), sha256Hasher(row1[2], row1[3])}
+	root := sha256Hasher(row2[0], row2[1])
+
+	stump := Stump{Roots: []Hash{root}, NumLeaves: 8}
+	delHashes := []Hash{leaves[2].Hash}
+	proof := Proof{Targets: []uint64{2}, Proof: []Hash{leaves[3].Hash, row1[0], row2[1]}}
+
+	_, err := VerifyWithHasher(stump, delHashes, proof, sha256Hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default hash function must fail for the proof.
+	_, err = Verify(stump, delHashes, proof)
+	if err == nil {
+		t.Fatalf("expected the default hasher to fail verification")
+	}
+	_, err = VerifyWithHasher(stump, delHashes, proof, nil)
+	if err == nil {
+		t.Fatalf("expected the default hasher to fail verification")
+	}
+
+	// And the default hash function still works for proofs made by the pollard.
+	p := NewAccumulator(true)
+	err = p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err = p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stump = Stump{Roots: p.GetRoots(), NumLeaves: p.NumLeaves}
+	_, err = VerifyWithHasher(stump, delHashes, proof, parentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyWithHasher(stump, delHashes, proof, sha256Hasher)
+	if err == nil {
+		t.Fatalf("expected the sha256 hasher to fail verification")
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	t.Parallel()
+
+	err := VerifyEmpty(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyEmpty([]Hash{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyEmpty(nil, 1)
+	if err == nil {
+		t.Fatalf("expected an error for a numLeaves of 1")
+	}
+	err = VerifyEmpty([]Hash{{1}}, 0)
+	if err == nil {
+		t.Fatalf("expected an error for a root with 0 leaves")
+	}
+	err = VerifyEmpty([]Hash{empty}, 1)
+	if err == nil {
+		t.Fatalf("expected an error for an accumulator with its leaf deleted")
+	}
+}
+
+func TestVerifyNoDeletions(t *testing.T) {
+	t.Parallel()
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(13, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRoots, oldNumLeaves := p.GetRoots(), p.NumLeaves
+
+	// Adds only block.
+	adds := makeTestLeaves(6, 13)
+	err = p.Modify(adds, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyNoDeletions(oldRoots, p.GetRoots(), oldNumLeaves, p.NumLeaves, adds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRoots, oldNumLeaves = p.GetRoots(), p.NumLeaves
+
+	// Block that also deletes.
+	adds = makeTestLeaves(3, 19)
+	delHashes := []Hash{leaves[2].Hash, leaves[9].Hash}
+	proof, err := p.Prove(delHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Modify(adds, delHashes, proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyNoDeletions(oldRoots, p.GetRoots(), oldNumLeaves, p.NumLeaves, adds)
+	if err == nil {
+		t.Fatalf("expected an error for a block with deletions")
+	}
+
+	// Wrong numLeaves.
+	err = VerifyNoDeletions(oldRoots, p.GetRoots(), oldNumLeaves, p.NumLeaves+1, adds)
+	if err == nil {
+		t.Fatalf("expected an error for the wrong numLeaves")
+	}
+}
@@ -1,22 +1,118 @@
 package utreexo
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math"
 	"math/bits"
+	"runtime"
 	"sort"
+	"sync"
 
 	"golang.org/x/exp/slices"
 )
 
-// parentHash returns the hash of the left and right hashes passed in.
+// Hasher returns the parent hash of the left and right hashes passed in.
+type Hasher func(l, r Hash) Hash
+
+// parentHash returns the hash of the left and right hashes passed in. It's the
+// SHA-512/256 of the left hash followed by the right hash. The hashes are copied into a
+// buffer on the stack and hashed in one call instead of being written to a new hasher
+// as the hasher and its sum would be allocated on the heap for every parent hash.
 func parentHash(l, r Hash) Hash {
-	h := sha512.New512_256()
-	h.Write(l[:])
-	h.Write(r[:])
-	return *((*Hash)(h.Sum(nil)))
+	var buf [64]byte
+	copy(buf[:32], l[:])
+	copy(buf[32:], r[:])
+	return sha512.Sum512_256(buf[:])
+}
+
+// stateHash returns the commitment to an accumulator state with the given numLeaves
+// and roots. It's the sha256 of the numLeaves serialized as 8 little endian bytes
+// followed by all the roots.
+func stateHash(numLeaves uint64, roots []Hash) Hash {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], numLeaves)
+
+	h := sha256.New()
+	h.Write(buf[:])
+	for _, root := range roots {
+		h.Write(root[:])
+	}
+
+	var hash Hash
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// leafSetHash returns the sha256 of the given hashes concatenated in ascending order.
+// The order of the passed in hashes doesn't matter. The hashes are sorted in place.
+func leafSetHash(hashes []Hash) Hash {
+	slices.SortFunc(hashes, func(a, b Hash) bool {
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	h := sha256.New()
+	for _, hash := range hashes {
+		h.Write(hash[:])
+	}
+
+	var hash Hash
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// HashLeaf returns the hash of the data that is used as the leaf in the accumulator. The
+// leaf is the single sha256 of the data as is. No tag or prefix is added to the data and
+// the hash isn't applied twice. Implementations that tag their leaves should hash the
+// tagged data themselves and add the resulting hashes directly.
+func HashLeaf(data []byte) Hash {
+	return sha256.Sum256(data)
+}
+
+// HashLeavesParallel returns the HashLeaf of each of the preimages in the same order as
+// the preimages. The hashing is split up between the given number of goroutines. The
+// number of cpus is used if workers is less than 1.
+func HashLeavesParallel(preimages [][]byte, workers int) []Hash {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(preimages) {
+		workers = len(preimages)
+	}
+
+	hashes := make([]Hash, len(preimages))
+	if workers <= 1 {
+		for i, preimage := range preimages {
+			hashes[i] = HashLeaf(preimage)
+		}
+		return hashes
+	}
+
+	// Each worker hashes its own contiguous chunk so the results are written
+	// in order without any further synchronization.
+	chunkSize := (len(preimages) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(preimages); start += chunkSize {
+		end := start + chunkSize
+		if end > len(preimages) {
+			end = len(preimages)
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				hashes[i] = HashLeaf(preimages[i])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	return hashes
 }
 
 // leftChild gives you the position of the left child. The least significant
@@ -199,6 +295,51 @@ func isAncestor(higherPos, lowerPos uint64, forestRows uint8) bool {
 	return true
 }
 
+// LowestCommonAncestor returns the lowest position that both of the given positions are
+// either descendants of or equal to. The returned bool is false if the positions don't
+// share a tree within the forest of the given totalRows.
+//
+// NOTE Only the totalRows is known here so the positions are treated as if they're in a
+// perfect tree of totalRows. For a forest with multiple trees, the caller should check
+// that the returned position is not above the roots of the forest.
+//
+// 14
+// |---------------\
+// 12              13
+// |-------\       |-------\
+// 08      09      10      11
+// |---\   |---\   |---\   |---\
+// 00  01  02  03  04  05  06  07
+//
+// In the above tree, the lowest common ancestor of 00 and 03 is 12 and the lowest
+// common ancestor of 00 and 08 is 08.
+func LowestCommonAncestor(a, b uint64, totalRows uint8) (uint64, bool) {
+	if a >= maxPosition(totalRows) || b >= maxPosition(totalRows) {
+		return 0, false
+	}
+
+	// Bring both positions to the same row.
+	aRow := detectRow(a, totalRows)
+	bRow := detectRow(b, totalRows)
+	var err error
+	if aRow < bRow {
+		a, err = parentMany(a, bRow-aRow, totalRows)
+	} else if bRow < aRow {
+		b, err = parentMany(b, aRow-bRow, totalRows)
+	}
+	if err != nil {
+		return 0, false
+	}
+
+	// Go up until the two positions meet.
+	for a != b {
+		a = parent(a, totalRows)
+		b = parent(b, totalRows)
+	}
+
+	return a, true
+}
+
 // removeBit removes the nth bit from the val passed in. For example, if the 2nd
 // bit is to be removed from 1011 (11 in dec), the returned value is 111 (7 in dec).
 func removeBit(val, bit uint64) uint64 {
@@ -406,6 +547,53 @@ func treeRows(n uint64) uint8 {
 	return uint8(bits.Len64(n - 1))
 }
 
+// GrowthPoint is the shape of the accumulator after a block in SimulateGrowth.
+type GrowthPoint struct {
+	// NumLeaves is the total number of leaves ever added.
+	NumLeaves uint64
+
+	// LiveLeaves is the number of leaves that weren't deleted.
+	LiveLeaves uint64
+
+	// NumRoots is the number of roots including the empty roots.
+	NumRoots uint8
+
+	// TreeRows is the number of rows the accumulator has allocated for.
+	TreeRows uint8
+}
+
+// SimulateGrowth returns the shape of the accumulator after each of the blocks when
+// starting from startLeaves and adding and deleting the given amount of leaves every
+// block. The accumulator isn't built as the shape only depends on the number of leaves.
+//
+// Deletions never decrease the number of leaves as the deleted leaves still take up
+// their positions so they only change the LiveLeaves. The deletions in a block happen
+// before the additions and can't delete more leaves than there are live leaves.
+func SimulateGrowth(startLeaves uint64, addsPerBlock, delsPerBlock uint64,
+	blocks int) []GrowthPoint {
+
+	points := make([]GrowthPoint, 0, blocks)
+	numLeaves, liveLeaves := startLeaves, startLeaves
+	for b := 0; b < blocks; b++ {
+		if delsPerBlock > liveLeaves {
+			liveLeaves = 0
+		} else {
+			liveLeaves -= delsPerBlock
+		}
+		numLeaves += addsPerBlock
+		liveLeaves += addsPerBlock
+
+		points = append(points, GrowthPoint{
+			NumLeaves:  numLeaves,
+			LiveLeaves: liveLeaves,
+			NumRoots:   numRoots(numLeaves),
+			TreeRows:   treeRows(numLeaves),
+		})
+	}
+
+	return points
+}
+
 // numRoots returns the number of roots that exist with the given number of leaves.
 func numRoots(numLeaves uint64) uint8 {
 	return uint8(bits.OnesCount64(numLeaves))
@@ -433,6 +621,20 @@ func startPositionAtRow(row, forestRows uint8) uint64 {
 	return uint64(offset)
 }
 
+// MaxPositionForLeaves returns the highest valid position in a forest of numLeaves. It's
+// the position of the root of the biggest tree, which is the maxPositionAtRow of the top
+// row that has a root. 0 is returned for a forest with no leaves.
+func MaxPositionForLeaves(numLeaves uint64) uint64 {
+	if numLeaves == 0 {
+		return 0
+	}
+
+	// The biggest tree is on the row of the highest set bit of numLeaves.
+	topRow := uint8(bits.Len64(numLeaves) - 1)
+	max, _ := maxPositionAtRow(topRow, treeRows(numLeaves), numLeaves)
+	return max
+}
+
 // maxPossiblePosAtRow returns the biggest position an accumulator can have for the
 // given totalRows.
 func maxPossiblePosAtRow(row, totalRows uint8) uint64 {
@@ -1,6 +1,7 @@
 package utreexo
 
 import (
+	"crypto/sha512"
 	"fmt"
 	"math/rand"
 	"reflect"
@@ -410,3 +411,252 @@ func TestDeTwinRand(t *testing.T) {
 		}
 	}
 }
+
+func TestLowestCommonAncestor(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		a, b      uint64
+		totalRows uint8
+		expected  uint64
+		found     bool
+	}{
+		// 30
+		// |-------------------------------\
+		// 28                              29
+		// |---------------\               |---------------\
+		// 24              25              26              27
+		// |-------\       |-------\       |-------\       |-------\
+		// 16      17      18      19      20      21      22      23
+		// |---\   |---\   |---\   |---\   |---\   |---\   |---\   |---\
+		// 00  01  02  03  04  05  06  07  08  09  10  11  12  13  14  15
+		{a: 0, b: 1, totalRows: 4, expected: 16, found: true},
+		{a: 1, b: 0, totalRows: 4, expected: 16, found: true},
+		{a: 0, b: 3, totalRows: 4, expected: 24, found: true},
+		{a: 5, b: 6, totalRows: 4, expected: 25, found: true},
+		{a: 7, b: 8, totalRows: 4, expected: 30, found: true},
+		{a: 0, b: 15, totalRows: 4, expected: 30, found: true},
+		{a: 9, b: 9, totalRows: 4, expected: 9, found: true},
+		{a: 2, b: 24, totalRows: 4, expected: 24, found: true},
+		{a: 2, b: 25, totalRows: 4, expected: 28, found: true},
+		{a: 12, b: 21, totalRows: 4, expected: 29, found: true},
+		{a: 30, b: 0, totalRows: 4, expected: 30, found: true},
+
+		// Positions outside of the forest don't share a tree.
+		{a: 0, b: 31, totalRows: 4, expected: 0, found: false},
+		{a: 32, b: 1, totalRows: 4, expected: 0, found: false},
+		{a: 0, b: 7, totalRows: 2, expected: 0, found: false},
+	}
+
+	for _, test := range tests {
+		got, found := LowestCommonAncestor(test.a, test.b, test.totalRows)
+		if found != test.found {
+			t.Fatalf("LowestCommonAncestor(%d, %d, %d): expected found %v but got %v",
+				test.a, test.b, test.totalRows, test.found, found)
+		}
+		if got != test.expected {
+			t.Fatalf("LowestCommonAncestor(%d, %d, %d): expected %d but got %d",
+				test.a, test.b, test.totalRows, test.expected, got)
+		}
+	}
+}
+
+func TestSimulateGrowth(t *testing.T) {
+	t.Parallel()
+
+	const (
+		startLeaves  = 10
+		addsPerBlock = 7
+		delsPerBlock = 3
+		blocks       = 30
+	)
+	points := SimulateGrowth(startLeaves, addsPerBlock, delsPerBlock, blocks)
+	if len(points) != blocks {
+		t.Fatalf("expected %d points but got %d", blocks, len(points))
+	}
+
+	p := NewAccumulator(true)
+	leaves := makeTestLeaves(startLeaves, 0)
+	err := p.Modify(leaves, nil, Proof{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for b, point := range points {
+		// Delete the oldest live leaves.
+		delHashes := make([]Hash, delsPerBlock)
+		for i := range delHashes {
+			delHashes[i] = leaves[b*delsPerBlock+i].Hash
+		}
+		proof, err := p.Prove(delHashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adds := makeTestLeaves(addsPerBlock, len(leaves))
+		leaves = append(leaves, adds...)
+		err = p.Modify(adds, delHashes, proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := GrowthPoint{
+			NumLeaves:  p.NumLeaves,
+			LiveLeaves: p.NumLeaves - p.NumDels,
+			NumRoots:   uint8(len(p.Roots)),
+			TreeRows:   p.GetTreeRows(),
+		}
+		if point != expect {
+			t.Fatalf("block %d: expected %+v but got %+v", b, expect, point)
+		}
+	}
+
+	// Can't delete more leaves than there are.
+	points = SimulateGrowth(2, 1, 5, 2)
+	if points[0].LiveLeaves != 1 || points[1].LiveLeaves != 1 || points[1].NumLeaves != 4 {
+		t.Fatalf("unexpected points %+v", points)
+	}
+}
+
+func TestHashLeaf(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		data     []byte
+		expected string
+	}{
+		{
+			[]byte{},
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			[]byte("hello"),
+			"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+	}
+
+	for _, test := range tests {
+		got := HashLeaf(test.data)
+		if got.String() != test.expected {
+			t.Fatalf("for data %x, expected %s but got %s", test.data, test.expected, got)
+		}
+	}
+}
+
+func makePreimages(count int, seed int64) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+	preimages := make([][]byte, count)
+	for i := range preimages {
+		preimages[i] = make([]byte, rnd.Intn(100))
+		rnd.Read(preimages[i])
+	}
+
+	return preimages
+}
+
+func TestHashLeavesParallel(t *testing.T) {
+	t.Parallel()
+
+	preimages := makePreimages(1001, time.Now().UnixNano())
+	expected := make([]Hash, len(preimages))
+	for i, preimage := range preimages {
+		expected[i] = HashLeaf(preimage)
+	}
+
+	for _, workers := range []int{-1, 0, 1, 3, 8, 2000} {
+		got := HashLeavesParallel(preimages, workers)
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("with %d workers, hashes don't match the sequential hashes", workers)
+		}
+	}
+
+	if got := HashLeavesParallel(nil, 4); len(got) != 0 {
+		t.Fatalf("expected no hashes but got %d", len(got))
+	}
+}
+
+func BenchmarkHashLeavesParallel(b *testing.B) {
+	preimages := makePreimages(1_000_000, 1)
+
+	for _, workers := range []int{1, 0} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				HashLeavesParallel(preimages, workers)
+			}
+		})
+	}
+}
+
+func TestMaxPositionForLeaves(t *testing.T) {
+	t.Parallel()
+
+	if got := MaxPositionForLeaves(0); got != 0 {
+		t.Fatalf("expected 0 for no leaves but got %d", got)
+	}
+
+	p := NewAccumulator(true)
+	for numLeaves := uint64(1); numLeaves <= 70; numLeaves++ {
+		err := p.Modify(makeTestLeaves(1, int(numLeaves-1)), nil, Proof{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The highest position that getNode can read from.
+		forestRows := treeRows(numLeaves)
+		expected := uint64(0)
+		for pos := uint64(0); pos < maxPosition(forestRows); pos++ {
+			if !inForest(pos, numLeaves, forestRows) {
+				continue
+			}
+			n, _, _, err := p.getNode(pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != nil {
+				expected = pos
+			}
+		}
+
+		got := MaxPositionForLeaves(numLeaves)
+		if got != expected {
+			t.Fatalf("numLeaves %d: expected %d but got %d", numLeaves, expected, got)
+		}
+	}
+
+	// Check the bigger forests against the root positions.
+	for _, numLeaves := range []uint64{1 << 20, 1<<20 + 1, 1<<33 - 1, 123456789} {
+		forestRows := treeRows(numLeaves)
+		expected := RootPositions(numLeaves, forestRows)[0]
+		got := MaxPositionForLeaves(numLeaves)
+		if got != expected {
+			t.Fatalf("numLeaves %d: expected %d but got %d", numLeaves, expected, got)
+		}
+	}
+}
+
+func TestParentHash(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		var l, r Hash
+		rnd.Read(l[:])
+		rnd.Read(r[:])
+
+		h := sha512.New512_256()
+		h.Write(l[:])
+		h.Write(r[:])
+		var expected Hash
+		copy(expected[:], h.Sum(nil))
+
+		got := parentHash(l, r)
+		if got != expected {
+			t.Fatalf("expected %s but got %s", expected, got)
+		}
+	}
+
+	l, r := Hash{1}, Hash{2}
+	allocs := testing.AllocsPerRun(100, func() {
+		parentHash(l, r)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations but got %v", allocs)
+	}
+}